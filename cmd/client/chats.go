@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/url"
+
+	"chat-app/internal/models"
+)
+
+// chatPath is the API path of a chat
+func chatPath(chatID string) string {
+	return "/api/chats/" + url.PathEscape(chatID)
+}
+
+// updateChat applies req to a chat and describes the result
+func (c *Client) updateChat(chatID string, req models.UpdateChatRequest) {
+	var chat models.Chat
+	if err := c.call("PATCH", chatPath(chatID), req, &chat); err != nil {
+		c.println("Failed to update chat:", err)
+		return
+	}
+	switch {
+	case req.Name != "":
+		c.printf("Chat renamed to '%s'\n", chat.Name)
+	case req.Topic != nil && chat.Topic == "":
+		c.println("Topic cleared")
+	case req.Topic != nil:
+		c.printf("Topic set to '%s'\n", chat.Topic)
+	case chat.ArchivedAt != nil:
+		c.println("Chat archived; it is now read-only and hidden from /list")
+	default:
+		c.println("Chat restored")
+	}
+}
+
+// showChatDetails prints the topic of a chat and notes if it is archived or
+// under legal hold
+func (c *Client) showChatDetails(chat *models.Chat) {
+	if chat.Topic != "" {
+		c.printf("Topic: %s\n", chat.Topic)
+	}
+	if chat.ArchivedAt != nil {
+		c.printf("This chat was archived on %s and is read-only\n", chat.ArchivedAt.Format("2006-01-02"))
+	}
+	if chat.LegalHold {
+		c.println("This chat is under legal hold; its messages do not expire")
+	}
+}
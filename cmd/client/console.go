@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/term"
+)
+
+// errInterrupted is returned by ReadLine when the user presses Ctrl-C
+var errInterrupted = errors.New("interrupted")
+
+// console reads input lines while letting other goroutines print. On a
+// terminal the input line is edited in raw mode, so output arriving while the
+// user types is written above it and the status, prompt and partly typed
+// line are redrawn underneath.
+type console struct {
+	in     *bufio.Reader
+	out    io.Writer
+	fd     int
+	isTerm bool
+
+	mu      sync.Mutex
+	prompt  string
+	status  string
+	line    []rune
+	editing bool // the input line is on screen in raw mode
+}
+
+func newConsole(in, out *os.File) *console {
+	fd := int(in.Fd())
+	return &console{
+		in:     bufio.NewReader(in),
+		out:    out,
+		fd:     fd,
+		isTerm: term.IsTerminal(fd) && term.IsTerminal(int(out.Fd())),
+	}
+}
+
+// SetPrompt sets the prompt shown by the next ReadLine
+func (c *console) SetPrompt(prompt string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prompt = prompt
+	c.redraw()
+}
+
+// SetStatus sets the status shown in front of the prompt, such as the state
+// of the connection. An empty status hides it.
+func (c *console) SetStatus(status string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.status = status
+	c.redraw()
+}
+
+// Write prints p above the input line. It is safe to call from any
+// goroutine.
+func (c *console) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.editing {
+		return c.out.Write(p)
+	}
+	// Raw mode does not turn "\n" into "\r\n", and output must end on a
+	// fresh line so the input line can be redrawn below it
+	text := strings.ReplaceAll(string(p), "\n", "\r\n")
+	if !strings.HasSuffix(text, "\n") {
+		text += "\r\n"
+	}
+	if _, err := io.WriteString(c.out, "\r\x1b[K"+text); err != nil {
+		return 0, err
+	}
+	c.redraw()
+	return len(p), nil
+}
+
+// ReadLine shows the prompt and returns the next line typed by the user. It
+// returns io.EOF at the end of input and errInterrupted on Ctrl-C.
+func (c *console) ReadLine() (string, error) {
+	if !c.isTerm {
+		c.mu.Lock()
+		_, _ = io.WriteString(c.out, c.promptText())
+		c.mu.Unlock()
+		return c.readPlainLine()
+	}
+
+	state, err := term.MakeRaw(c.fd)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = term.Restore(c.fd, state)
+	}()
+
+	c.mu.Lock()
+	c.line = c.line[:0]
+	c.editing = true
+	c.redraw()
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		c.editing = false
+		_, _ = io.WriteString(c.out, "\r\n")
+		c.mu.Unlock()
+	}()
+
+	for {
+		r, _, err := c.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		c.mu.Lock()
+		switch {
+		case r == '\r' || r == '\n':
+			line := string(c.line)
+			c.mu.Unlock()
+			return line, nil
+		case r == 3: // Ctrl-C
+			c.mu.Unlock()
+			return "", errInterrupted
+		case r == 4: // Ctrl-D
+			if len(c.line) == 0 {
+				c.mu.Unlock()
+				return "", io.EOF
+			}
+		case r == 127 || r == '\b':
+			if len(c.line) > 0 {
+				c.line = c.line[:len(c.line)-1]
+				c.redraw()
+			}
+		case r == 21: // Ctrl-U
+			c.line = c.line[:0]
+			c.redraw()
+		case r == 27: // escape sequences such as arrow keys are ignored
+			c.mu.Unlock()
+			if err := c.skipEscape(); err != nil {
+				return "", err
+			}
+			continue
+		case unicode.IsPrint(r):
+			c.line = append(c.line, r)
+			_, _ = io.WriteString(c.out, string(r))
+		}
+		c.mu.Unlock()
+	}
+}
+
+// ReadPassword shows prompt and reads a line without echoing it when on a
+// terminal
+func (c *console) ReadPassword(prompt string) (string, error) {
+	c.mu.Lock()
+	_, _ = io.WriteString(c.out, prompt)
+	c.mu.Unlock()
+
+	if !c.isTerm {
+		return c.readPlainLine()
+	}
+	password, err := term.ReadPassword(c.fd)
+	_, _ = io.WriteString(c.out, "\n")
+	return string(password), err
+}
+
+// readPlainLine reads a line from input that is not a terminal
+func (c *console) readPlainLine() (string, error) {
+	line, err := c.in.ReadString('\n')
+	if err != nil && (line == "" || !errors.Is(err, io.EOF)) {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// skipEscape consumes the rest of an ANSI escape sequence
+func (c *console) skipEscape() error {
+	r, _, err := c.in.ReadRune()
+	if err != nil || (r != '[' && r != 'O') {
+		return err
+	}
+	for {
+		r, _, err := c.in.ReadRune()
+		if err != nil {
+			return err
+		}
+		// A control sequence ends with a byte in the range 0x40-0x7E
+		if r >= 0x40 && r <= 0x7e {
+			return nil
+		}
+	}
+}
+
+// promptText returns the status and prompt. The caller must hold c.mu.
+func (c *console) promptText() string {
+	if c.status == "" {
+		return c.prompt
+	}
+	return "[" + c.status + "] " + c.prompt
+}
+
+// redraw replaces the input line on screen. The caller must hold c.mu.
+func (c *console) redraw() {
+	if !c.editing {
+		return
+	}
+	_, _ = io.WriteString(c.out, "\r\x1b[K"+c.promptText()+string(c.line))
+}
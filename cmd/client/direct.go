@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+
+	"chat-app/internal/models"
+)
+
+// peer returns the other participant of a direct chat
+func (c *Client) peer(chat *models.Chat) string {
+	for _, participant := range chat.Participants {
+		if !strings.EqualFold(participant, c.username) {
+			return participant
+		}
+	}
+	return chat.Name
+}
+
+// directLabel is how the prompt shows a direct chat
+func (c *Client) directLabel(chat *models.Chat) string {
+	return "dm @" + c.peer(chat)
+}
+
+// openDirectChat switches to the direct chat with username, starting it if
+// needed
+func (c *Client) openDirectChat(username string) {
+	var chat models.Chat
+	if err := c.call("POST", "/api/dms", models.DirectChatRequest{Username: username}, &chat); err != nil {
+		c.println("Failed to open direct chat:", err)
+		return
+	}
+	c.joinChat(chat.ID, c.directLabel(&chat))
+}
+
+// listDirectChats prints the direct chats of the user, if any
+func (c *Client) listDirectChats() {
+	var chats []*models.Chat
+	if err := c.call("GET", "/api/dms", nil, &chats); err != nil {
+		c.println("Error fetching direct chats:", err)
+		return
+	}
+	if len(chats) == 0 {
+		return
+	}
+
+	c.println("Direct messages:")
+	for _, chat := range chats {
+		c.printf("  ID: %s | [dm] %s\n", chat.ID[:8], c.peer(chat))
+	}
+	c.println()
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"chat-app/internal/models"
+)
+
+// messagePath is the API path of one message of a chat
+func messagePath(chatID, messageID string) string {
+	return "/api/chats/" + url.PathEscape(chatID) + "/messages/" + url.PathEscape(messageID)
+}
+
+// resolveMessage finds the message ref names in a chat. Messages are shown
+// with their sequence number, so ref may be one such as "#12" or "12", or a
+// full message ID. Only the ID of the result is sure to be set.
+func (c *Client) resolveMessage(chatID, ref string) (*models.Message, error) {
+	seq, err := strconv.ParseInt(strings.TrimPrefix(ref, "#"), 10, 64)
+	if err != nil || seq < 1 {
+		return &models.Message{ID: ref}, nil
+	}
+	c.mu.Lock()
+	for id, known := range c.seqOf {
+		if known == seq && chatID == c.currentChat {
+			c.mu.Unlock()
+			return &models.Message{ID: id, Seq: seq}, nil
+		}
+	}
+	c.mu.Unlock()
+
+	params := url.Values{"since_seq": {strconv.FormatInt(seq-1, 10)}, "limit": {"1"}}
+	page, err := c.fetchMessages(context.Background(), chatID, params)
+	if err != nil {
+		return nil, err
+	}
+	if len(page.Messages) == 0 || page.Messages[0].Seq != seq {
+		return nil, errors.New("no message #" + strconv.FormatInt(seq, 10))
+	}
+	return page.Messages[0], nil
+}
+
+func (c *Client) editMessage(chatID, ref, content string) {
+	msg, err := c.resolveMessage(chatID, ref)
+	if err != nil {
+		c.println("Failed to edit message:", err)
+		return
+	}
+	var edited models.Message
+	req := models.EditMessageRequest{Content: content}
+	if err := c.call("PATCH", messagePath(chatID, msg.ID), req, &edited); err != nil {
+		c.println("Failed to edit message:", err)
+	}
+}
+
+func (c *Client) deleteMessage(chatID, ref string) {
+	msg, err := c.resolveMessage(chatID, ref)
+	if err != nil {
+		c.println("Failed to delete message:", err)
+		return
+	}
+	if err := c.call("DELETE", messagePath(chatID, msg.ID), nil, nil); err != nil {
+		c.println("Failed to delete message:", err)
+	}
+}
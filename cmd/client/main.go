@@ -1,55 +1,110 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 
 	"chat-app/internal/models"
 )
 
+// errChatNotFound is returned when the server does not know a chat
+var errChatNotFound = errors.New("chat not found")
+
 type Client struct {
-	serverURL   string
-	username    string
+	serverURL string
+	username  string
+	token     string // session token sent with every request
+	console   *console
+
+	// mu guards the fields below, which the stream goroutine shares
+	mu          sync.Mutex
 	currentChat string
-	reader      *bufio.Reader
+	chatLabel   string             // how the prompt shows currentChat
+	lastSeq     int64              // sequence number of the newest message seen in currentChat
+	stopFollow  context.CancelFunc // stops following currentChat
+	thread      *models.Message    // first message of the thread being viewed, if any
+	seqOf       map[string]int64   // message ID -> sequence number of messages seen in currentChat
 }
 
 func NewClient(serverURL, username string) *Client {
 	return &Client{
 		serverURL: serverURL,
 		username:  username,
-		reader:    bufio.NewReader(os.Stdin),
+		console:   newConsole(os.Stdin, os.Stdout),
 	}
 }
 
+// printf prints above the input line, so it is safe to use while the user
+// is typing
+func (c *Client) printf(format string, args ...any) {
+	_, _ = fmt.Fprintf(c.console, format, args...)
+}
+
+// println prints a line above the input line
+func (c *Client) println(args ...any) {
+	_, _ = fmt.Fprintln(c.console, args...)
+}
+
 func (c *Client) Run() {
-	fmt.Printf("Welcome to Chat App, %s!\n", c.username)
-	fmt.Println("Commands:")
-	fmt.Println("  /list        - List all chats")
-	fmt.Println("  /create NAME - Create a new chat")
-	fmt.Println("  /join ID     - Join a chat")
-	fmt.Println("  /refresh     - Refresh messages in current chat")
-	fmt.Println("  /quit        - Exit the application")
-	fmt.Println()
+	c.printf("Welcome to Chat App, %s!\n", c.username)
+	c.println("Commands:")
+	c.println("  /list                          - List all chats, most recently active first")
+	c.println("  /create [--private] NAME       - Create a new chat")
+	c.println("  /join ID                       - Join a chat")
+	c.println("  /refresh                       - Show new messages now")
+	c.println("  /members                       - List the members of the current chat")
+	c.println("  /rename NAME                   - Rename the current chat (admins)")
+	c.println("  /topic [TEXT]                  - Set or clear the topic of the current chat (admins)")
+	c.println("  /archive                       - Make the current chat read-only and unlisted (admins)")
+	c.println("  /unarchive                     - Restore an archived chat (admins)")
+	c.println("  /invite USER [ROLE]            - Add a user to the current chat (admins)")
+	c.println("  /invite --link [EXPIRY] [USES] - Create an invite token for the current chat")
+	c.println("  /edit MSG TEXT                 - Change one of your messages, e.g. /edit #3 Hello")
+	c.println("  /delete MSG                    - Delete a message")
+	c.println("  /thread [MSG]                  - View the thread of a message, or leave it")
+	c.println("  /reply MSG TEXT                - Reply to a message in its thread")
+	c.println("  /react MSG EMOJI               - React to a message, e.g. /react #3 :thumbsup:")
+	c.println("  /unreact MSG EMOJI             - Take back a reaction")
+	c.println("  /search TEXT                   - Find messages in all your chats")
+	c.println("  /dm USER                       - Open a direct chat with a user")
+	c.println("  /accept TOKEN                  - Join a chat with an invite token")
+	c.println("  /kick USER                     - Remove a user from the current chat (admins)")
+	c.println("  /promote USER [ROLE]           - Change a member's role, admin by default")
+	c.println("  /quit                          - Exit the application")
+	c.println()
 
 	for {
-		if c.currentChat != "" {
-			fmt.Printf("[%s] > ", c.currentChat)
+		c.mu.Lock()
+		chatID, label, thread := c.currentChat, c.chatLabel, c.thread
+		c.mu.Unlock()
+		if thread != nil {
+			label += fmt.Sprintf(" #%d", thread.Seq)
+		}
+		if chatID != "" {
+			c.console.SetPrompt(fmt.Sprintf("[%s] > ", label))
 		} else {
-			fmt.Print("> ")
+			c.console.SetPrompt("> ")
 		}
 
-		input, err := c.reader.ReadString('\n')
+		input, err := c.console.ReadLine()
+		if errors.Is(err, io.EOF) || errors.Is(err, errInterrupted) {
+			c.println("Goodbye!")
+			return
+		}
 		if err != nil {
-			fmt.Println("Error reading input:", err)
-			continue
+			c.println("Error reading input:", err)
+			return
 		}
 
 		input = strings.TrimSpace(input)
@@ -59,10 +114,15 @@ func (c *Client) Run() {
 
 		if strings.HasPrefix(input, "/") {
 			c.handleCommand(input)
-		} else if c.currentChat != "" {
-			c.sendMessage(input)
+		} else if chatID != "" {
+			req := models.SendMessageRequest{Content: input}
+			// Everything typed in a thread replies to it
+			if thread != nil {
+				req.ThreadID = thread.ID
+			}
+			c.sendMessage(chatID, req)
 		} else {
-			fmt.Println("Please join a chat first using /join ID")
+			c.println("Please join a chat first using /join ID")
 		}
 	}
 }
@@ -76,198 +136,478 @@ func (c *Client) handleCommand(cmd string) {
 	switch parts[0] {
 	case "/list":
 		c.listChats()
+		c.listDirectChats()
 	case "/create":
+		visibility := models.VisibilityPublic
+		if len(parts) > 1 && parts[1] == "--private" {
+			visibility = models.VisibilityPrivate
+			parts = parts[1:]
+		}
 		if len(parts) < 2 {
-			fmt.Println("Usage: /create NAME")
+			c.println("Usage: /create [--private] NAME")
 			return
 		}
 		name := strings.Join(parts[1:], " ")
-		c.createChat(name)
+		c.createChat(name, visibility)
 	case "/join":
 		if len(parts) != 2 {
-			fmt.Println("Usage: /join ID")
+			c.println("Usage: /join ID")
 			return
 		}
-		c.joinChat(parts[1])
+		c.joinChat(parts[1], "")
 	case "/refresh":
-		if c.currentChat != "" {
-			c.refreshMessages()
+		c.mu.Lock()
+		chatID := c.currentChat
+		c.mu.Unlock()
+		if chatID != "" {
+			c.refreshMessages(chatID)
 		} else {
-			fmt.Println("Not in a chat")
+			c.println("Not in a chat")
+		}
+	case "/members":
+		if chatID, ok := c.currentChatID(); ok {
+			c.listMembers(chatID)
+		}
+	case "/invite":
+		if len(parts) > 1 && parts[1] == "--link" {
+			if len(parts) > 4 {
+				c.println("Usage: /invite --link [EXPIRY] [USES]")
+				return
+			}
+			if chatID, ok := c.currentChatID(); ok {
+				c.createInviteLink(chatID, parts[2:])
+			}
+			return
 		}
+		if len(parts) < 2 || len(parts) > 3 {
+			c.println("Usage: /invite USER [ROLE] or /invite --link [EXPIRY] [USES]")
+			return
+		}
+		if chatID, ok := c.currentChatID(); ok {
+			role := models.RoleMember
+			if len(parts) == 3 {
+				role = parts[2]
+			}
+			c.inviteMember(chatID, parts[1], role)
+		}
+	case "/kick":
+		if len(parts) != 2 {
+			c.println("Usage: /kick USER")
+			return
+		}
+		if chatID, ok := c.currentChatID(); ok {
+			c.kickMember(chatID, parts[1])
+		}
+	case "/promote":
+		if len(parts) < 2 || len(parts) > 3 {
+			c.println("Usage: /promote USER [ROLE]")
+			return
+		}
+		if chatID, ok := c.currentChatID(); ok {
+			role := models.RoleAdmin
+			if len(parts) == 3 {
+				role = parts[2]
+			}
+			c.setRole(chatID, parts[1], role)
+		}
+	case "/edit":
+		if len(parts) < 3 {
+			c.println("Usage: /edit MSG TEXT")
+			return
+		}
+		if chatID, ok := c.currentChatID(); ok {
+			c.editMessage(chatID, parts[1], strings.Join(parts[2:], " "))
+		}
+	case "/delete":
+		if len(parts) != 2 {
+			c.println("Usage: /delete MSG")
+			return
+		}
+		if chatID, ok := c.currentChatID(); ok {
+			c.deleteMessage(chatID, parts[1])
+		}
+	case "/rename":
+		if len(parts) < 2 {
+			c.println("Usage: /rename NAME")
+			return
+		}
+		if chatID, ok := c.currentChatID(); ok {
+			c.updateChat(chatID, models.UpdateChatRequest{Name: strings.Join(parts[1:], " ")})
+		}
+	case "/topic":
+		if chatID, ok := c.currentChatID(); ok {
+			topic := strings.Join(parts[1:], " ")
+			c.updateChat(chatID, models.UpdateChatRequest{Topic: &topic})
+		}
+	case "/archive", "/unarchive":
+		if chatID, ok := c.currentChatID(); ok {
+			archived := parts[0] == "/archive"
+			c.updateChat(chatID, models.UpdateChatRequest{Archived: &archived})
+		}
+	case "/thread":
+		if len(parts) > 2 {
+			c.println("Usage: /thread [MSG]")
+			return
+		}
+		if chatID, ok := c.currentChatID(); ok {
+			if len(parts) == 1 {
+				c.leaveThread(chatID)
+			} else {
+				c.enterThread(chatID, parts[1])
+			}
+		}
+	case "/reply":
+		if len(parts) < 3 {
+			c.println("Usage: /reply MSG TEXT")
+			return
+		}
+		if chatID, ok := c.currentChatID(); ok {
+			c.reply(chatID, parts[1], strings.Join(parts[2:], " "))
+		}
+	case "/react", "/unreact":
+		if len(parts) != 3 {
+			c.printf("Usage: %s MSG EMOJI\n", parts[0])
+			return
+		}
+		if chatID, ok := c.currentChatID(); ok {
+			c.react(chatID, parts[1], parts[2], parts[0] == "/unreact")
+		}
+	case "/search":
+		if len(parts) < 2 {
+			c.println("Usage: /search TEXT")
+			return
+		}
+		c.search(strings.Join(parts[1:], " "))
+	case "/dm":
+		if len(parts) != 2 {
+			c.println("Usage: /dm USER")
+			return
+		}
+		c.openDirectChat(parts[1])
+	case "/accept":
+		if len(parts) != 2 {
+			c.println("Usage: /accept TOKEN")
+			return
+		}
+		c.acceptInvite(parts[1])
 	case "/quit":
-		fmt.Println("Goodbye!")
+		c.println("Goodbye!")
 		os.Exit(0)
 	default:
-		fmt.Println("Unknown command:", parts[0])
+		c.println("Unknown command:", parts[0])
 	}
 }
 
 func (c *Client) listChats() {
-	resp, err := http.Get(c.serverURL + "/api/chats")
+	resp, err := c.do(context.Background(), "GET", "/api/chats?sort=activity", nil)
 	if err != nil {
-		fmt.Println("Error fetching chats:", err)
+		c.println("Error fetching chats:", err)
 		return
 	}
 	defer func() {
 		if closeErr := resp.Body.Close(); closeErr != nil {
-			fmt.Printf("Warning: failed to close response body: %v\n", closeErr)
+			c.printf("Warning: failed to close response body: %v\n", closeErr)
 		}
 	}()
 
-	var chats []*models.Chat
+	if resp.StatusCode != http.StatusOK {
+		c.println("Error fetching chats:", responseError(resp))
+		return
+	}
+
+	var chats []*models.ChatSummary
 	if err := json.NewDecoder(resp.Body).Decode(&chats); err != nil {
-		fmt.Println("Error decoding response:", err)
+		c.println("Error decoding response:", err)
 		return
 	}
 
 	if len(chats) == 0 {
-		fmt.Println("No chats available. Create one with /create NAME")
+		c.println("No chats available. Create one with /create NAME")
 		return
 	}
 
-	fmt.Println("\nAvailable chats:")
+	c.println("\nAvailable chats:")
 	for _, chat := range chats {
-		fmt.Printf("  ID: %s | Name: %s | Created: %s\n",
-			chat.ID[:8], chat.Name, chat.CreatedAt.Format("15:04:05"))
+		private := ""
+		if chat.Visibility == models.VisibilityPrivate {
+			private = " (private)"
+		}
+		c.printf("  ID: %s | Name: %s%s | Messages: %d | Active: %s\n",
+			chat.ID[:8], chat.Name, private, chat.MessageCount, chat.LastActivityAt.Format("Jan 2 15:04"))
+		if last := chat.LastMessage; last != nil {
+			c.printf("      %s: %s\n", last.Username, last.Content)
+		}
 	}
-	fmt.Println()
+	c.println()
 }
 
-func (c *Client) createChat(name string) {
-	reqBody, _ := json.Marshal(models.CreateChatRequest{Name: name})
-	resp, err := http.Post(c.serverURL+"/api/chats", "application/json", bytes.NewBuffer(reqBody))
+func (c *Client) createChat(name, visibility string) {
+	reqBody, _ := json.Marshal(models.CreateChatRequest{Name: name, Visibility: visibility})
+	resp, err := c.do(context.Background(), "POST", "/api/chats", bytes.NewBuffer(reqBody))
 	if err != nil {
-		fmt.Println("Error creating chat:", err)
+		c.println("Error creating chat:", err)
 		return
 	}
 	defer func() {
 		if closeErr := resp.Body.Close(); closeErr != nil {
-			fmt.Printf("Warning: failed to close response body: %v\n", closeErr)
+			c.printf("Warning: failed to close response body: %v\n", closeErr)
 		}
 	}()
 
 	if resp.StatusCode != http.StatusCreated {
-		body, _ := io.ReadAll(resp.Body)
-		fmt.Printf("Failed to create chat: %s\n", string(body))
+		c.println("Failed to create chat:", responseError(resp))
 		return
 	}
 
 	var chat models.Chat
 	if err := json.NewDecoder(resp.Body).Decode(&chat); err != nil {
-		fmt.Println("Error decoding response:", err)
+		c.println("Error decoding response:", err)
 		return
 	}
 
-	fmt.Printf("Created chat '%s' with ID: %s\n", chat.Name, chat.ID[:8])
-	fmt.Println("Join it with: /join", chat.ID)
+	c.printf("Created %s chat '%s' with ID: %s\n", chat.Visibility, chat.Name, chat.ID[:8])
+	c.println("Join it with: /join", chat.ID)
 }
 
-func (c *Client) joinChat(chatID string) {
-	// First, check if chat exists by getting messages
-	resp, err := http.Get(c.serverURL + "/api/chats/" + chatID + "/messages")
+// historyLimit is the number of messages shown when joining or refreshing a chat
+const historyLimit = 50
+
+// fetchMessages gets a page of messages for a chat
+func (c *Client) fetchMessages(ctx context.Context, chatID string, params url.Values) (*models.MessagePageResponse, error) {
+	resp, err := c.do(ctx, "GET", "/api/chats/"+url.PathEscape(chatID)+"/messages?"+params.Encode(), nil)
 	if err != nil {
-		fmt.Println("Error joining chat:", err)
-		return
+		return nil, err
 	}
 	defer func() {
 		if closeErr := resp.Body.Close(); closeErr != nil {
-			fmt.Printf("Warning: failed to close response body: %v\n", closeErr)
+			c.printf("Warning: failed to close response body: %v\n", closeErr)
 		}
 	}()
 
 	if resp.StatusCode == http.StatusNotFound {
-		fmt.Println("Chat not found")
-		return
+		return nil, errChatNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp)
 	}
 
-	var messages []*models.Message
-	if err := json.NewDecoder(resp.Body).Decode(&messages); err != nil {
-		fmt.Println("Error decoding messages:", err)
+	var page models.MessagePageResponse
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, fmt.Errorf("decoding messages: %w", err)
+	}
+	return &page, nil
+}
+
+// latestMessages gets the most recent messages of a chat
+func (c *Client) latestMessages(chatID string) (*models.MessagePageResponse, error) {
+	return c.fetchMessages(context.Background(), chatID, url.Values{"limit": {strconv.Itoa(historyLimit)}})
+}
+
+// joinChat switches to a chat. The prompt shows label, or the chat ID if it
+// is empty.
+func (c *Client) joinChat(chatID, label string) {
+	page, err := c.latestMessages(chatID)
+	if errors.Is(err, errChatNotFound) {
+		c.println("Chat not found")
 		return
 	}
+	if err != nil {
+		c.println("Error joining chat:", err)
+		return
+	}
+	member, err := c.ensureMember(chatID)
+	if err != nil {
+		c.println("Error joining chat:", err)
+		return
+	}
+	var chat models.Chat
+	if err := c.call("GET", chatPath(chatID), nil, &chat); err != nil {
+		c.println("Error joining chat:", err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
+	if c.stopFollow != nil {
+		c.stopFollow()
+	}
 	c.currentChat = chatID
-	fmt.Printf("\nJoined chat %s\n", chatID[:8])
-	fmt.Println("=== Chat History ===")
+	c.chatLabel = label
+	joined := label
+	if label == "" {
+		c.chatLabel = chatID
+		joined = "chat " + chatID[:8]
+	}
+	c.lastSeq = 0
+	c.thread = nil
+	c.seqOf = make(map[string]int64)
+	c.trackSeq(page.Messages)
+	c.printf("\nJoined %s as %s\n", joined, member.Role)
+	c.showChatDetails(&chat)
+	c.println("=== Chat History ===")
+	c.displayPage(page)
+	c.println("===================")
+	c.println("New messages will appear as they arrive.")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.stopFollow = cancel
+	go c.follow(ctx, chatID)
+}
 
-	if len(messages) == 0 {
-		fmt.Println("(No messages yet)")
-	} else {
-		for _, msg := range messages {
-			c.displayMessage(msg)
+// refreshMessages prints the messages added since the newest one seen
+func (c *Client) refreshMessages(chatID string) {
+	c.mu.Lock()
+	since := c.lastSeq
+	c.mu.Unlock()
+
+	var fresh []*models.Message
+	for {
+		page, err := c.fetchMessages(context.Background(), chatID, url.Values{
+			"since_seq": {strconv.FormatInt(since, 10)},
+			"limit":     {strconv.Itoa(historyLimit)},
+		})
+		if err != nil {
+			c.println("Error fetching messages:", err)
+			return
 		}
+		fresh = append(fresh, page.Messages...)
+		if len(page.Messages) > 0 {
+			since = page.Messages[len(page.Messages)-1].Seq
+		}
+		if page.NextCursor == "" || len(page.Messages) == 0 {
+			break
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.showNew(chatID, fresh) == 0 {
+		c.println("(No new messages)")
 	}
-	fmt.Println("===================")
 }
 
-func (c *Client) refreshMessages() {
-	resp, err := http.Get(c.serverURL + "/api/chats/" + c.currentChat + "/messages")
-	if err != nil {
-		fmt.Println("Error fetching messages:", err)
-		return
+// showNew prints the messages of chatID newer than any seen so far and
+// returns how many were printed. The caller must hold c.mu.
+func (c *Client) showNew(chatID string, messages []*models.Message) int {
+	if chatID != c.currentChat {
+		return 0
 	}
-	defer func() {
-		if closeErr := resp.Body.Close(); closeErr != nil {
-			fmt.Printf("Warning: failed to close response body: %v\n", closeErr)
+	shown := 0
+	for _, msg := range messages {
+		if msg.Seq <= c.lastSeq {
+			continue
 		}
-	}()
+		c.seqOf[msg.ID] = msg.Seq
+		c.lastSeq = msg.Seq
+		switch {
+		case c.inView(msg):
+			c.displayMessage(msg)
+		case c.thread == nil:
+			// Replies are kept out of the main chat, but announced
+			if seq, ok := c.seqOf[msg.ThreadID]; ok {
+				c.printf("(%s replied in the thread of #%d)\n", msg.Username, seq)
+			} else {
+				c.printf("(%s replied in a thread)\n", msg.Username)
+			}
+		default:
+			continue
+		}
+		shown++
+	}
+	return shown
+}
 
-	var messages []*models.Message
-	if err := json.NewDecoder(resp.Body).Decode(&messages); err != nil {
-		fmt.Println("Error decoding messages:", err)
-		return
+// trackSeq records the newest sequence number in messages. The caller must
+// hold c.mu.
+func (c *Client) trackSeq(messages []*models.Message) {
+	for _, msg := range messages {
+		c.seqOf[msg.ID] = msg.Seq
+		c.lastSeq = max(c.lastSeq, msg.Seq)
 	}
+}
 
-	fmt.Println("\n=== Refreshed Messages ===")
-	if len(messages) == 0 {
-		fmt.Println("(No messages yet)")
-	} else {
-		for _, msg := range messages {
+// inView reports whether msg belongs to what is being viewed: the thread
+// that was entered, or else the main chat without replies. The caller must
+// hold c.mu.
+func (c *Client) inView(msg *models.Message) bool {
+	if c.thread != nil {
+		return msg.ID == c.thread.ID || msg.ThreadID == c.thread.ID
+	}
+	return msg.ThreadID == ""
+}
+
+// displayPage prints the messages of a page that are in view, noting when
+// older history exists. The caller must hold c.mu.
+func (c *Client) displayPage(page *models.MessagePageResponse) {
+	if page.PrevCursor != "" {
+		c.printf("(showing the last %d messages)\n", len(page.Messages))
+	}
+	shown := 0
+	for _, msg := range page.Messages {
+		if c.inView(msg) {
 			c.displayMessage(msg)
+			shown++
 		}
 	}
-	fmt.Println("========================")
+	if shown == 0 {
+		c.println("(No messages yet)")
+	}
 }
 
-func (c *Client) sendMessage(content string) {
-	reqBody, _ := json.Marshal(models.SendMessageRequest{
-		Username: c.username,
-		Content:  content,
-	})
-
-	resp, err := http.Post(
-		c.serverURL+"/api/chats/"+c.currentChat+"/messages",
-		"application/json",
-		bytes.NewBuffer(reqBody),
-	)
+func (c *Client) sendMessage(chatID string, req models.SendMessageRequest) {
+	reqBody, _ := json.Marshal(req)
+
+	resp, err := c.do(context.Background(), "POST", "/api/chats/"+chatID+"/messages", bytes.NewBuffer(reqBody))
 	if err != nil {
-		fmt.Println("Error sending message:", err)
+		c.println("Error sending message:", err)
 		return
 	}
 	defer func() {
 		if closeErr := resp.Body.Close(); closeErr != nil {
-			fmt.Printf("Warning: failed to close response body: %v\n", closeErr)
+			c.printf("Warning: failed to close response body: %v\n", closeErr)
 		}
 	}()
 
 	if resp.StatusCode != http.StatusCreated {
-		body, _ := io.ReadAll(resp.Body)
-		fmt.Printf("Failed to send message: %s\n", string(body))
+		c.println("Failed to send message:", responseError(resp))
 	}
 }
 
+// displayMessage prints a message with its sequence number, which commands
+// such as /edit use to refer to it
 func (c *Client) displayMessage(msg *models.Message) {
 	timestamp := msg.Timestamp.Format("15:04:05")
-	if msg.Username == c.username {
-		fmt.Printf("[%s] You: %s\n", timestamp, msg.Content)
-	} else {
-		fmt.Printf("[%s] %s: %s\n", timestamp, msg.Username, msg.Content)
+	content := msg.Content
+	switch {
+	case msg.DeletedAt != nil:
+		content = "(message deleted)"
+	case msg.EditedAt != nil:
+		content += " (edited)"
+	}
+	switch {
+	case msg.ReplyCount == 1:
+		content += " [1 reply]"
+	case msg.ReplyCount > 1:
+		content += fmt.Sprintf(" [%d replies]", msg.ReplyCount)
+	}
+	if summary := reactionSummary(msg); summary != "" {
+		content += " " + summary
+	}
+	switch {
+	case msg.Bot:
+		c.printf("[%s] #%d %s [bot]: %s\n", timestamp, msg.Seq, msg.Username, content)
+	case msg.Username == c.username:
+		c.printf("[%s] #%d You: %s\n", timestamp, msg.Seq, content)
+	default:
+		c.printf("[%s] #%d %s: %s\n", timestamp, msg.Seq, msg.Username, content)
 	}
 }
 
 func main() {
 	username := flag.String("username", "", "Your username")
+	password := flag.String("password", "", "Your password (prompted for if not given)")
+	register := flag.Bool("register", false, "Create the account before logging in")
 	server := flag.String("server", "http://localhost:8080", "Server URL")
 	flag.Parse()
 
@@ -278,5 +618,9 @@ func main() {
 	}
 
 	client := NewClient(*server, *username)
+	if err := client.login(*password, *register); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
 	client.Run()
 }
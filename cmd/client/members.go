@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+
+	"chat-app/internal/models"
+)
+
+// membersPath is the API path of a chat's members, or of one member when
+// username is not empty
+func membersPath(chatID, username string) string {
+	path := "/api/chats/" + url.PathEscape(chatID) + "/members"
+	if username != "" {
+		path += "/" + url.PathEscape(username)
+	}
+	return path
+}
+
+// currentChatID returns the chat being viewed, printing a hint if there is
+// none
+func (c *Client) currentChatID() (string, bool) {
+	c.mu.Lock()
+	chatID := c.currentChat
+	c.mu.Unlock()
+	if chatID == "" {
+		c.println("Not in a chat")
+		return "", false
+	}
+	return chatID, true
+}
+
+// ensureMember joins a chat as a member unless already in it, in which case
+// the server returns the existing membership
+func (c *Client) ensureMember(chatID string) (*models.Member, error) {
+	var member models.Member
+	if err := c.call("POST", membersPath(chatID, ""), models.AddMemberRequest{Username: c.username}, &member); err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+func (c *Client) listMembers(chatID string) {
+	var members []*models.Member
+	if err := c.call("GET", membersPath(chatID, ""), nil, &members); err != nil {
+		c.println("Error fetching members:", err)
+		return
+	}
+	c.println("\nMembers:")
+	for _, member := range members {
+		c.printf("  %s (%s)\n", member.Username, member.Role)
+	}
+	c.println()
+}
+
+func (c *Client) inviteMember(chatID, username, role string) {
+	var member models.Member
+	req := models.AddMemberRequest{Username: username, Role: role}
+	if err := c.call("POST", membersPath(chatID, ""), req, &member); err != nil {
+		c.println("Failed to invite:", err)
+		return
+	}
+	c.printf("Added %s as %s\n", member.Username, member.Role)
+}
+
+func (c *Client) kickMember(chatID, username string) {
+	if err := c.call("DELETE", membersPath(chatID, username), nil, nil); err != nil {
+		c.println("Failed to remove member:", err)
+		return
+	}
+	c.printf("Removed %s from the chat\n", username)
+}
+
+func (c *Client) setRole(chatID, username, role string) {
+	var member models.Member
+	req := models.UpdateMemberRequest{Role: role}
+	if err := c.call("PATCH", membersPath(chatID, username), req, &member); err != nil {
+		c.println("Failed to change role:", err)
+		return
+	}
+	c.printf("%s is now %s\n", member.Username, member.Role)
+}
+
+// createInviteLink mints an invite token for a chat. args are an optional
+// expiry such as 24h and an optional number of uses.
+func (c *Client) createInviteLink(chatID string, args []string) {
+	var req models.CreateInviteRequest
+	if len(args) > 0 {
+		req.ExpiresIn = args[0]
+	}
+	if len(args) > 1 {
+		uses, err := strconv.Atoi(args[1])
+		if err != nil {
+			c.println("USES must be a number")
+			return
+		}
+		req.MaxUses = uses
+	}
+
+	var resp models.CreateInviteResponse
+	if err := c.call("POST", "/api/chats/"+url.PathEscape(chatID)+"/invites", req, &resp); err != nil {
+		c.println("Failed to create invite:", err)
+		return
+	}
+	c.println("Invite token:", resp.Token)
+	if resp.Invite.ExpiresAt != nil {
+		c.printf("Expires at %s\n", resp.Invite.ExpiresAt.Format("2006-01-02 15:04:05"))
+	}
+	if resp.Invite.MaxUses > 0 {
+		c.printf("Can be used %d times\n", resp.Invite.MaxUses)
+	}
+	c.println("Others join with: /accept", resp.Token)
+}
+
+// acceptInvite redeems an invite token and joins its chat
+func (c *Client) acceptInvite(token string) {
+	var member models.Member
+	if err := c.call("POST", "/api/invites/accept", models.AcceptInviteRequest{Token: token}, &member); err != nil {
+		c.println("Failed to accept invite:", err)
+		return
+	}
+	c.joinChat(member.ChatID, "")
+}
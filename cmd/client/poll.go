@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// pollWait is how long each long-poll request waits on the server
+const pollWait = 30 * time.Second
+
+// poll long-polls a chat for messages after the newest one seen and prints
+// them as they arrive. It is the fallback for networks whose proxies block
+// event streams; only new messages are seen, not edits or reactions.
+// onConnect is called after the first successful poll. It reports whether
+// any poll succeeded and always returns the error that ended polling.
+func (c *Client) poll(ctx context.Context, chatID string, onConnect func()) (bool, error) {
+	connected := false
+	for {
+		c.mu.Lock()
+		since := c.lastSeq
+		c.mu.Unlock()
+
+		page, err := c.fetchMessages(ctx, chatID, url.Values{
+			"since_seq": {strconv.FormatInt(since, 10)},
+			"limit":     {strconv.Itoa(historyLimit)},
+			"wait":      {pollWait.String()},
+		})
+		if err != nil {
+			return connected, err
+		}
+		if !connected {
+			connected = true
+			onConnect()
+		}
+
+		c.mu.Lock()
+		c.showNew(chatID, page.Messages)
+		c.mu.Unlock()
+	}
+}
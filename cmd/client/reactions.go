@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"chat-app/internal/models"
+)
+
+// reactionSummary formats the reaction counts of a message, such as
+// "[:tada: 1, :thumbsup: 2]", or returns "" if it has none
+func reactionSummary(msg *models.Message) string {
+	if len(msg.Reactions) == 0 {
+		return ""
+	}
+	emojis := make([]string, 0, len(msg.Reactions))
+	for emoji := range msg.Reactions {
+		emojis = append(emojis, emoji)
+	}
+	sort.Strings(emojis)
+	counts := make([]string, len(emojis))
+	for i, emoji := range emojis {
+		counts[i] = fmt.Sprintf("%s %d", emoji, msg.Reactions[emoji])
+	}
+	return "[" + strings.Join(counts, ", ") + "]"
+}
+
+// react adds the caller's reaction to the message ref names, or takes it back
+// when remove is set
+func (c *Client) react(chatID, ref, emoji string, remove bool) {
+	msg, err := c.resolveMessage(chatID, ref)
+	if err != nil {
+		c.println("Failed to react:", err)
+		return
+	}
+	method := "POST"
+	if remove {
+		method = "DELETE"
+	}
+	req := models.ReactionRequest{Emoji: emoji}
+	if err := c.call(method, messagePath(chatID, msg.ID)+"/reactions", req, nil); err != nil {
+		c.println("Failed to react:", err)
+	}
+}
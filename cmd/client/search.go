@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net/url"
+
+	"chat-app/internal/models"
+)
+
+// search looks for messages containing text in every chat the user can see.
+// Each result names its chat and message so it can be found with /join.
+func (c *Client) search(text string) {
+	var results []*models.SearchResult
+	if err := c.call("GET", "/api/search?"+url.Values{"q": {text}}.Encode(), nil, &results); err != nil {
+		c.println("Failed to search:", err)
+		return
+	}
+	if len(results) == 0 {
+		c.printf("No messages match '%s'\n", text)
+		return
+	}
+	c.printf("\nMessages matching '%s':\n", text)
+	for _, result := range results {
+		msg := result.Message
+		c.printf("  [%s] #%d %s: %s\n", msg.Timestamp.Format("Jan 2 15:04"), msg.Seq, msg.Username, result.Highlight)
+		c.printf("      chat %s, message %s\n", msg.ChatID, msg.ID)
+	}
+	c.println()
+}
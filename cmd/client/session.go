@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"chat-app/internal/models"
+)
+
+// apiError is an error response from the server
+type apiError struct {
+	status int
+	models.APIError
+}
+
+// Error returns the server's explanation. Failures of the server add the
+// request ID to quote when reporting them.
+func (e *apiError) Error() string {
+	if e.status >= http.StatusInternalServerError && e.RequestID != "" {
+		return fmt.Sprintf("%s (request ID %s)", e.Message, e.RequestID)
+	}
+	return e.Message
+}
+
+// responseError reads the error explained by a response without a 2xx
+// status. Bodies that are not JSON error envelopes are taken as plain text.
+func responseError(resp *http.Response) error {
+	body, _ := io.ReadAll(resp.Body)
+	var envelope models.ErrorResponse
+	if err := json.Unmarshal(body, &envelope); err == nil && envelope.Error != nil {
+		return &apiError{status: resp.StatusCode, APIError: *envelope.Error}
+	}
+	text := strings.TrimSpace(string(body))
+	if text == "" {
+		text = resp.Status
+	}
+	return &apiError{status: resp.StatusCode, APIError: models.APIError{Message: text}}
+}
+
+// do sends a request to the server, authenticated with the session token
+// once logged in. A non-nil body is sent as JSON.
+func (c *Client) do(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.serverURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// login starts a session as c.username, prompting for the password if it is
+// empty. With register set the account is created first.
+func (c *Client) login(password string, register bool) error {
+	if password == "" {
+		var err error
+		password, err = c.console.ReadPassword(fmt.Sprintf("Password for %s: ", c.username))
+		if err != nil {
+			return fmt.Errorf("reading password: %w", err)
+		}
+	}
+
+	if register {
+		var user models.User
+		if err := c.call("POST", "/api/users", models.RegisterRequest{
+			Username: c.username,
+			Password: password,
+		}, &user); err != nil {
+			return fmt.Errorf("registration failed: %w", err)
+		}
+		c.printf("Registered account %s\n", user.Username)
+	}
+
+	var session models.SessionResponse
+	if err := c.call("POST", "/api/sessions", models.LoginRequest{
+		Username: c.username,
+		Password: password,
+	}, &session); err != nil {
+		return fmt.Errorf("login failed: %w", err)
+	}
+	c.token = session.Token
+	// Use the name as registered so our own messages are recognised
+	c.username = session.User.Username
+	return nil
+}
+
+// call sends req, if not nil, as JSON and decodes the response into out, if
+// not nil. Responses without a 2xx status are returned as errors carrying
+// the server's explanation.
+func (c *Client) call(method, path string, req, out any) error {
+	var body io.Reader
+	if req != nil {
+		reqBody, _ := json.Marshal(req)
+		body = bytes.NewBuffer(reqBody)
+	}
+	resp, err := c.do(context.Background(), method, path, body)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			c.printf("Warning: failed to close response body: %v\n", closeErr)
+		}
+	}()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return responseError(resp)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	return nil
+}
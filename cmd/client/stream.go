@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"chat-app/internal/models"
+)
+
+const (
+	// reconnectMinDelay is the pause before the first reconnection attempt
+	reconnectMinDelay = 500 * time.Millisecond
+	// reconnectMaxDelay caps the exponential backoff between attempts
+	reconnectMaxDelay = 30 * time.Second
+	// streamIdleTimeout is how long a stream may stay silent before it is
+	// considered dead. The server sends a heartbeat every 15 seconds.
+	streamIdleTimeout = 45 * time.Second
+	// maxEventSize is the largest event accepted from the server
+	maxEventSize = 1 << 20
+)
+
+// Connection states shown on the status line
+const (
+	statusConnecting   = "connecting"
+	statusLive         = "live"
+	statusPolling      = "live (polling)"
+	statusReconnecting = "reconnecting in %s"
+	statusOffline      = "offline"
+)
+
+// errStreamClosed is returned when the server ends an event stream
+var errStreamClosed = errors.New("stream closed by server")
+
+// follow prints the messages posted to a chat as they arrive, until ctx is
+// cancelled. If the event stream cannot be established it falls back to long
+// polling. When the connection is lost it reconnects with exponential
+// backoff and resumes after the newest message seen.
+func (c *Client) follow(ctx context.Context, chatID string) {
+	delay := reconnectMinDelay
+	lost := false
+	onConnect := func(status string) func() {
+		return func() {
+			c.console.SetStatus(status)
+			if lost {
+				c.printf("Reconnected\n")
+				lost = false
+			}
+		}
+	}
+	for {
+		c.console.SetStatus(statusConnecting)
+		connected, err := c.stream(ctx, chatID, onConnect(statusLive))
+		if !connected && ctx.Err() == nil && !errors.Is(err, errChatNotFound) {
+			// Proxies that block event streams usually let plain
+			// requests through
+			connected, err = c.poll(ctx, chatID, onConnect(statusPolling))
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			delay = reconnectMinDelay
+		}
+		if errors.Is(err, errChatNotFound) {
+			c.console.SetStatus(statusOffline)
+			c.printf("This chat no longer exists\n")
+			return
+		}
+		if !lost {
+			c.printf("Connection lost: %v\n", err)
+			lost = true
+		}
+
+		c.console.SetStatus(fmt.Sprintf(statusReconnecting, delay))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		delay = min(delay*2, reconnectMaxDelay)
+	}
+}
+
+// stream reads a chat's event stream, starting after the newest message
+// seen, and prints its messages. onConnect is called once the server has
+// accepted the stream. It reports whether the stream was established and
+// always returns the error that ended it.
+func (c *Client) stream(parent context.Context, chatID string, onConnect func()) (bool, error) {
+	c.mu.Lock()
+	since := c.lastSeq
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	resp, err := c.do(ctx, "GET", "/api/chats/"+url.PathEscape(chatID)+"/events?since_seq="+strconv.FormatInt(since, 10), nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return false, errChatNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, responseError(resp)
+	}
+	onConnect()
+
+	// A connection that goes quiet for longer than the heartbeat interval
+	// is abandoned so that a vanished server is noticed
+	idle := time.AfterFunc(streamIdleTimeout, cancel)
+	defer idle.Stop()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 4096), maxEventSize)
+	var event, data string
+	for scanner.Scan() {
+		idle.Reset(streamIdleTimeout)
+		line := scanner.Text()
+		if line == "" {
+			switch event {
+			case models.EventMessage, models.EventEdit, models.EventDelete, models.EventReaction:
+				c.receive(chatID, event, data)
+			case models.EventExpire:
+				c.receiveExpiry(chatID, data)
+			case models.EventChatDeleted:
+				return true, errChatNotFound
+			}
+			event, data = "", ""
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			event = value
+		case "data":
+			if data != "" {
+				data += "\n"
+			}
+			data += value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if parent.Err() == nil && ctx.Err() != nil {
+			return true, fmt.Errorf("no data from server for %s", streamIdleTimeout)
+		}
+		return true, err
+	}
+	return true, errStreamClosed
+}
+
+// receive prints a message event unless it has already been shown. Edits,
+// deletions and reactions to messages already shown print the message again.
+func (c *Client) receive(chatID, event, data string) {
+	var msg models.Message
+	if err := json.Unmarshal([]byte(data), &msg); err != nil {
+		c.printf("Ignoring malformed event: %v\n", err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if event != models.EventMessage {
+		if chatID == c.currentChat && msg.Seq <= c.lastSeq && c.inView(&msg) {
+			c.displayMessage(&msg)
+		}
+		return
+	}
+	c.showNew(chatID, []*models.Message{&msg})
+}
+
+// receiveExpiry notes how many of the messages already shown were removed
+// by retention. They can be many, so they are not printed again.
+func (c *Client) receiveExpiry(chatID, data string) {
+	var msgs []*models.Message
+	if err := json.Unmarshal([]byte(data), &msgs); err != nil {
+		c.printf("Ignoring malformed event: %v\n", err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if chatID != c.currentChat {
+		return
+	}
+	shown := 0
+	for _, msg := range msgs {
+		if msg.Seq <= c.lastSeq {
+			shown++
+		}
+	}
+	if shown > 0 {
+		c.printf("%d older messages expired\n", shown)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import "chat-app/internal/models"
+
+// enterThread shows the thread of the message ref names and makes it the
+// view, so that typed text replies to it
+func (c *Client) enterThread(chatID, ref string) {
+	msg, err := c.resolveMessage(chatID, ref)
+	if err != nil {
+		c.println("Error opening thread:", err)
+		return
+	}
+	var thread []*models.Message
+	if err := c.call("GET", messagePath(chatID, msg.ID)+"/thread", nil, &thread); err != nil {
+		c.println("Error opening thread:", err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if chatID != c.currentChat {
+		return
+	}
+	c.thread = thread[0]
+	c.printf("\n=== Thread #%d ===\n", c.thread.Seq)
+	for _, msg := range thread {
+		c.seqOf[msg.ID] = msg.Seq
+		c.displayMessage(msg)
+	}
+	c.println("===================")
+	c.println("Type to reply, or /thread to go back to the chat.")
+}
+
+// leaveThread goes back from a thread to the main chat, showing its latest
+// messages again since those posted meanwhile were not shown
+func (c *Client) leaveThread(chatID string) {
+	c.mu.Lock()
+	inThread := c.thread != nil
+	c.mu.Unlock()
+	if !inThread {
+		c.println("Not in a thread")
+		return
+	}
+
+	page, err := c.latestMessages(chatID)
+	if err != nil {
+		c.println("Error fetching messages:", err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if chatID != c.currentChat {
+		return
+	}
+	c.thread = nil
+	c.println("\n=== Chat History ===")
+	c.displayPage(page)
+	c.println("===================")
+}
+
+// reply answers the message ref names without entering its thread
+func (c *Client) reply(chatID, ref, content string) {
+	msg, err := c.resolveMessage(chatID, ref)
+	if err != nil {
+		c.println("Failed to reply:", err)
+		return
+	}
+	c.sendMessage(chatID, models.SendMessageRequest{Content: content, ReplyTo: msg.ID})
+}
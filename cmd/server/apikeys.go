@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"chat-app/internal/auth"
+	"chat-app/internal/models"
+	"chat-app/internal/storage"
+
+	"github.com/gorilla/mux"
+)
+
+// handleCreateAPIKey creates an API key for a bot owned by the caller. The
+// token is returned once and only its hash is stored.
+func (s *Server) handleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := auth.ValidateUsername(req.BotName); err != nil {
+		invalidField(w, "bot_name", "Invalid bot name: "+err.Error())
+		return
+	}
+	if len(req.Scopes) == 0 {
+		invalidField(w, "scopes", "At least one scope is required")
+		return
+	}
+	var scopes []string
+	for _, scope := range req.Scopes {
+		if scope != models.ScopeRead && scope != models.ScopeWrite {
+			invalidField(w, "scopes", "Unknown scope: "+scope)
+			return
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	for _, chatID := range req.Chats {
+		if _, exists := s.storage.GetChat(chatID); !exists {
+			invalidField(w, "chats", "Chat not found: "+chatID)
+			return
+		}
+	}
+
+	token, err := auth.NewAPIKey()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to create API key")
+		return
+	}
+	key, err := s.storage.CreateAPIKey(auth.HashToken(token), models.APIKey{
+		BotName: req.BotName,
+		Owner:   identityFrom(r).Username,
+		Chats:   req.Chats,
+		Scopes:  scopes,
+	})
+	if errors.Is(err, storage.ErrBotNameTaken) {
+		writeError(w, http.StatusConflict, "Bot name already taken")
+		return
+	}
+	if errors.Is(err, storage.ErrAPIKeyQuota) {
+		writeError(w, http.StatusTooManyRequests, fmt.Sprintf("You already have %d API keys; revoke one first", storage.MaxAPIKeys))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to create API key")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(models.CreateAPIKeyResponse{Key: key, Token: token}); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to encode response")
+		return
+	}
+}
+
+// handleListAPIKeys lists the caller's API keys, including revoked ones
+func (s *Server) handleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys := s.storage.ListAPIKeys(identityFrom(r).Username)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(keys); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to encode response")
+		return
+	}
+}
+
+// handleRevokeAPIKey revokes one of the caller's API keys
+func (s *Server) handleRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	keyID := vars["keyID"]
+
+	key, err := s.storage.RevokeAPIKey(identityFrom(r).Username, keyID)
+	if errors.Is(err, storage.ErrAPIKeyNotFound) {
+		writeError(w, http.StatusNotFound, "API key not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to revoke API key")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(key); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to encode response")
+		return
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"chat-app/internal/auth"
+	"chat-app/internal/models"
+	"chat-app/internal/storage"
+)
+
+// createTestAPIKey creates an API key as owner and returns its token
+func createTestAPIKey(t *testing.T, server *Server, owner string, req models.CreateAPIKeyRequest) (models.APIKey, string) {
+	t.Helper()
+	rr := postTestJSON(server, "/api/keys", req, testAuthHeader(t, server, owner))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Failed to create API key: status %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp models.CreateAPIKeyResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode API key: %v", err)
+	}
+	return *resp.Key, resp.Token
+}
+
+func bearer(token string) http.Header {
+	return http.Header{"Authorization": {"Bearer " + token}}
+}
+
+func serveTestRequest(server *Server, method, path string, header http.Header) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestAPIKeys(t *testing.T) {
+	server := NewServer(storage.NewStorage())
+	alerts := createTestChat(t, server, "Alerts")
+	general := createTestChat(t, server, "General")
+	// Bots post with the role of the user who owns their key
+	if _, err := server.storage.CreateUser("alice", "unusable"); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	joinTestChat(t, server, alerts.ID, "alice")
+	joinTestChat(t, server, general.ID, "alice")
+
+	t.Run("CreateAndPost", func(t *testing.T) {
+		key, token := createTestAPIKey(t, server, "alice", models.CreateAPIKeyRequest{
+			BotName: "ci-bot",
+			Chats:   []string{alerts.ID},
+			Scopes:  []string{models.ScopeWrite, models.ScopeWrite},
+		})
+		if !strings.HasPrefix(token, auth.APIKeyPrefix) {
+			t.Errorf("Expected token with prefix %s, got %s", auth.APIKeyPrefix, token)
+		}
+		if key.Owner != "alice" || key.BotName != "ci-bot" || len(key.Scopes) != 1 {
+			t.Errorf("Expected a write key for ci-bot owned by alice, got %+v", key)
+		}
+
+		rr := postTestJSON(server, "/api/chats/"+alerts.ID+"/messages", models.SendMessageRequest{Content: "Build passed"}, bearer(token))
+		if status := rr.Code; status != http.StatusCreated {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+		}
+		var message models.Message
+		if err := json.NewDecoder(rr.Body).Decode(&message); err != nil {
+			t.Fatalf("Failed to decode message: %v", err)
+		}
+		if !message.Bot || message.Username != "ci-bot" {
+			t.Errorf("Expected a bot message from ci-bot, got %+v", message)
+		}
+
+		// Messages from users are not flagged
+		if msg := sendTestMessage(t, server, alerts.ID, "alice", "Thanks"); msg.Bot {
+			t.Error("Expected a user message not to be flagged as from a bot")
+		}
+	})
+
+	t.Run("Scopes", func(t *testing.T) {
+		_, writer := createTestAPIKey(t, server, "alice", models.CreateAPIKeyRequest{
+			BotName: "alert-bot",
+			Chats:   []string{alerts.ID},
+			Scopes:  []string{models.ScopeWrite},
+		})
+		_, reader := createTestAPIKey(t, server, "alice", models.CreateAPIKeyRequest{
+			BotName: "alert-bot",
+			Chats:   []string{alerts.ID},
+			Scopes:  []string{models.ScopeRead},
+		})
+		_, global := createTestAPIKey(t, server, "alice", models.CreateAPIKeyRequest{
+			BotName: "alert-bot",
+			Scopes:  []string{models.ScopeRead, models.ScopeWrite},
+		})
+
+		send := models.SendMessageRequest{Content: "Disk full"}
+		tests := []struct {
+			name   string
+			method string
+			path   string
+			token  string
+			want   int
+		}{
+			{"WriteKeyPostsToItsChat", "POST", "/api/chats/" + alerts.ID + "/messages", writer, http.StatusCreated},
+			{"WriteKeyCannotPostElsewhere", "POST", "/api/chats/" + general.ID + "/messages", writer, http.StatusForbidden},
+			{"WriteKeyCannotRead", "GET", "/api/chats/" + alerts.ID + "/messages", writer, http.StatusForbidden},
+			{"ReadKeyReadsItsChat", "GET", "/api/chats/" + alerts.ID + "/messages", reader, http.StatusOK},
+			{"ReadKeyCannotReadElsewhere", "GET", "/api/chats/" + general.ID + "/messages", reader, http.StatusForbidden},
+			{"ReadKeyCannotPost", "POST", "/api/chats/" + alerts.ID + "/messages", reader, http.StatusForbidden},
+			{"ReadKeyListsChats", "GET", "/api/chats", reader, http.StatusOK},
+			{"ChatKeyCannotCreateChats", "POST", "/api/chats", writer, http.StatusForbidden},
+			{"GlobalKeyPostsAnywhere", "POST", "/api/chats/" + general.ID + "/messages", global, http.StatusCreated},
+			{"KeyCannotManageKeys", "GET", "/api/keys", global, http.StatusForbidden},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				var rr *httptest.ResponseRecorder
+				if tt.method == "POST" {
+					body := any(send)
+					if tt.path == "/api/chats" {
+						body = models.CreateChatRequest{Name: "Bot Chat"}
+					}
+					rr = postTestJSON(server, tt.path, body, bearer(tt.token))
+				} else {
+					rr = serveTestRequest(server, tt.method, tt.path, bearer(tt.token))
+				}
+				if status := rr.Code; status != tt.want {
+					t.Errorf("handler returned wrong status code: got %v want %v", status, tt.want)
+				}
+			})
+		}
+	})
+
+	t.Run("Create_Invalid", func(t *testing.T) {
+		if _, err := server.storage.CreateUser("carol", "unusable"); err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		tests := []struct {
+			name string
+			req  models.CreateAPIKeyRequest
+			want int
+		}{
+			{"NoScopes", models.CreateAPIKeyRequest{BotName: "some-bot"}, http.StatusBadRequest},
+			{"UnknownScope", models.CreateAPIKeyRequest{BotName: "some-bot", Scopes: []string{"admin"}}, http.StatusBadRequest},
+			{"InvalidBotName", models.CreateAPIKeyRequest{BotName: "a bot", Scopes: []string{models.ScopeRead}}, http.StatusBadRequest},
+			{"UnknownChat", models.CreateAPIKeyRequest{BotName: "some-bot", Chats: []string{"nonexistent"}, Scopes: []string{models.ScopeRead}}, http.StatusBadRequest},
+			{"BotNameIsUsername", models.CreateAPIKeyRequest{BotName: "carol", Scopes: []string{models.ScopeRead}}, http.StatusConflict},
+			{"BotOwnedByAnotherUser", models.CreateAPIKeyRequest{BotName: "ci-bot", Scopes: []string{models.ScopeRead}}, http.StatusConflict},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				rr := postTestJSON(server, "/api/keys", tt.req, testAuthHeader(t, server, "bob"))
+				if status := rr.Code; status != tt.want {
+					t.Errorf("handler returned wrong status code: got %v want %v", status, tt.want)
+				}
+			})
+		}
+
+		rr := postTestJSON(server, "/api/keys", models.CreateAPIKeyRequest{BotName: "some-bot", Scopes: []string{models.ScopeRead}}, nil)
+		if status := rr.Code; status != http.StatusUnauthorized {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("ListAndRevoke", func(t *testing.T) {
+		key, token := createTestAPIKey(t, server, "dave", models.CreateAPIKeyRequest{
+			BotName: "deploy-bot",
+			Scopes:  []string{models.ScopeWrite},
+		})
+
+		rr := serveTestRequest(server, "GET", "/api/keys", testAuthHeader(t, server, "dave"))
+		var keys []models.APIKey
+		if err := json.NewDecoder(rr.Body).Decode(&keys); err != nil {
+			t.Fatalf("Failed to decode keys: %v", err)
+		}
+		if len(keys) != 1 || keys[0].ID != key.ID {
+			t.Fatalf("Expected dave's one key, got %+v", keys)
+		}
+		if strings.Contains(rr.Body.String(), "token") {
+			t.Errorf("Expected listing without tokens, got %s", rr.Body.String())
+		}
+
+		rr = serveTestRequest(server, "DELETE", "/api/keys/"+key.ID, testAuthHeader(t, server, "alice"))
+		if status := rr.Code; status != http.StatusNotFound {
+			t.Errorf("handler returned wrong status code revoking another user's key: got %v want %v", status, http.StatusNotFound)
+		}
+
+		rr = serveTestRequest(server, "DELETE", "/api/keys/"+key.ID, testAuthHeader(t, server, "dave"))
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+
+		rr = postTestJSON(server, "/api/chats/"+general.ID+"/messages", models.SendMessageRequest{Content: "Deployed"}, bearer(token))
+		if status := rr.Code; status != http.StatusUnauthorized {
+			t.Errorf("handler returned wrong status code for a revoked key: got %v want %v", status, http.StatusUnauthorized)
+		}
+	})
+}
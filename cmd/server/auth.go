@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"chat-app/internal/auth"
+	"chat-app/internal/models"
+	"chat-app/internal/storage"
+
+	"github.com/gorilla/mux"
+)
+
+// defaultSessionTTL is how long a login session lasts
+const defaultSessionTTL = 7 * 24 * time.Hour
+
+// identity is the authenticated caller of a request
+type identity struct {
+	UserID   string
+	Username string
+	// Key is set when the caller is a bot using an API key
+	Key *models.APIKey
+}
+
+// can reports whether the caller may act with scope on chatID. Users may do
+// anything; API keys are limited to their scopes and chats. An empty chatID
+// stands for requests not tied to one chat, which keys limited to certain
+// chats may only make to read.
+func (id *identity) can(scope, chatID string) bool {
+	if id.Key == nil {
+		return true
+	}
+	if !slices.Contains(id.Key.Scopes, scope) {
+		return false
+	}
+	if len(id.Key.Chats) == 0 {
+		return true
+	}
+	if chatID == "" {
+		return scope == models.ScopeRead
+	}
+	return slices.Contains(id.Key.Chats, chatID)
+}
+
+// requestScope is the API key scope a request needs
+func requestScope(r *http.Request) string {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return models.ScopeRead
+	}
+	return models.ScopeWrite
+}
+
+type identityKey struct{}
+
+// identityFrom returns the authenticated caller of r, or nil for anonymous
+// requests
+func identityFrom(r *http.Request) *identity {
+	id, _ := r.Context().Value(identityKey{}).(*identity)
+	return id
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer" header
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return "", false
+	}
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// unauthorized rejects a request that lacks valid credentials
+func unauthorized(w http.ResponseWriter, text string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="chat-app"`)
+	writeError(w, http.StatusUnauthorized, text)
+}
+
+// authenticate resolves the session token or API key of a request, if any,
+// into an identity on the request context. Requests with a credential that
+// is malformed, unknown, expired or revoked are rejected, as are API key
+// requests outside the key's scopes. Requests without one pass through
+// anonymously.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token, ok := bearerToken(r)
+		if !ok {
+			unauthorized(w, "Malformed Authorization header")
+			return
+		}
+		id := s.resolveToken(token)
+		if id == nil {
+			unauthorized(w, "Invalid, expired or revoked credentials")
+			return
+		}
+		// Routes are matched before middleware runs, so the chat is known
+		if !id.can(requestScope(r), mux.Vars(r)["chatID"]) {
+			writeError(w, http.StatusForbidden, "API key does not allow this request")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, id)))
+	})
+}
+
+// resolveToken returns the identity a bearer token belongs to, or nil
+func (s *Server) resolveToken(token string) *identity {
+	hash := auth.HashToken(token)
+	if auth.IsAPIKey(token) {
+		if key, ok := s.storage.GetAPIKey(hash); ok {
+			return &identity{Username: key.BotName, Key: key}
+		}
+	}
+	if session, ok := s.storage.GetSession(hash); ok {
+		return &identity{UserID: session.UserID, Username: session.Username}
+	}
+	return nil
+}
+
+// requireUser rejects anonymous requests to next. Bots are let through.
+func (s *Server) requireUser(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if identityFrom(r) == nil {
+			unauthorized(w, "Authentication required")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requireSession rejects requests to next that are not made by a logged-in
+// user
+func (s *Server) requireSession(next http.HandlerFunc) http.HandlerFunc {
+	return s.requireUser(func(w http.ResponseWriter, r *http.Request) {
+		if identityFrom(r).Key != nil {
+			writeError(w, http.StatusForbidden, "API keys cannot be used here")
+			return
+		}
+		next(w, r)
+	})
+}
+
+func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
+	var req models.RegisterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := auth.ValidateUsername(req.Username); err != nil {
+		invalidField(w, "username", "Invalid username: "+err.Error())
+		return
+	}
+	if err := auth.ValidatePassword(req.Password); err != nil {
+		invalidField(w, "password", "Invalid password: "+err.Error())
+		return
+	}
+
+	// Checked up front to spare hashing; CreateUser has the final say
+	if _, exists := s.storage.GetAccount(req.Username); exists {
+		writeError(w, http.StatusConflict, "Username already taken")
+		return
+	}
+
+	hash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to create user")
+		return
+	}
+	user, err := s.storage.CreateUser(req.Username, hash)
+	if errors.Is(err, storage.ErrUsernameTaken) {
+		writeError(w, http.StatusConflict, "Username already taken")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to create user")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(user); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to encode response")
+		return
+	}
+}
+
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	var req models.LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	// Unknown users and wrong passwords are indistinguishable to the caller
+	var hash string
+	account, exists := s.storage.GetAccount(req.Username)
+	if exists {
+		hash = account.PasswordHash
+	}
+	if !auth.CheckPassword(hash, req.Password) {
+		unauthorized(w, "Invalid username or password")
+		return
+	}
+
+	token, err := auth.NewToken()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to create session")
+		return
+	}
+	session, err := s.storage.CreateSession(auth.HashToken(token), account.User.Username, s.sessionTTL)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to create session")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	resp := models.SessionResponse{Token: token, ExpiresAt: session.ExpiresAt, User: account.User}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to encode response")
+		return
+	}
+}
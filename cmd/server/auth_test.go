@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"chat-app/internal/auth"
+	"chat-app/internal/models"
+	"chat-app/internal/storage"
+)
+
+// testToken returns a session token for username, creating the user if
+// needed. It goes straight to storage to avoid hashing a password per call.
+func testToken(t *testing.T, server *Server, username string) string {
+	t.Helper()
+	if _, exists := server.storage.GetAccount(username); !exists {
+		if _, err := server.storage.CreateUser(username, "unusable"); err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+	}
+	token, err := auth.NewToken()
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+	if _, err := server.storage.CreateSession(auth.HashToken(token), username, time.Hour); err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	return token
+}
+
+// testAuthHeader returns request headers authenticating as username
+func testAuthHeader(t *testing.T, server *Server, username string) http.Header {
+	t.Helper()
+	return http.Header{"Authorization": {"Bearer " + testToken(t, server, username)}}
+}
+
+func postTestJSON(server *Server, path string, body any, header http.Header) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	req, _ := http.NewRequest("POST", path, bytes.NewBuffer(data))
+	req.Header.Set("Content-Type", "application/json")
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestAuth(t *testing.T) {
+	server := NewServer(storage.NewStorage())
+	chat := createTestChat(t, server, "Auth Chat")
+
+	t.Run("Register", func(t *testing.T) {
+		rr := postTestJSON(server, "/api/users", models.RegisterRequest{Username: "Alice", Password: "correct horse"}, nil)
+		if status := rr.Code; status != http.StatusCreated {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+		}
+		if strings.Contains(rr.Body.String(), "password") {
+			t.Errorf("Expected response without password fields, got %s", rr.Body.String())
+		}
+
+		var user models.User
+		if err := json.NewDecoder(rr.Body).Decode(&user); err != nil {
+			t.Fatalf("Failed to decode user: %v", err)
+		}
+		if user.ID == "" || user.Username != "Alice" {
+			t.Errorf("Expected user Alice with an ID, got %+v", user)
+		}
+
+		account, _ := server.storage.GetAccount("alice")
+		if account == nil || account.PasswordHash == "correct horse" {
+			t.Error("Expected the password to be stored hashed")
+		}
+	})
+
+	t.Run("Register_Invalid", func(t *testing.T) {
+		tests := []struct {
+			name string
+			req  models.RegisterRequest
+			want int
+		}{
+			{"ShortUsername", models.RegisterRequest{Username: "al", Password: "correct horse"}, http.StatusBadRequest},
+			{"BadCharacters", models.RegisterRequest{Username: "al ice", Password: "correct horse"}, http.StatusBadRequest},
+			{"ShortPassword", models.RegisterRequest{Username: "carol", Password: "short"}, http.StatusBadRequest},
+			{"TakenIgnoringCase", models.RegisterRequest{Username: "ALICE", Password: "correct horse"}, http.StatusConflict},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				rr := postTestJSON(server, "/api/users", tt.req, nil)
+				if status := rr.Code; status != tt.want {
+					t.Errorf("handler returned wrong status code: got %v want %v", status, tt.want)
+				}
+			})
+		}
+	})
+
+	t.Run("LoginAndPost", func(t *testing.T) {
+		rr := postTestJSON(server, "/api/sessions", models.LoginRequest{Username: "alice", Password: "correct horse"}, nil)
+		if status := rr.Code; status != http.StatusCreated {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+		}
+		var session models.SessionResponse
+		if err := json.NewDecoder(rr.Body).Decode(&session); err != nil {
+			t.Fatalf("Failed to decode session: %v", err)
+		}
+		if session.Token == "" || session.User == nil || session.User.Username != "Alice" {
+			t.Fatalf("Expected a token for Alice, got %+v", session)
+		}
+		if !session.ExpiresAt.After(time.Now()) {
+			t.Errorf("Expected expiry in the future, got %v", session.ExpiresAt)
+		}
+
+		header := http.Header{"Authorization": {"Bearer " + session.Token}}
+		rr = postTestJSON(server, "/api/chats/"+chat.ID+"/members", models.AddMemberRequest{Username: "alice"}, header)
+		if status := rr.Code; status != http.StatusCreated {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+		}
+
+		// The username in the body is ignored in favour of the session's
+		body := map[string]string{"username": "mallory", "content": "Hello"}
+		rr = postTestJSON(server, "/api/chats/"+chat.ID+"/messages", body, header)
+		if status := rr.Code; status != http.StatusCreated {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+		}
+		var message models.Message
+		if err := json.NewDecoder(rr.Body).Decode(&message); err != nil {
+			t.Fatalf("Failed to decode message: %v", err)
+		}
+		if message.Username != "Alice" {
+			t.Errorf("Expected username 'Alice', got '%s'", message.Username)
+		}
+	})
+
+	t.Run("Login_Rejected", func(t *testing.T) {
+		for _, req := range []models.LoginRequest{
+			{Username: "alice", Password: "wrong horse"},
+			{Username: "nobody", Password: "correct horse"},
+		} {
+			rr := postTestJSON(server, "/api/sessions", req, nil)
+			if status := rr.Code; status != http.StatusUnauthorized {
+				t.Errorf("handler returned wrong status code for %s: got %v want %v", req.Username, status, http.StatusUnauthorized)
+			}
+		}
+	})
+
+	t.Run("SendMessage_Unauthenticated", func(t *testing.T) {
+		expired := NewServer(storage.NewStorage())
+		expired.sessionTTL = -time.Second
+		expiredChat := createTestChat(t, expired, "Expired Chat")
+		if _, err := expired.storage.CreateUser("alice", "unusable"); err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		if _, err := expired.storage.CreateSession(auth.HashToken("stale"), "alice", expired.sessionTTL); err != nil {
+			t.Fatalf("Failed to create session: %v", err)
+		}
+
+		tests := []struct {
+			name          string
+			authorization string
+		}{
+			{"NoHeader", ""},
+			{"WrongScheme", "Basic YWxpY2U6cGFzc3dvcmQ="},
+			{"UnknownToken", "Bearer not-a-real-token"},
+			{"ExpiredToken", "Bearer stale"},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				header := http.Header{}
+				if tt.authorization != "" {
+					header.Set("Authorization", tt.authorization)
+				}
+				rr := postTestJSON(expired, "/api/chats/"+expiredChat.ID+"/messages", models.SendMessageRequest{Content: "Hello"}, header)
+				if status := rr.Code; status != http.StatusUnauthorized {
+					t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnauthorized)
+				}
+				if rr.Header().Get("WWW-Authenticate") == "" {
+					t.Error("Expected a WWW-Authenticate header")
+				}
+			})
+		}
+	})
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"chat-app/internal/models"
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// chatOrders are the orderings accepted by the sort parameter of chat
+// listings. Ties are broken by chat ID so that pages stay stable.
+var chatOrders = map[string]func(a, b *models.ChatSummary) int{
+	"created": func(a, b *models.ChatSummary) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	},
+	"activity": func(a, b *models.ChatSummary) int {
+		return b.LastActivityAt.Compare(a.LastActivityAt)
+	},
+	"name": func(a, b *models.ChatSummary) int {
+		return cmp.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+	},
+}
+
+// handleListChats lists the chats the caller can see with a summary of their
+// messages. Archived chats are left out unless archived=true is given. The
+// list is sorted by sort (created, activity or name; created by default) and
+// filtered by q, which must appear in the name or topic. Giving limit or
+// cursor returns a page instead of the full list.
+func (s *Server) handleListChats(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	order := values.Get("sort")
+	if order == "" {
+		order = "created"
+	}
+	compare, ok := chatOrders[order]
+	if !ok {
+		invalidField(w, "sort", "sort must be created, activity or name")
+		return
+	}
+	limit := defaultPageLimit
+	if raw := values.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			invalidField(w, "limit", "limit must be a positive integer")
+			return
+		}
+		limit = min(n, maxPageLimit)
+	}
+
+	caller := identityFrom(r)
+	withArchived := values.Get("archived") == "true"
+	search := strings.ToLower(values.Get("q"))
+	chats := []*models.ChatSummary{}
+	for _, chat := range s.storage.ListChats() {
+		if chat.ArchivedAt != nil && !withArchived {
+			continue
+		}
+		// Direct chats are listed separately by GET /api/dms
+		if chat.Visibility == models.VisibilityDirect || !s.canRead(chat, caller) {
+			continue
+		}
+		if search != "" && !strings.Contains(strings.ToLower(chat.Name), search) &&
+			!strings.Contains(strings.ToLower(chat.Topic), search) {
+			continue
+		}
+		if summary, exists := s.storage.GetChatSummary(chat.ID); exists {
+			chats = append(chats, summary)
+		}
+	}
+	compare = byID(compare)
+	slices.SortFunc(chats, compare)
+
+	if !values.Has("limit") && !values.Has("cursor") {
+		writeJSON(w, http.StatusOK, chats)
+		return
+	}
+	page, err := pageChats(chats, order, compare, values.Get("cursor"), limit)
+	if err != nil {
+		invalidField(w, "cursor", "Invalid cursor")
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// byID breaks the ties of compare by chat ID, so that every chat has its own
+// place in the order
+func byID(compare func(a, b *models.ChatSummary) int) func(a, b *models.ChatSummary) int {
+	return func(a, b *models.ChatSummary) int {
+		if c := compare(a, b); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	}
+}
+
+// chatCursor marks the last chat of a page by its place in the order: the
+// sort key and ID. The next page resumes strictly after that place, so chats
+// archived, deleted or moved in the meantime do not upset paging.
+type chatCursor struct {
+	Sort string     `json:"sort"`
+	ID   string     `json:"id"`
+	Name string     `json:"name,omitempty"`
+	Time *time.Time `json:"time,omitempty"`
+}
+
+// encodeChatCursor returns the cursor following chat in the order sort
+func encodeChatCursor(sort string, chat *models.ChatSummary) string {
+	cursor := chatCursor{Sort: sort, ID: chat.ID}
+	switch sort {
+	case "created":
+		cursor.Time = &chat.CreatedAt
+	case "activity":
+		cursor.Time = &chat.LastActivityAt
+	case "name":
+		cursor.Name = chat.Name
+	}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeChatCursor returns the place in the order sort a cursor marks, as a
+// summary holding just what the order compares
+func decodeChatCursor(sort, raw string) (*models.ChatSummary, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var cursor chatCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.Sort != sort || cursor.ID == "" {
+		return nil, errInvalidCursor
+	}
+	place := &models.ChatSummary{Chat: &models.Chat{ID: cursor.ID, Name: cursor.Name}}
+	if sort != "name" {
+		if cursor.Time == nil {
+			return nil, errInvalidCursor
+		}
+		place.CreatedAt = *cursor.Time
+		place.LastActivityAt = *cursor.Time
+	}
+	return place, nil
+}
+
+// pageChats returns up to limit chats of the sorted list chats following the
+// place cursor marks, or from the start if cursor is empty
+func pageChats(chats []*models.ChatSummary, sort string, compare func(a, b *models.ChatSummary) int, cursor string, limit int) (models.ChatPageResponse, error) {
+	start := 0
+	if cursor != "" {
+		after, err := decodeChatCursor(sort, cursor)
+		if err != nil {
+			return models.ChatPageResponse{}, err
+		}
+		i, found := slices.BinarySearchFunc(chats, after, compare)
+		if found {
+			i++
+		}
+		start = i
+	}
+	end := min(start+limit, len(chats))
+	page := models.ChatPageResponse{Chats: chats[start:end]}
+	if end < len(chats) {
+		page.NextCursor = encodeChatCursor(sort, chats[end-1])
+	}
+	return page, nil
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"chat-app/internal/models"
+	"chat-app/internal/storage"
+)
+
+func TestChatLifecycle(t *testing.T) {
+	server := NewServer(storage.NewStorage())
+	owner := testAuthHeader(t, server, "owner")
+	alice := testAuthHeader(t, server, "alice")
+
+	chat := createTestChat(t, server, "Project")
+	base := "/api/chats/" + chat.ID
+	msg := sendTestMessage(t, server, chat.ID, "alice", "Kickoff")
+
+	update := func(header http.Header, req models.UpdateChatRequest, want int) models.Chat {
+		t.Helper()
+		rr := serveTestJSON(server, "PATCH", base, req, header)
+		if status := rr.Code; status != want {
+			t.Fatalf("handler returned wrong status code: got %v want %v: %s", status, want, rr.Body.String())
+		}
+		var updated models.Chat
+		_ = json.NewDecoder(rr.Body).Decode(&updated)
+		return updated
+	}
+	listChats := func(query string) []*models.Chat {
+		t.Helper()
+		rr := serveTestRequest(server, "GET", "/api/chats"+query, nil)
+		var chats []*models.Chat
+		if err := json.NewDecoder(rr.Body).Decode(&chats); err != nil {
+			t.Fatalf("Failed to decode chats: %v", err)
+		}
+		return chats
+	}
+	text := func(s string) *string { return &s }
+	flag := func(b bool) *bool { return &b }
+
+	t.Run("Describe", func(t *testing.T) {
+		updated := update(owner, models.UpdateChatRequest{Topic: text("Shipping v2"), Description: text("Everything about v2")}, http.StatusOK)
+		if updated.Name != "Project" || updated.Topic != "Shipping v2" || updated.Description != "Everything about v2" {
+			t.Errorf("Expected the topic and description to change, got %+v", updated)
+		}
+
+		rr := serveTestRequest(server, "GET", base, nil)
+		var got models.Chat
+		if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+			t.Fatalf("Failed to decode chat: %v", err)
+		}
+		if got.Topic != "Shipping v2" {
+			t.Errorf("Expected the topic in the chat, got %+v", got)
+		}
+
+		update(alice, models.UpdateChatRequest{Topic: text("Mine now")}, http.StatusForbidden)
+		update(owner, models.UpdateChatRequest{Topic: text(strings.Repeat("x", maxTopicLength+1))}, http.StatusBadRequest)
+	})
+
+	t.Run("Archive", func(t *testing.T) {
+		archived := update(owner, models.UpdateChatRequest{Archived: flag(true)}, http.StatusOK)
+		if archived.ArchivedAt == nil {
+			t.Fatalf("Expected the chat to be archived, got %+v", archived)
+		}
+
+		if chats := listChats(""); len(chats) != 0 {
+			t.Errorf("Expected archived chats to be hidden, got %+v", chats)
+		}
+		if chats := listChats("?archived=true"); len(chats) != 1 {
+			t.Errorf("Expected archived chats on request, got %+v", chats)
+		}
+
+		// Archived chats are read-only but can still be read
+		send := models.SendMessageRequest{Content: "Too late"}
+		if rr := postTestJSON(server, base+"/messages", send, alice); rr.Code != http.StatusForbidden {
+			t.Errorf("Expected posting to be refused, got status %v", rr.Code)
+		}
+		edit := models.EditMessageRequest{Content: "Changed"}
+		if rr := serveTestJSON(server, "PATCH", base+"/messages/"+msg.ID, edit, alice); rr.Code != http.StatusForbidden {
+			t.Errorf("Expected editing to be refused, got status %v", rr.Code)
+		}
+		react := models.ReactionRequest{Emoji: ":eyes:"}
+		if rr := serveTestJSON(server, "POST", base+"/messages/"+msg.ID+"/reactions", react, alice); rr.Code != http.StatusForbidden {
+			t.Errorf("Expected reacting to be refused, got status %v", rr.Code)
+		}
+		if rr := serveTestRequest(server, "GET", base+"/messages", nil); rr.Code != http.StatusOK {
+			t.Errorf("Expected the history to stay readable, got status %v", rr.Code)
+		}
+
+		update(alice, models.UpdateChatRequest{Archived: flag(false)}, http.StatusForbidden)
+		if restored := update(owner, models.UpdateChatRequest{Archived: flag(false)}, http.StatusOK); restored.ArchivedAt != nil {
+			t.Errorf("Expected the chat to be restored, got %+v", restored)
+		}
+		if rr := postTestJSON(server, base+"/messages", send, alice); rr.Code != http.StatusCreated {
+			t.Errorf("Expected posting to work again, got status %v", rr.Code)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if rr := serveTestRequest(server, "DELETE", base, alice); rr.Code != http.StatusForbidden {
+			t.Errorf("Expected members not to delete the chat, got status %v", rr.Code)
+		}
+		if rr := serveTestRequest(server, "DELETE", base, owner); rr.Code != http.StatusNoContent {
+			t.Fatalf("Expected the owner to delete the chat, got status %v", rr.Code)
+		}
+		if rr := serveTestRequest(server, "GET", base+"/messages", nil); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected the messages to be gone, got status %v", rr.Code)
+		}
+		if _, exists := server.storage.GetMessage(msg.ID); exists {
+			t.Error("Expected the stored messages to be removed")
+		}
+	})
+}
+
+func TestListChats(t *testing.T) {
+	server := NewServer(storage.NewStorage())
+	alpha := createTestChat(t, server, "alpha")
+	bravo := createTestChat(t, server, "Bravo")
+	charlie := createTestChat(t, server, "charlie")
+	sendTestMessage(t, server, bravo.ID, "alice", "Morning")
+	sendTestMessage(t, server, alpha.ID, "bob", "Hi all")
+
+	list := func(query string, want int) *httptest.ResponseRecorder {
+		t.Helper()
+		rr := serveTestRequest(server, "GET", "/api/chats"+query, nil)
+		if status := rr.Code; status != want {
+			t.Fatalf("handler returned wrong status code: got %v want %v: %s", status, want, rr.Body.String())
+		}
+		return rr
+	}
+	names := func(query string) []string {
+		t.Helper()
+		var chats []*models.ChatSummary
+		if err := json.NewDecoder(list(query, http.StatusOK).Body).Decode(&chats); err != nil {
+			t.Fatalf("Failed to decode chats: %v", err)
+		}
+		listed := make([]string, len(chats))
+		for i, chat := range chats {
+			listed[i] = chat.Name
+		}
+		return listed
+	}
+
+	t.Run("Sort", func(t *testing.T) {
+		for query, want := range map[string]string{
+			"":               "alpha Bravo charlie",
+			"?sort=created":  "alpha Bravo charlie",
+			"?sort=activity": "alpha Bravo charlie",
+			"?sort=name":     "alpha Bravo charlie",
+		} {
+			if got := strings.Join(names(query), " "); got != want {
+				t.Errorf("GET /api/chats%s: got %q want %q", query, got, want)
+			}
+		}
+		sendTestMessage(t, server, charlie.ID, "alice", "Back")
+		if got := strings.Join(names("?sort=activity"), " "); got != "charlie alpha Bravo" {
+			t.Errorf("Expected the most recently active chat first, got %q", got)
+		}
+		list("?sort=size", http.StatusBadRequest)
+	})
+
+	t.Run("Summary", func(t *testing.T) {
+		var chats []*models.ChatSummary
+		_ = json.NewDecoder(list("?q=ALPH", http.StatusOK).Body).Decode(&chats)
+		if len(chats) != 1 || chats[0].ID != alpha.ID {
+			t.Fatalf("Expected only alpha to match, got %+v", chats)
+		}
+		if chats[0].MessageCount != 1 || chats[0].LastMessage == nil || chats[0].LastMessage.Content != "Hi all" {
+			t.Errorf("Expected alpha's message in the summary, got %+v", chats[0])
+		}
+	})
+
+	t.Run("Pages", func(t *testing.T) {
+		var seen []string
+		cursor := ""
+		for pages := 0; pages < 5; pages++ {
+			var page models.ChatPageResponse
+			_ = json.NewDecoder(list("?sort=name&limit=2&cursor="+cursor, http.StatusOK).Body).Decode(&page)
+			for _, chat := range page.Chats {
+				seen = append(seen, chat.Name)
+			}
+			if cursor = page.NextCursor; cursor == "" {
+				break
+			}
+		}
+		if got := strings.Join(seen, " "); got != "alpha Bravo charlie" {
+			t.Errorf("Expected every chat once across pages, got %q", got)
+		}
+		list("?cursor=nonexistent", http.StatusBadRequest)
+		list("?limit=0", http.StatusBadRequest)
+	})
+
+	page := func(query string) ([]string, string) {
+		t.Helper()
+		var page models.ChatPageResponse
+		if err := json.NewDecoder(list(query, http.StatusOK).Body).Decode(&page); err != nil {
+			t.Fatalf("Failed to decode page: %v", err)
+		}
+		listed := make([]string, len(page.Chats))
+		for i, chat := range page.Chats {
+			listed[i] = chat.Name
+		}
+		return listed, page.NextCursor
+	}
+
+	t.Run("PagesAfterChanges", func(t *testing.T) {
+		// The chat a cursor points at moving up must not repeat anything
+		first, cursor := page("?sort=activity&limit=2")
+		if got := strings.Join(first, " "); got != "charlie alpha" {
+			t.Fatalf("Expected the two most active chats, got %q", got)
+		}
+		sendTestMessage(t, server, alpha.ID, "bob", "Again")
+		if rest, _ := page("?sort=activity&limit=2&cursor=" + cursor); strings.Join(rest, " ") != "Bravo" {
+			t.Errorf("Expected the page to resume after alpha's old place, got %q", rest)
+		}
+		list("?sort=name&limit=2&cursor="+cursor, http.StatusBadRequest)
+
+		// Nor must the chat a cursor points at disappearing break paging
+		first, cursor = page("?sort=name&limit=1")
+		if got := strings.Join(first, " "); got != "alpha" {
+			t.Fatalf("Expected alpha first, got %q", got)
+		}
+		archive := true
+		owner := testAuthHeader(t, server, "owner")
+		if rr := serveTestJSON(server, "PATCH", "/api/chats/"+alpha.ID, models.UpdateChatRequest{Archived: &archive}, owner); rr.Code != http.StatusOK {
+			t.Fatalf("Failed to archive chat: status %d", rr.Code)
+		}
+		if rest, _ := page("?sort=name&limit=5&cursor=" + cursor); strings.Join(rest, " ") != "Bravo charlie" {
+			t.Errorf("Expected paging to go on past the archived chat, got %q", rest)
+		}
+	})
+
+	t.Run("APIKeyScope", func(t *testing.T) {
+		_, token := createTestAPIKey(t, server, "alice", models.CreateAPIKeyRequest{
+			BotName: "lister", Scopes: []string{models.ScopeRead}, Chats: []string{bravo.ID},
+		})
+		rr := serveTestRequest(server, "GET", "/api/chats", bearer(token))
+		var chats []*models.ChatSummary
+		if err := json.NewDecoder(rr.Body).Decode(&chats); err != nil {
+			t.Fatalf("Failed to decode chats: %v: %s", err, rr.Body.String())
+		}
+		if len(chats) != 1 || chats[0].ID != bravo.ID {
+			t.Errorf("Expected a key to list only its own chats, got %+v", chats)
+		}
+	})
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"chat-app/internal/models"
+	"chat-app/internal/storage"
+)
+
+// handleOpenDirectChat returns the caller's direct chat with another user,
+// creating it on first use
+func (s *Server) handleOpenDirectChat(w http.ResponseWriter, r *http.Request) {
+	var req models.DirectChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.Username == "" {
+		invalidField(w, "username", "Username is required")
+		return
+	}
+
+	chat, created, err := s.storage.OpenDirectChat(identityFrom(r).Username, req.Username)
+	if errors.Is(err, storage.ErrUserNotFound) {
+		writeError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if errors.Is(err, storage.ErrSameUser) {
+		invalidField(w, "username", "Cannot start a direct chat with yourself")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to open direct chat")
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, chat)
+}
+
+func (s *Server) handleListDirectChats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.storage.ListDirectChats(identityFrom(r).Username))
+}
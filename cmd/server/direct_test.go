@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"chat-app/internal/models"
+	"chat-app/internal/storage"
+)
+
+func TestDirectChats(t *testing.T) {
+	server := NewServer(storage.NewStorage())
+	alice := testAuthHeader(t, server, "alice")
+	bob := testAuthHeader(t, server, "bob")
+	mallory := testAuthHeader(t, server, "mallory")
+	createTestChat(t, server, "Lobby")
+
+	openDirect := func(header http.Header, username string, want int) models.Chat {
+		t.Helper()
+		rr := postTestJSON(server, "/api/dms", models.DirectChatRequest{Username: username}, header)
+		if status := rr.Code; status != want {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, want)
+		}
+		var chat models.Chat
+		if err := json.NewDecoder(rr.Body).Decode(&chat); err != nil {
+			t.Fatalf("Failed to decode chat: %v", err)
+		}
+		return chat
+	}
+
+	chat := openDirect(alice, "bob", http.StatusCreated)
+	base := "/api/chats/" + chat.ID
+
+	t.Run("Unique", func(t *testing.T) {
+		if chat.Visibility != models.VisibilityDirect || len(chat.Participants) != 2 {
+			t.Errorf("Expected a direct chat between two users, got %+v", chat)
+		}
+		if again := openDirect(bob, "ALICE", http.StatusOK); again.ID != chat.ID {
+			t.Errorf("Expected the existing chat %s, got %s", chat.ID, again.ID)
+		}
+	})
+
+	t.Run("ListedSeparately", func(t *testing.T) {
+		rr := serveTestRequest(server, "GET", "/api/chats", alice)
+		var chats []*models.Chat
+		if err := json.NewDecoder(rr.Body).Decode(&chats); err != nil {
+			t.Fatalf("Failed to decode chats: %v", err)
+		}
+		if len(chats) != 1 || chats[0].Name != "Lobby" {
+			t.Errorf("Expected only the lobby in the chat list, got %+v", chats)
+		}
+
+		for _, tt := range []struct {
+			name   string
+			header http.Header
+			want   int
+		}{
+			{"Alice", alice, 1},
+			{"Bob", bob, 1},
+			{"Mallory", mallory, 0},
+		} {
+			rr := serveTestRequest(server, "GET", "/api/dms", tt.header)
+			var dms []*models.Chat
+			if err := json.NewDecoder(rr.Body).Decode(&dms); err != nil {
+				t.Fatalf("Failed to decode direct chats: %v", err)
+			}
+			if len(dms) != tt.want {
+				t.Errorf("%s: expected %d direct chats, got %d", tt.name, tt.want, len(dms))
+			}
+		}
+
+		if rr := serveTestRequest(server, "GET", "/api/dms", nil); rr.Code != http.StatusUnauthorized {
+			t.Errorf("Expected anonymous requests to be rejected, got status %v", rr.Code)
+		}
+	})
+
+	t.Run("OnlyParticipants", func(t *testing.T) {
+		send := models.SendMessageRequest{Content: "Just between us"}
+		if rr := postTestJSON(server, base+"/messages", send, bob); rr.Code != http.StatusCreated {
+			t.Errorf("Expected participants to post, got status %v", rr.Code)
+		}
+
+		tests := []struct {
+			name   string
+			method string
+			path   string
+			body   any
+			header http.Header
+			want   int
+		}{
+			{"OutsiderReads", "GET", base + "/messages", nil, mallory, http.StatusNotFound},
+			{"OutsiderPosts", "POST", base + "/messages", send, mallory, http.StatusNotFound},
+			{"OutsiderJoins", "POST", base + "/members", models.AddMemberRequest{Username: "mallory"}, mallory, http.StatusNotFound},
+			{"AnonymousReads", "GET", base + "/messages", nil, nil, http.StatusNotFound},
+			{"ParticipantAddsMember", "POST", base + "/members", models.AddMemberRequest{Username: "mallory"}, alice, http.StatusForbidden},
+			{"ParticipantInvites", "POST", base + "/invites", models.CreateInviteRequest{}, alice, http.StatusBadRequest},
+			{"ParticipantLeaves", "DELETE", base + "/members/alice", nil, alice, http.StatusBadRequest},
+			{"ParticipantRenames", "PATCH", base, models.UpdateChatRequest{Name: "Us"}, alice, http.StatusForbidden},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				rr := serveTestJSON(server, tt.method, tt.path, tt.body, tt.header)
+				if status := rr.Code; status != tt.want {
+					t.Errorf("handler returned wrong status code: got %v want %v", status, tt.want)
+				}
+			})
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			username string
+			want     int
+		}{
+			{"Self", "alice", http.StatusBadRequest},
+			{"UnknownUser", "nobody", http.StatusNotFound},
+			{"MissingUsername", "", http.StatusBadRequest},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				rr := postTestJSON(server, "/api/dms", models.DirectChatRequest{Username: tt.username}, alice)
+				if status := rr.Code; status != tt.want {
+					t.Errorf("handler returned wrong status code: got %v want %v", status, tt.want)
+				}
+			})
+		}
+	})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"chat-app/internal/models"
+	"chat-app/internal/storage"
+
+	"github.com/gorilla/mux"
+)
+
+// chatMessage returns the message named by the request, writing a 404 if it
+// does not exist or belongs to another chat
+func (s *Server) chatMessage(w http.ResponseWriter, r *http.Request) (*models.Message, bool) {
+	vars := mux.Vars(r)
+	msg, exists := s.storage.GetMessage(vars["messageID"])
+	if !exists || msg.ChatID != vars["chatID"] {
+		writeError(w, http.StatusNotFound, "Message not found")
+		return nil, false
+	}
+	return msg, true
+}
+
+// isAuthor reports whether id wrote msg. Bots and users never share a name,
+// but a bot's messages are only its own while it uses its key.
+func isAuthor(msg *models.Message, id *identity) bool {
+	return strings.EqualFold(msg.Username, id.Username) && msg.Bot == (id.Key != nil)
+}
+
+// handleEditMessage replaces the content of a message. Only its author may
+// edit it, and only while they may still post in the chat.
+func (s *Server) handleEditMessage(w http.ResponseWriter, r *http.Request) {
+	var req models.EditMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.Content == "" {
+		invalidField(w, "content", "Content is required")
+		return
+	}
+
+	msg, ok := s.chatMessage(w, r)
+	if !ok {
+		return
+	}
+	caller := identityFrom(r)
+	if !isAuthor(msg, caller) || !s.hasRole(msg.ChatID, caller, models.RoleMember) {
+		writeError(w, http.StatusForbidden, "Only the author can edit this message")
+		return
+	}
+
+	edited, err := s.storage.EditMessage(msg.ID, req.Content)
+	if errors.Is(err, storage.ErrMessageNotFound) {
+		writeError(w, http.StatusNotFound, "Message not found")
+		return
+	}
+	if errors.Is(err, storage.ErrMessageDeleted) {
+		writeError(w, http.StatusConflict, "Message was deleted")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to edit message")
+		return
+	}
+	writeJSON(w, http.StatusOK, edited)
+}
+
+// handleDeleteMessage replaces a message with a tombstone. Authors may
+// delete their own messages and chat admins anyone's.
+func (s *Server) handleDeleteMessage(w http.ResponseWriter, r *http.Request) {
+	msg, ok := s.chatMessage(w, r)
+	if !ok {
+		return
+	}
+	caller := identityFrom(r)
+	if !isAuthor(msg, caller) && !s.hasRole(msg.ChatID, caller, models.RoleAdmin) {
+		writeError(w, http.StatusForbidden, "Not allowed to delete this message")
+		return
+	}
+
+	tombstone, err := s.storage.DeleteMessage(msg.ID)
+	if errors.Is(err, storage.ErrMessageNotFound) {
+		writeError(w, http.StatusNotFound, "Message not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to delete message")
+		return
+	}
+	writeJSON(w, http.StatusOK, tombstone)
+}
+
+func (s *Server) handleGetRevisions(w http.ResponseWriter, r *http.Request) {
+	msg, ok := s.chatMessage(w, r)
+	if !ok {
+		return
+	}
+	revisions, err := s.storage.GetRevisions(msg.ID)
+	if errors.Is(err, storage.ErrMessageNotFound) {
+		writeError(w, http.StatusNotFound, "Message not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to get revisions")
+		return
+	}
+	writeJSON(w, http.StatusOK, revisions)
+}
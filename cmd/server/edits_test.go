@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"chat-app/internal/models"
+	"chat-app/internal/storage"
+)
+
+func TestEditMessages(t *testing.T) {
+	server := NewServer(storage.NewStorage())
+	owner := testAuthHeader(t, server, "owner")
+	alice := testAuthHeader(t, server, "alice")
+	bob := testAuthHeader(t, server, "bob")
+
+	chat := createTestChat(t, server, "Edited Chat")
+	other := createTestChat(t, server, "Other Chat")
+	joinTestChat(t, server, chat.ID, "bob")
+	msg := sendTestMessage(t, server, chat.ID, "alice", "Helo")
+	path := "/api/chats/" + chat.ID + "/messages/" + msg.ID
+
+	edit := func(header http.Header, path, content string, want int) models.Message {
+		t.Helper()
+		rr := serveTestJSON(server, "PATCH", path, models.EditMessageRequest{Content: content}, header)
+		if status := rr.Code; status != want {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, want)
+		}
+		var message models.Message
+		_ = json.NewDecoder(rr.Body).Decode(&message)
+		return message
+	}
+
+	t.Run("Edit", func(t *testing.T) {
+		edited := edit(alice, path, "Hello", http.StatusOK)
+		if edited.Content != "Hello" || edited.EditedAt == nil || edited.Seq != msg.Seq {
+			t.Errorf("Expected the edited message, got %+v", edited)
+		}
+
+		rr := serveTestRequest(server, "GET", path+"/revisions", nil)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		var revisions []*models.Revision
+		if err := json.NewDecoder(rr.Body).Decode(&revisions); err != nil {
+			t.Fatalf("Failed to decode revisions: %v", err)
+		}
+		if len(revisions) != 1 || revisions[0].Content != "Helo" {
+			t.Errorf("Expected the original content as a revision, got %+v", revisions)
+		}
+	})
+
+	t.Run("Edit_Errors", func(t *testing.T) {
+		edit(bob, path, "Not mine", http.StatusForbidden)
+		edit(owner, path, "Not mine either", http.StatusForbidden)
+		edit(alice, path, "", http.StatusBadRequest)
+		edit(alice, "/api/chats/"+chat.ID+"/messages/nonexistent", "Hi", http.StatusNotFound)
+		edit(alice, "/api/chats/"+other.ID+"/messages/"+msg.ID, "Hi", http.StatusNotFound)
+		edit(nil, path, "Anonymous", http.StatusUnauthorized)
+
+		// Authors who lost the right to post cannot edit either
+		if _, err := server.storage.SetMember(chat.ID, "alice", models.RoleReadOnly); err != nil {
+			t.Fatalf("Failed to change role: %v", err)
+		}
+		edit(alice, path, "Muted", http.StatusForbidden)
+		if _, err := server.storage.SetMember(chat.ID, "alice", models.RoleMember); err != nil {
+			t.Fatalf("Failed to change role: %v", err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if rr := serveTestRequest(server, "DELETE", path, bob); rr.Code != http.StatusForbidden {
+			t.Errorf("Expected other members not to delete, got status %v", rr.Code)
+		}
+
+		rr := serveTestRequest(server, "DELETE", path, alice)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		var tombstone models.Message
+		if err := json.NewDecoder(rr.Body).Decode(&tombstone); err != nil {
+			t.Fatalf("Failed to decode message: %v", err)
+		}
+		if tombstone.DeletedAt == nil || tombstone.Content != "" || tombstone.ID != msg.ID {
+			t.Errorf("Expected a tombstone, got %+v", tombstone)
+		}
+
+		edit(alice, path, "Undelete", http.StatusConflict)
+
+		var messages []*models.Message
+		rr = serveTestRequest(server, "GET", "/api/chats/"+chat.ID+"/messages", nil)
+		if err := json.NewDecoder(rr.Body).Decode(&messages); err != nil {
+			t.Fatalf("Failed to decode messages: %v", err)
+		}
+		if len(messages) != 1 || messages[0].DeletedAt == nil {
+			t.Errorf("Expected the tombstone in the history, got %+v", messages)
+		}
+	})
+
+	t.Run("AdminsDeleteAnyMessage", func(t *testing.T) {
+		sent := sendTestMessage(t, server, chat.ID, "bob", "Spam")
+		rr := serveTestRequest(server, "DELETE", "/api/chats/"+chat.ID+"/messages/"+sent.ID, owner)
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+	})
+}
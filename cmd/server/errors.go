@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"chat-app/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// requestIDHeader carries the ID of a request, which error responses repeat
+const requestIDHeader = "X-Request-ID"
+
+// errorCodes gives the error code of each status used for errors
+var errorCodes = map[int]string{
+	http.StatusBadRequest:          models.ErrorBadRequest,
+	http.StatusUnauthorized:        models.ErrorUnauthorized,
+	http.StatusForbidden:           models.ErrorForbidden,
+	http.StatusNotFound:            models.ErrorNotFound,
+	http.StatusMethodNotAllowed:    models.ErrorMethodNotAllowed,
+	http.StatusConflict:            models.ErrorConflict,
+	http.StatusGone:                models.ErrorGone,
+	http.StatusTooManyRequests:     models.ErrorQuota,
+	http.StatusInternalServerError: models.ErrorInternal,
+}
+
+// writeError responds with a JSON error envelope. The code follows from
+// status, except that bad requests with details are validation failures.
+// The request ID is taken from the X-Request-ID response header, which is
+// set if it is missing.
+func writeError(w http.ResponseWriter, status int, message string, details ...models.FieldError) {
+	code, ok := errorCodes[status]
+	if !ok {
+		code = models.ErrorInternal
+	}
+	if status == http.StatusBadRequest && len(details) > 0 {
+		code = models.ErrorValidation
+	}
+	requestID := w.Header().Get(requestIDHeader)
+	if requestID == "" {
+		requestID = uuid.New().String()
+		w.Header().Set(requestIDHeader, requestID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(models.ErrorResponse{Error: &models.APIError{
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestID: requestID,
+	}})
+}
+
+// invalidField rejects a request because of one of its fields
+func invalidField(w http.ResponseWriter, field, message string) {
+	writeError(w, http.StatusBadRequest, message, models.FieldError{Field: field, Message: message})
+}
+
+// fieldError is returned by request parsers for a field that is not valid
+type fieldError struct {
+	field   string
+	message string
+}
+
+func (e *fieldError) Error() string { return e.message }
+
+// writeInvalid rejects a request because of err, naming the field at fault
+// if err is a fieldError
+func writeInvalid(w http.ResponseWriter, err error) {
+	var invalid *fieldError
+	if errors.As(err, &invalid) {
+		invalidField(w, invalid.field, invalid.message)
+		return
+	}
+	writeError(w, http.StatusBadRequest, err.Error())
+}
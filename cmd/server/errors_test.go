@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"chat-app/internal/models"
+	"chat-app/internal/storage"
+)
+
+func TestErrorResponses(t *testing.T) {
+	server := NewServer(storage.NewStorage())
+	alice := testAuthHeader(t, server, "alice")
+	chat := createTestChat(t, server, "Errors")
+
+	decode := func(t *testing.T, method, path string, body any, header http.Header, want int) *models.APIError {
+		t.Helper()
+		rr := serveTestJSON(server, method, path, body, header)
+		if status := rr.Code; status != want {
+			t.Fatalf("handler returned wrong status code: got %v want %v: %s", status, want, rr.Body.String())
+		}
+		if contentType := rr.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("Expected a JSON error, got Content-Type %q", contentType)
+		}
+		var resp models.ErrorResponse
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil || resp.Error == nil {
+			t.Fatalf("Failed to decode error envelope: %v: %s", err, rr.Body.String())
+		}
+		if resp.Error.RequestID == "" || resp.Error.RequestID != rr.Header().Get(requestIDHeader) {
+			t.Errorf("Expected the request ID in the body and header, got %q and %q",
+				resp.Error.RequestID, rr.Header().Get(requestIDHeader))
+		}
+		return resp.Error
+	}
+
+	t.Run("NotFound", func(t *testing.T) {
+		apiErr := decode(t, "GET", "/api/chats/nonexistent", nil, nil, http.StatusNotFound)
+		if apiErr.Code != models.ErrorNotFound || apiErr.Message != "Chat not found" {
+			t.Errorf("Expected not_found, got %+v", apiErr)
+		}
+		if apiErr := decode(t, "GET", "/api/nowhere", nil, nil, http.StatusNotFound); apiErr.Code != models.ErrorNotFound {
+			t.Errorf("Expected not_found for unknown paths, got %+v", apiErr)
+		}
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		apiErr := decode(t, "GET", "/api/chats/"+chat.ID+"/messages?limit=0", nil, nil, http.StatusBadRequest)
+		if apiErr.Code != models.ErrorValidation || len(apiErr.Details) != 1 || apiErr.Details[0].Field != "limit" {
+			t.Errorf("Expected a validation error about limit, got %+v", apiErr)
+		}
+		apiErr = decode(t, "POST", "/api/chats/"+chat.ID+"/messages", models.SendMessageRequest{}, alice, http.StatusBadRequest)
+		if apiErr.Code != models.ErrorValidation || apiErr.Details[0].Field != "content" {
+			t.Errorf("Expected a validation error about content, got %+v", apiErr)
+		}
+		if apiErr := decode(t, "POST", "/api/chats", "not an object", alice, http.StatusBadRequest); apiErr.Code != models.ErrorBadRequest {
+			t.Errorf("Expected bad_request for a malformed body, got %+v", apiErr)
+		}
+	})
+
+	t.Run("Auth", func(t *testing.T) {
+		if apiErr := decode(t, "POST", "/api/chats", models.CreateChatRequest{Name: "x"}, nil, http.StatusUnauthorized); apiErr.Code != models.ErrorUnauthorized {
+			t.Errorf("Expected unauthorized, got %+v", apiErr)
+		}
+		if apiErr := decode(t, "PUT", "/api/chats", nil, nil, http.StatusMethodNotAllowed); apiErr.Code != models.ErrorMethodNotAllowed {
+			t.Errorf("Expected method_not_allowed, got %+v", apiErr)
+		}
+	})
+
+	t.Run("Quota", func(t *testing.T) {
+		req := models.CreateAPIKeyRequest{BotName: "alice-bot", Scopes: []string{models.ScopeRead}}
+		for i := 0; i < storage.MaxAPIKeys; i++ {
+			createTestAPIKey(t, server, "alice", req)
+		}
+		if apiErr := decode(t, "POST", "/api/keys", req, alice, http.StatusTooManyRequests); apiErr.Code != models.ErrorQuota {
+			t.Errorf("Expected quota_exceeded, got %+v", apiErr)
+		}
+	})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"chat-app/internal/models"
+)
+
+// streamPollInterval is how often Shutdown checks whether the live streams
+// have closed
+const streamPollInterval = 10 * time.Millisecond
+
+// handleHealth reports that the server is alive. It answers as long as the
+// server can serve requests at all, draining or not.
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, models.HealthResponse{Status: models.HealthOK})
+}
+
+// handleReady reports whether the server should be sent new traffic. It
+// fails with 503 once the server starts draining.
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	if s.draining.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, models.HealthResponse{Status: models.HealthDraining})
+		return
+	}
+	writeJSON(w, http.StatusOK, models.HealthResponse{Status: models.HealthReady})
+}
+
+// openStreams returns the number of live streams being served
+func (s *Server) openStreams() int {
+	open := 0.0
+	for _, transport := range []string{streamWebSocket, streamSSE, streamLongPoll} {
+		open += s.metrics.streams.With(transport).Value()
+	}
+	return int(open)
+}
+
+// Shutdown drains srv, which must be serving s. Readiness fails at once,
+// while requests are still served for delay so that load balancers notice.
+// Then live streams are told to close, srv stops accepting connections and
+// Shutdown waits for in-flight requests and streams to finish until ctx is
+// done. It must only be called once.
+func (s *Server) Shutdown(ctx context.Context, srv *http.Server, delay time.Duration) error {
+	s.draining.Store(true)
+	timer := time.NewTimer(delay)
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		timer.Stop()
+	}
+
+	close(s.quit)
+	err := srv.Shutdown(ctx)
+
+	// WebSockets are hijacked connections, which srv does not wait for
+	ticker := time.NewTicker(streamPollInterval)
+	defer ticker.Stop()
+	for s.openStreams() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			if err == nil {
+				err = ctx.Err()
+			}
+			return err
+		}
+	}
+	return err
+}
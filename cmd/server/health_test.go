@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"chat-app/internal/models"
+	"chat-app/internal/storage"
+
+	"github.com/gorilla/websocket"
+)
+
+func getTestHealth(t *testing.T, url string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("Failed to get %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	var health models.HealthResponse
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		t.Fatalf("Failed to decode health: %v", err)
+	}
+	return resp.StatusCode, health.Status
+}
+
+func TestHealth(t *testing.T) {
+	server := NewServer(storage.NewStorage())
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	if status, health := getTestHealth(t, ts.URL+"/healthz"); status != http.StatusOK || health != models.HealthOK {
+		t.Errorf("Expected /healthz to be ok, got %d %q", status, health)
+	}
+	if status, health := getTestHealth(t, ts.URL+"/readyz"); status != http.StatusOK || health != models.HealthReady {
+		t.Errorf("Expected /readyz to be ready, got %d %q", status, health)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	t.Run("DrainsStreams", func(t *testing.T) {
+		server := NewServer(storage.NewStorage())
+		server.logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+		ts := httptest.NewServer(server)
+		t.Cleanup(ts.Close)
+		chat := createTestChat(t, server, "Draining")
+		base := "/api/chats/" + chat.ID
+
+		conn := dialTestWebSocket(t, ts, base+"/ws", nil)
+		events := openTestEventStream(t, ts.URL+base+"/events", "")
+		polled := make(chan int, 1)
+		go func() {
+			resp, err := http.Get(ts.URL + base + "/messages?since_seq=0&wait=30s")
+			if err != nil {
+				polled <- 0
+				return
+			}
+			resp.Body.Close()
+			polled <- resp.StatusCode
+		}()
+		deadline := time.Now().Add(2 * time.Second)
+		for server.openStreams() < 3 {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected 3 open streams, got %d", server.openStreams())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+
+		delay := 200 * time.Millisecond
+		shutdown := make(chan error, 1)
+		go func() {
+			shutdown <- server.Shutdown(context.Background(), ts.Config, delay)
+		}()
+
+		// Requests are still served during the delay, but readiness fails
+		time.Sleep(delay / 4)
+		if status, health := getTestHealth(t, ts.URL+"/readyz"); status != http.StatusServiceUnavailable || health != models.HealthDraining {
+			t.Errorf("Expected /readyz to fail while draining, got %d %q", status, health)
+		}
+		if status, health := getTestHealth(t, ts.URL+"/healthz"); status != http.StatusOK || health != models.HealthOK {
+			t.Errorf("Expected /healthz to stay ok while draining, got %d %q", status, health)
+		}
+
+		select {
+		case err := <-shutdown:
+			if err != nil {
+				t.Fatalf("Shutdown failed: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Shutdown did not finish")
+		}
+		if open := server.openStreams(); open != 0 {
+			t.Errorf("Expected no open streams after shutdown, got %d", open)
+		}
+
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, _, err := conn.ReadMessage()
+		if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+			t.Errorf("Expected the WebSocket to be closed as going away, got %v", err)
+		}
+		select {
+		case _, ok := <-events:
+			if ok {
+				t.Error("Expected the event stream to end without further events")
+			}
+		case <-time.After(2 * time.Second):
+			t.Error("Expected the event stream to end")
+		}
+		if status := <-polled; status != http.StatusOK {
+			t.Errorf("Expected the long poll to be answered, got %d", status)
+		}
+
+		if _, err := http.Get(ts.URL + "/healthz"); err == nil {
+			t.Error("Expected new connections to be refused after shutdown")
+		}
+	})
+
+	t.Run("Deadline", func(t *testing.T) {
+		server := NewServer(storage.NewStorage())
+		server.logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+		release := make(chan struct{})
+		started := make(chan struct{})
+		server.router.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+		})
+		ts := httptest.NewServer(server)
+		t.Cleanup(ts.Close)
+		t.Cleanup(func() { close(release) })
+
+		go func() {
+			if resp, err := http.Get(ts.URL + "/slow"); err == nil {
+				resp.Body.Close()
+			}
+		}()
+		<-started
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		if err := server.Shutdown(ctx, ts.Config, 0); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected shutdown to give up at the deadline, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"chat-app/internal/auth"
+	"chat-app/internal/models"
+	"chat-app/internal/storage"
+
+	"github.com/gorilla/mux"
+)
+
+// handleCreateInvite mints an invite token for a chat. Anyone who may post
+// in the chat may invite others to it.
+func (s *Server) handleCreateInvite(w http.ResponseWriter, r *http.Request) {
+	chatID := mux.Vars(r)["chatID"]
+	caller := identityFrom(r)
+
+	var req models.CreateInviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.MaxUses < 0 {
+		invalidField(w, "max_uses", "max_uses must not be negative")
+		return
+	}
+	invite := models.Invite{ChatID: chatID, CreatedBy: caller.Username, MaxUses: req.MaxUses}
+	if req.ExpiresIn != "" {
+		ttl, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || ttl <= 0 {
+			invalidField(w, "expires_in", "expires_in must be a positive duration such as 24h")
+			return
+		}
+		expiresAt := time.Now().Add(ttl)
+		invite.ExpiresAt = &expiresAt
+	}
+
+	chat, exists := s.storage.GetChat(chatID)
+	if !exists {
+		writeError(w, http.StatusNotFound, "Chat not found")
+		return
+	}
+	if chat.Visibility == models.VisibilityDirect {
+		writeError(w, http.StatusBadRequest, "Direct chats cannot have invites")
+		return
+	}
+	if !s.hasRole(chatID, caller, models.RoleMember) {
+		writeError(w, http.StatusForbidden, "Only chat members can create invites")
+		return
+	}
+
+	token, err := auth.NewToken()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to create invite")
+		return
+	}
+	created, err := s.storage.CreateInvite(auth.HashToken(token), invite)
+	if errors.Is(err, storage.ErrChatNotFound) {
+		writeError(w, http.StatusNotFound, "Chat not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to create invite")
+		return
+	}
+	writeJSON(w, http.StatusCreated, models.CreateInviteResponse{Invite: created, Token: token})
+}
+
+// handleAcceptInvite redeems an invite token, making the caller a member of
+// its chat
+func (s *Server) handleAcceptInvite(w http.ResponseWriter, r *http.Request) {
+	var req models.AcceptInviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.Token == "" {
+		invalidField(w, "token", "Token is required")
+		return
+	}
+
+	member, err := s.storage.RedeemInvite(auth.HashToken(req.Token), identityFrom(r).Username)
+	if errors.Is(err, storage.ErrInviteNotFound) {
+		writeError(w, http.StatusNotFound, "Invite not found")
+		return
+	}
+	if errors.Is(err, storage.ErrInviteExpired) {
+		writeError(w, http.StatusGone, "Invite has expired or been used up")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to accept invite")
+		return
+	}
+	writeJSON(w, http.StatusOK, member)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"chat-app/internal/models"
+	"chat-app/internal/storage"
+)
+
+func createTestInvite(t *testing.T, server *Server, chatID string, header http.Header, req models.CreateInviteRequest) string {
+	t.Helper()
+	rr := postTestJSON(server, "/api/chats/"+chatID+"/invites", req, header)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Failed to create invite: status %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp models.CreateInviteResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode invite: %v", err)
+	}
+	if resp.Token == "" || resp.Invite == nil || resp.Invite.ChatID != chatID {
+		t.Fatalf("Expected a token for an invite to %s, got %+v", chatID, resp)
+	}
+	return resp.Token
+}
+
+func TestPrivateChats(t *testing.T) {
+	server := NewServer(storage.NewStorage())
+	owner := testAuthHeader(t, server, "owner")
+	outsider := testAuthHeader(t, server, "mallory")
+
+	rr := postTestJSON(server, "/api/chats", models.CreateChatRequest{Name: "Secret", Visibility: models.VisibilityPrivate}, owner)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Failed to create chat: status %d: %s", rr.Code, rr.Body.String())
+	}
+	var secret models.Chat
+	if err := json.NewDecoder(rr.Body).Decode(&secret); err != nil {
+		t.Fatalf("Failed to decode chat: %v", err)
+	}
+	public := createTestChat(t, server, "Lobby")
+	base := "/api/chats/" + secret.ID
+
+	listChats := func(header http.Header) []*models.Chat {
+		rr := serveTestRequest(server, "GET", "/api/chats", header)
+		var chats []*models.Chat
+		if err := json.NewDecoder(rr.Body).Decode(&chats); err != nil {
+			t.Fatalf("Failed to decode chats: %v", err)
+		}
+		return chats
+	}
+
+	t.Run("Visibility", func(t *testing.T) {
+		if secret.Visibility != models.VisibilityPrivate || public.Visibility != models.VisibilityPublic {
+			t.Errorf("Expected private and public chats, got '%s' and '%s'", secret.Visibility, public.Visibility)
+		}
+		rr := postTestJSON(server, "/api/chats", models.CreateChatRequest{Name: "Odd", Visibility: "hidden"}, owner)
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("HiddenFromNonMembers", func(t *testing.T) {
+		if chats := listChats(owner); len(chats) != 2 {
+			t.Errorf("Expected the owner to see both chats, got %d", len(chats))
+		}
+		for _, header := range []http.Header{outsider, nil} {
+			if chats := listChats(header); len(chats) != 1 || chats[0].ID != public.ID {
+				t.Errorf("Expected only the public chat to be listed, got %+v", chats)
+			}
+		}
+
+		tests := []struct {
+			name   string
+			method string
+			path   string
+			body   any
+		}{
+			{"GetMessages", "GET", base + "/messages", nil},
+			{"SendMessage", "POST", base + "/messages", models.SendMessageRequest{Content: "Hi"}},
+			{"Members", "GET", base + "/members", nil},
+			{"Join", "POST", base + "/members", models.AddMemberRequest{Username: "mallory"}},
+			{"Events", "GET", base + "/events", nil},
+			{"Invite", "POST", base + "/invites", models.CreateInviteRequest{}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				rr := serveTestJSON(server, tt.method, tt.path, tt.body, outsider)
+				if status := rr.Code; status != http.StatusNotFound {
+					t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+				}
+			})
+		}
+	})
+
+	t.Run("AcceptInvite", func(t *testing.T) {
+		token := createTestInvite(t, server, secret.ID, owner, models.CreateInviteRequest{ExpiresIn: "1h", MaxUses: 1})
+		guest := testAuthHeader(t, server, "guest")
+
+		rr := postTestJSON(server, "/api/invites/accept", models.AcceptInviteRequest{Token: token}, guest)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		var member models.Member
+		if err := json.NewDecoder(rr.Body).Decode(&member); err != nil {
+			t.Fatalf("Failed to decode member: %v", err)
+		}
+		if member.ChatID != secret.ID || member.Username != "guest" || member.Role != models.RoleMember {
+			t.Errorf("Expected guest to become a member, got %+v", member)
+		}
+
+		if chats := listChats(guest); len(chats) != 2 {
+			t.Errorf("Expected the new member to see the private chat, got %d chats", len(chats))
+		}
+		if rr := postTestJSON(server, base+"/messages", models.SendMessageRequest{Content: "Hi"}, guest); rr.Code != http.StatusCreated {
+			t.Errorf("Expected the new member to post, got status %v", rr.Code)
+		}
+
+		// The invite had a single use
+		rr = postTestJSON(server, "/api/invites/accept", models.AcceptInviteRequest{Token: token}, outsider)
+		if status := rr.Code; status != http.StatusGone {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusGone)
+		}
+	})
+
+	t.Run("MembersInvite", func(t *testing.T) {
+		token := createTestInvite(t, server, secret.ID, testAuthHeader(t, server, "guest"), models.CreateInviteRequest{})
+		rr := postTestJSON(server, "/api/invites/accept", models.AcceptInviteRequest{Token: token}, testAuthHeader(t, server, "friend"))
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			path   string
+			body   any
+			header http.Header
+			want   int
+		}{
+			{"BadExpiry", base + "/invites", models.CreateInviteRequest{ExpiresIn: "soon"}, owner, http.StatusBadRequest},
+			{"NegativeExpiry", base + "/invites", models.CreateInviteRequest{ExpiresIn: "-1h"}, owner, http.StatusBadRequest},
+			{"NegativeUses", base + "/invites", models.CreateInviteRequest{MaxUses: -1}, owner, http.StatusBadRequest},
+			{"Anonymous", base + "/invites", models.CreateInviteRequest{}, nil, http.StatusUnauthorized},
+			{"UnknownChat", "/api/chats/nonexistent/invites", models.CreateInviteRequest{}, owner, http.StatusNotFound},
+			{"UnknownToken", "/api/invites/accept", models.AcceptInviteRequest{Token: "bogus"}, outsider, http.StatusNotFound},
+			{"MissingToken", "/api/invites/accept", models.AcceptInviteRequest{}, outsider, http.StatusBadRequest},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				rr := postTestJSON(server, tt.path, tt.body, tt.header)
+				if status := rr.Code; status != tt.want {
+					t.Errorf("handler returned wrong status code: got %v want %v", status, tt.want)
+				}
+			})
+		}
+	})
+}
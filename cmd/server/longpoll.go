@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"chat-app/internal/storage"
+)
+
+const (
+	// maxWait bounds how long a long-poll request may block
+	maxWait = 60 * time.Second
+	// longPollWriteMargin is the time allowed to write a long-poll
+	// response once the wait is over
+	longPollWriteMargin = 10 * time.Second
+)
+
+// parseWait reads the wait query parameter, a duration such as "30s"
+func parseWait(r *http.Request) (time.Duration, error) {
+	raw := r.URL.Query().Get("wait")
+	if raw == "" {
+		return 0, nil
+	}
+	wait, err := time.ParseDuration(raw)
+	if err != nil || wait < 0 {
+		return 0, &fieldError{"wait", "wait must be a non-negative duration such as 30s"}
+	}
+	return min(wait, maxWait), nil
+}
+
+// waitForMessages blocks until the page selected by query contains at least
+// one message, the wait expires, the request is cancelled or the server
+// shuts down. It returns early if the page cannot be read so the caller
+// reports the error.
+func (s *Server) waitForMessages(ctx context.Context, chatID string, query storage.PageQuery, wait time.Duration) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	defer s.trackStream(streamLongPoll)()
+
+	for {
+		// Taken before the check so a message committed in between
+		// still wakes us up
+		changed := s.storage.Changed(chatID)
+
+		page, err := s.storage.GetMessagePage(chatID, query)
+		if err != nil || len(page.Messages) > 0 {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return
+		case <-ctx.Done():
+			return
+		case <-s.quit:
+			// Answer now with whatever there is rather than hold up
+			// shutdown
+			return
+		}
+	}
+}
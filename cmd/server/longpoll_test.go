@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"chat-app/internal/storage"
+)
+
+func TestLongPoll(t *testing.T) {
+	server := NewServer(storage.NewStorage())
+	chat := createTestChat(t, server, "Polled Chat")
+	first := sendTestMessage(t, server, chat.ID, "alice", "Already here")
+	base := "/api/chats/" + chat.ID + "/messages"
+
+	t.Run("ReturnsImmediatelyWhenNewer", func(t *testing.T) {
+		start := time.Now()
+		page := getTestPage(t, server, base+"?since_seq=0&wait=5s")
+		if len(page.Messages) != 1 {
+			t.Fatalf("Expected 1 message, got %d", len(page.Messages))
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Expected an immediate response, took %v", elapsed)
+		}
+	})
+
+	t.Run("WakesOnNewMessage", func(t *testing.T) {
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			if _, err := server.storage.AddMessage(chat.ID, "bob", "Worth the wait"); err != nil {
+				t.Errorf("Failed to add message: %v", err)
+			}
+		}()
+
+		page := getTestPage(t, server, base+"?after="+first.ID+"&wait=5s")
+		if len(page.Messages) != 1 {
+			t.Fatalf("Expected 1 message, got %d", len(page.Messages))
+		}
+		if page.Messages[0].Content != "Worth the wait" {
+			t.Errorf("Expected the new message, got '%s'", page.Messages[0].Content)
+		}
+	})
+
+	t.Run("TimesOut", func(t *testing.T) {
+		messages, _ := server.storage.GetMessages(chat.ID)
+		last := messages[len(messages)-1].Seq
+
+		start := time.Now()
+		page := getTestPage(t, server, base+"?since_seq="+strconv.FormatInt(last, 10)+"&wait=200ms")
+		if len(page.Messages) != 0 {
+			t.Errorf("Expected no messages, got %d", len(page.Messages))
+		}
+		if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+			t.Errorf("Expected to wait at least 200ms, returned after %v", elapsed)
+		}
+	})
+
+	t.Run("RequiresCursor", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", base+"?wait=1s", nil)
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("InvalidWait", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", base+"?since_seq=0&wait=soon", nil)
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("NonExistentChat", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/api/chats/nonexistent/messages?since_seq=0&wait=5s", nil)
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusNotFound {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+		}
+	})
+}
@@ -1,10 +1,20 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"chat-app/internal/models"
@@ -14,125 +24,464 @@ import (
 )
 
 type Server struct {
-	storage *storage.Storage
-	router  *mux.Router
+	storage    storage.Store
+	router     *mux.Router
+	handler    http.Handler
+	logger     *slog.Logger
+	metrics    *serverMetrics
+	sessionTTL time.Duration
+	// retention is the policy of chats without one of their own
+	retention models.RetentionPolicy
+
+	// draining is set once Shutdown starts; quit is closed when live
+	// streams must end
+	draining atomic.Bool
+	quit     chan struct{}
 }
 
-func NewServer() *Server {
+func NewServer(store storage.Store) *Server {
 	s := &Server{
-		storage: storage.NewStorage(),
-		router:  mux.NewRouter(),
+		storage:    store,
+		router:     mux.NewRouter(),
+		logger:     slog.Default(),
+		metrics:    newServerMetrics(store),
+		sessionTTL: defaultSessionTTL,
+		quit:       make(chan struct{}),
 	}
 	s.setupRoutes()
+	s.handler = withRequestID(s.logRequests(s.measureRequests(s.recoverPanics(s.router))))
 	return s
 }
 
-func (s *Server) setupRoutes() {
-	s.router.HandleFunc("/api/chats", s.handleListChats).Methods("GET")
-	s.router.HandleFunc("/api/chats", s.handleCreateChat).Methods("POST")
-	s.router.HandleFunc("/api/chats/{chatID}/messages", s.handleGetMessages).Methods("GET")
-	s.router.HandleFunc("/api/chats/{chatID}/messages", s.handleSendMessage).Methods("POST")
+// ServeHTTP serves a request through the middleware and the router.
+// Middleware added with router.Use only sees requests that match a route,
+// so the chain wraps the router instead.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
 }
 
-func (s *Server) handleListChats(w http.ResponseWriter, r *http.Request) {
-	chats := s.storage.ListChats()
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(chats); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-		return
-	}
+func (s *Server) setupRoutes() {
+	s.router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "No such endpoint")
+	})
+	s.router.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusMethodNotAllowed, r.Method+" is not allowed here")
+	})
+	s.router.Use(labelRoute, s.authenticate)
+	s.router.HandleFunc("/metrics", s.handleMetrics).Methods("GET")
+	s.router.HandleFunc("/healthz", s.handleHealth).Methods("GET")
+	s.router.HandleFunc("/readyz", s.handleReady).Methods("GET")
+	s.router.HandleFunc("/api/users", s.handleRegister).Methods("POST")
+	s.router.HandleFunc("/api/sessions", s.handleLogin).Methods("POST")
+	s.router.HandleFunc("/api/keys", s.requireSession(s.handleListAPIKeys)).Methods("GET")
+	s.router.HandleFunc("/api/keys", s.requireSession(s.handleCreateAPIKey)).Methods("POST")
+	s.router.HandleFunc("/api/keys/{keyID}", s.requireSession(s.handleRevokeAPIKey)).Methods("DELETE")
+	s.router.HandleFunc("/api/chats", s.handleListChats).Methods("GET")
+	s.router.HandleFunc("/api/chats", s.requireSession(s.handleCreateChat)).Methods("POST")
+	s.router.HandleFunc("/api/dms", s.requireSession(s.handleListDirectChats)).Methods("GET")
+	s.router.HandleFunc("/api/dms", s.requireSession(s.handleOpenDirectChat)).Methods("POST")
+	s.router.HandleFunc("/api/chats/{chatID}", s.requireAccess(s.handleGetChat)).Methods("GET")
+	s.router.HandleFunc("/api/chats/{chatID}", s.requireSession(s.requireAccess(s.handleUpdateChat))).Methods("PATCH")
+	s.router.HandleFunc("/api/chats/{chatID}", s.requireSession(s.requireAccess(s.handleDeleteChat))).Methods("DELETE")
+	s.router.HandleFunc("/api/chats/{chatID}/retention", s.requireAccess(s.handleGetRetention)).Methods("GET")
+	s.router.HandleFunc("/api/chats/{chatID}/retention", s.requireSession(s.requireAccess(s.handleSetRetention))).Methods("PUT")
+	s.router.HandleFunc("/api/chats/{chatID}/retention", s.requireSession(s.requireAccess(s.handleClearRetention))).Methods("DELETE")
+	s.router.HandleFunc("/api/chats/{chatID}/members", s.requireAccess(s.handleListMembers)).Methods("GET")
+	s.router.HandleFunc("/api/chats/{chatID}/members", s.requireSession(s.requireAccess(s.handleAddMember))).Methods("POST")
+	s.router.HandleFunc("/api/chats/{chatID}/members/{username}", s.requireSession(s.requireAccess(s.handleUpdateMember))).Methods("PATCH")
+	s.router.HandleFunc("/api/chats/{chatID}/members/{username}", s.requireSession(s.requireAccess(s.handleRemoveMember))).Methods("DELETE")
+	s.router.HandleFunc("/api/chats/{chatID}/invites", s.requireSession(s.requireAccess(s.handleCreateInvite))).Methods("POST")
+	s.router.HandleFunc("/api/invites/accept", s.requireSession(s.handleAcceptInvite)).Methods("POST")
+	s.router.HandleFunc("/api/chats/{chatID}/messages", s.requireAccess(s.handleGetMessages)).Methods("GET")
+	s.router.HandleFunc("/api/chats/{chatID}/messages", s.requireUser(s.requireAccess(s.handleSendMessage))).Methods("POST")
+	s.router.HandleFunc("/api/chats/{chatID}/messages/{messageID}", s.requireUser(s.requireAccess(s.requireActive(s.handleEditMessage)))).Methods("PATCH")
+	s.router.HandleFunc("/api/chats/{chatID}/messages/{messageID}", s.requireUser(s.requireAccess(s.requireActive(s.handleDeleteMessage)))).Methods("DELETE")
+	s.router.HandleFunc("/api/chats/{chatID}/messages/{messageID}/revisions", s.requireAccess(s.handleGetRevisions)).Methods("GET")
+	s.router.HandleFunc("/api/chats/{chatID}/messages/{messageID}/thread", s.requireAccess(s.handleGetThread)).Methods("GET")
+	s.router.HandleFunc("/api/chats/{chatID}/messages/{messageID}/reactions", s.requireAccess(s.handleListReactions)).Methods("GET")
+	s.router.HandleFunc("/api/chats/{chatID}/messages/{messageID}/reactions", s.requireUser(s.requireAccess(s.requireActive(s.handleAddReaction)))).Methods("POST")
+	s.router.HandleFunc("/api/chats/{chatID}/messages/{messageID}/reactions", s.requireUser(s.requireAccess(s.requireActive(s.handleRemoveReaction)))).Methods("DELETE")
+	s.router.HandleFunc("/api/search", s.handleSearch).Methods("GET")
+	s.router.HandleFunc("/api/chats/{chatID}/ws", s.requireAccess(s.handleWebSocket)).Methods("GET")
+	s.router.HandleFunc("/api/chats/{chatID}/events", s.requireAccess(s.handleEvents)).Methods("GET")
 }
 
 func (s *Server) handleCreateChat(w http.ResponseWriter, r *http.Request) {
 	var req models.CreateChatRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
 	if req.Name == "" {
-		http.Error(w, "Chat name is required", http.StatusBadRequest)
+		invalidField(w, "name", "Chat name is required")
+		return
+	}
+	switch req.Visibility {
+	case "", models.VisibilityPublic, models.VisibilityPrivate:
+	default:
+		invalidField(w, "visibility", "Visibility must be public or private")
 		return
 	}
 
-	chat, err := s.storage.CreateChat(req.Name)
+	// The creator becomes the chat's owner
+	chat, err := s.storage.CreateChatWithOwner(req.Name, identityFrom(r).Username, req.Visibility)
 	if err != nil {
-		http.Error(w, "Failed to create chat", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, "Failed to create chat")
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	if err := json.NewEncoder(w).Encode(chat); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, "Failed to encode response")
 		return
 	}
 }
 
+// Page size limits for GET /api/chats/{chatID}/messages
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+)
+
+// parsePageQuery reads the limit, before, after and since_seq query
+// parameters. It reports false if none were given, in which case the full
+// history is returned as a plain array.
+func parsePageQuery(r *http.Request) (storage.PageQuery, bool, error) {
+	values := r.URL.Query()
+	query := storage.PageQuery{
+		Limit:  defaultPageLimit,
+		Before: values.Get("before"),
+		After:  values.Get("after"),
+	}
+	paged := values.Has("limit") || values.Has("before") || values.Has("after") || values.Has("since_seq")
+
+	if raw := values.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 {
+			return query, paged, &fieldError{"limit", "limit must be a positive integer"}
+		}
+		query.Limit = min(limit, maxPageLimit)
+	}
+	if raw := values.Get("since_seq"); raw != "" {
+		seq, err := parseSeq(raw)
+		if err != nil {
+			return query, paged, &fieldError{"since_seq", "since_seq " + err.Error()}
+		}
+		query.AfterSeq = seq
+		query.HasAfterSeq = true
+	}
+	return query, paged, nil
+}
+
 func (s *Server) handleGetMessages(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	chatID := vars["chatID"]
 
+	query, paged, err := parsePageQuery(r)
+	if err != nil {
+		writeInvalid(w, err)
+		return
+	}
+	wait, err := parseWait(r)
+	if err != nil {
+		writeInvalid(w, err)
+		return
+	}
+	if wait > 0 {
+		if query.After == "" && !r.URL.Query().Has("since_seq") {
+			invalidField(w, "wait", "wait requires an after or since_seq cursor")
+			return
+		}
+		// The response may be written long after the server's
+		// WriteTimeout would have expired
+		_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(wait + longPollWriteMargin))
+		s.waitForMessages(r.Context(), chatID, query, wait)
+	}
+	if paged {
+		s.writeMessagePage(w, chatID, query)
+		return
+	}
+
 	messages, exists := s.storage.GetMessages(chatID)
 	if !exists {
-		http.Error(w, "Chat not found", http.StatusNotFound)
+		writeError(w, http.StatusNotFound, "Chat not found")
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(messages); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, "Failed to encode response")
+		return
+	}
+}
+
+func (s *Server) writeMessagePage(w http.ResponseWriter, chatID string, query storage.PageQuery) {
+	page, err := s.storage.GetMessagePage(chatID, query)
+	if errors.Is(err, storage.ErrInvalidCursor) {
+		writeError(w, http.StatusBadRequest, "Invalid cursor")
+		return
+	}
+	if errors.Is(err, storage.ErrChatNotFound) {
+		writeError(w, http.StatusNotFound, "Chat not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to get messages")
+		return
+	}
+
+	resp := models.MessagePageResponse{Messages: page.Messages}
+	if n := len(page.Messages); n > 0 {
+		if page.HasOlder {
+			resp.PrevCursor = page.Messages[0].ID
+		}
+		if page.HasNewer {
+			resp.NextCursor = page.Messages[n-1].ID
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to encode response")
 		return
 	}
 }
 
+// errWrongThread is returned by postMessage for a reply_to outside the
+// requested thread_id
+var errWrongThread = errors.New("reply_to is not in thread_id")
+
+// postMessage stores a message from author, who must be at least a member of
+// the chat. Storage delivers it to live subscribers.
+func (s *Server) postMessage(chatID string, author *identity, req models.SendMessageRequest) (*models.Message, error) {
+	chat, exists := s.storage.GetChat(chatID)
+	if !exists {
+		return nil, storage.ErrChatNotFound
+	}
+	if chat.ArchivedAt != nil {
+		return nil, errArchived
+	}
+	if !s.hasRole(chatID, author, models.RoleMember) {
+		return nil, errNotAllowed
+	}
+	replyTo := req.ReplyTo
+	if replyTo == "" {
+		replyTo = req.ThreadID
+	} else if req.ThreadID != "" {
+		parent, exists := s.storage.GetMessage(replyTo)
+		if exists && parent.ID != req.ThreadID && parent.ThreadID != req.ThreadID {
+			return nil, errWrongThread
+		}
+	}
+	return s.storage.Post(storage.NewMessage{
+		ChatID:   chatID,
+		Username: author.Username,
+		Content:  req.Content,
+		Bot:      author.Key != nil,
+		ReplyTo:  replyTo,
+	})
+}
+
+// messageErrorText describes a postMessage error for the client
+func messageErrorText(err error) string {
+	if errors.Is(err, storage.ErrChatNotFound) {
+		return "Chat not found"
+	}
+	if errors.Is(err, errNotAllowed) {
+		return "Your role does not allow posting in this chat"
+	}
+	if errors.Is(err, errArchived) {
+		return "This chat is archived and read-only"
+	}
+	if errors.Is(err, storage.ErrMessageNotFound) {
+		return "The message replied to is not in this chat"
+	}
+	if errors.Is(err, storage.ErrMessageDeleted) {
+		return "The message replied to was deleted"
+	}
+	if errors.Is(err, errWrongThread) {
+		return "The message replied to is not in that thread"
+	}
+	return "Failed to send message"
+}
+
 func (s *Server) handleSendMessage(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	chatID := vars["chatID"]
 
 	var req models.SendMessageRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
-	if req.Username == "" || req.Content == "" {
-		http.Error(w, "Username and content are required", http.StatusBadRequest)
+	if req.Content == "" {
+		invalidField(w, "content", "Content is required")
 		return
 	}
 
-	message, err := s.storage.AddMessage(chatID, req.Username, req.Content)
-	if err != nil || message == nil {
-		http.Error(w, "Chat not found", http.StatusNotFound)
+	// The author is whoever the session or API key belongs to
+	message, err := s.postMessage(chatID, identityFrom(r), req)
+	if errors.Is(err, storage.ErrChatNotFound) {
+		writeError(w, http.StatusNotFound, "Chat not found")
+		return
+	}
+	if errors.Is(err, errNotAllowed) || errors.Is(err, errArchived) {
+		writeError(w, http.StatusForbidden, messageErrorText(err))
+		return
+	}
+	if errors.Is(err, storage.ErrMessageNotFound) || errors.Is(err, errWrongThread) {
+		writeError(w, http.StatusBadRequest, messageErrorText(err))
+		return
+	}
+	if errors.Is(err, storage.ErrMessageDeleted) {
+		writeError(w, http.StatusConflict, messageErrorText(err))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to send message")
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	if err := json.NewEncoder(w).Encode(message); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, "Failed to encode response")
 		return
 	}
 }
 
+// storeConfig selects and configures the storage backend
+type storeConfig struct {
+	backend       string
+	dataDir       string
+	fsync         string
+	fsyncInterval time.Duration
+
+	snapshotInterval time.Duration
+	snapshotRetain   int
+}
+
+// openStore creates the storage backend selected by cfg
+func openStore(cfg storeConfig) (storage.Store, error) {
+	switch cfg.backend {
+	case "memory":
+		return storage.NewStorage(), nil
+	case "file":
+		policy, err := storage.ParseSyncPolicy(cfg.fsync)
+		if err != nil {
+			return nil, err
+		}
+		store, err := storage.OpenFileStore(cfg.dataDir, storage.FileOptions{
+			Sync:             policy,
+			SyncInterval:     cfg.fsyncInterval,
+			SnapshotInterval: cfg.snapshotInterval,
+			SnapshotRetain:   cfg.snapshotRetain,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("open file store: %w", err)
+		}
+		stats := store.Recovered()
+		if stats.Snapshot > 0 {
+			log.Printf("Loaded snapshot %d from %s", stats.Snapshot, cfg.dataDir)
+		}
+		log.Printf("Recovered %d records from %d log segments in %s", stats.Records, stats.Segments, cfg.dataDir)
+		if stats.TruncatedBytes > 0 {
+			log.Printf("Discarded %d bytes of incomplete log record", stats.TruncatedBytes)
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.backend)
+	}
+}
+
 func main() {
 	port := flag.String("port", "8080", "Server port")
+	var cfg storeConfig
+	flag.StringVar(&cfg.backend, "storage", "memory", "Storage backend (memory, file)")
+	flag.StringVar(&cfg.dataDir, "data-dir", "data", "Data directory for the file storage backend")
+	flag.StringVar(&cfg.fsync, "fsync", "always", "Log fsync policy for the file storage backend (always, interval, never)")
+	flag.DurationVar(&cfg.fsyncInterval, "fsync-interval", time.Second, "How often to fsync the log with --fsync=interval")
+	flag.DurationVar(&cfg.snapshotInterval, "snapshot-interval", 10*time.Minute, "How often the file storage backend snapshots its state (0 disables)")
+	flag.IntVar(&cfg.snapshotRetain, "snapshot-retain", storage.DefaultSnapshotRetain, "Number of snapshots to keep; older log segments are deleted")
+	sessionTTL := flag.Duration("session-ttl", defaultSessionTTL, "How long login sessions last")
+	var retention models.RetentionPolicy
+	flag.IntVar(&retention.MaxMessages, "retention-max-messages", 0, "Number of messages each chat keeps unless it has its own policy (0 keeps all)")
+	maxAge := flag.Duration("retention-max-age", 0, "How long messages are kept unless their chat has its own policy (0 keeps them for good)")
+	janitorInterval := flag.Duration("retention-interval", defaultJanitorInterval, "How often expired messages are removed (0 disables)")
+	logFormat := flag.String("log-format", "text", "Log format (text, json)")
+	logLevel := flag.String("log-level", "info", "Minimum level of log entries (debug, info, warn, error)")
+	shutdownDelay := flag.Duration("shutdown-delay", 0, "How long to keep serving after a shutdown signal while /readyz fails")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "How long to wait for requests and streams to finish when shutting down")
 	flag.Parse()
+	retention.MaxAge = models.Duration(*maxAge)
 
-	server := NewServer()
+	logger, err := newLogger(os.Stderr, *logFormat, *logLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Send the standard logger's output through the same handler
+	slog.SetDefault(logger)
 
-	log.Printf("Starting server on port %s", *port)
+	store, err := openStore(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	server := NewServer(store)
+	server.logger = logger
+	server.sessionTTL = *sessionTTL
+	server.retention = retention
+
+	janitorCtx, stopJanitor := context.WithCancel(context.Background())
+	janitorDone := make(chan struct{})
+	go func() {
+		defer close(janitorDone)
+		server.runJanitor(janitorCtx, *janitorInterval)
+	}()
+
+	log.Printf("Starting server on port %s with %s storage", *port, cfg.backend)
 
 	// Create server with timeouts
 	srv := &http.Server{
 		Addr:         ":" + *port,
-		Handler:      server.router,
+		Handler:      server,
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
 
-	if err := srv.ListenAndServe(); err != nil {
+	signals, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.ListenAndServe()
+	}()
+	select {
+	case err := <-served:
 		log.Fatal(err)
+	case <-signals.Done():
+	}
+	// A second signal stops the server at once
+	stopSignals()
+
+	logger.Info("Shutting down", "delay", *shutdownDelay, "timeout", *shutdownTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownDelay+*shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx, srv, *shutdownDelay); err != nil {
+		logger.Error("Failed to drain connections", "error", err)
+	}
+	stopJanitor()
+	<-janitorDone
+
+	// Flush what the storage backend has not yet written out
+	if closer, ok := store.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			logger.Error("Failed to close storage", "error", err)
+			os.Exit(1)
+		}
 	}
+	logger.Info("Server stopped")
 }
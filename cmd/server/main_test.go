@@ -3,15 +3,17 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"chat-app/internal/models"
+	"chat-app/internal/storage"
 )
 
 func TestServer(t *testing.T) {
-	server := NewServer()
+	server := NewServer(storage.NewStorage())
 
 	t.Run("ListChats_Empty", func(t *testing.T) {
 		req, _ := http.NewRequest("GET", "/api/chats", nil)
@@ -38,6 +40,7 @@ func TestServer(t *testing.T) {
 
 		req, _ := http.NewRequest("POST", "/api/chats", bytes.NewBuffer(body))
 		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+testToken(t, server, "testuser"))
 		rr := httptest.NewRecorder()
 		server.router.ServeHTTP(rr, req)
 
@@ -65,6 +68,7 @@ func TestServer(t *testing.T) {
 
 		req, _ := http.NewRequest("POST", "/api/chats", bytes.NewBuffer(body))
 		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+testToken(t, server, "testuser"))
 		rr := httptest.NewRecorder()
 		server.router.ServeHTTP(rr, req)
 
@@ -80,6 +84,7 @@ func TestServer(t *testing.T) {
 
 		req, _ := http.NewRequest("POST", "/api/chats", bytes.NewBuffer(createBody))
 		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+testToken(t, server, "testuser"))
 		rr := httptest.NewRecorder()
 		server.router.ServeHTTP(rr, req)
 
@@ -90,13 +95,13 @@ func TestServer(t *testing.T) {
 
 		// Send a message
 		msgReq := models.SendMessageRequest{
-			Username: "testuser",
-			Content:  "Hello, World!",
+			Content: "Hello, World!",
 		}
 		msgBody, _ := json.Marshal(msgReq)
 
 		req, _ = http.NewRequest("POST", "/api/chats/"+chat.ID+"/messages", bytes.NewBuffer(msgBody))
 		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+testToken(t, server, "testuser"))
 		rr = httptest.NewRecorder()
 		server.router.ServeHTTP(rr, req)
 
@@ -152,13 +157,13 @@ func TestServer(t *testing.T) {
 
 	t.Run("SendMessage_NonExistentChat", func(t *testing.T) {
 		msgReq := models.SendMessageRequest{
-			Username: "testuser",
-			Content:  "Hello",
+			Content: "Hello",
 		}
 		msgBody, _ := json.Marshal(msgReq)
 
 		req, _ := http.NewRequest("POST", "/api/chats/nonexistent/messages", bytes.NewBuffer(msgBody))
 		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+testToken(t, server, "testuser"))
 		rr := httptest.NewRecorder()
 		server.router.ServeHTTP(rr, req)
 
@@ -174,6 +179,7 @@ func TestServer(t *testing.T) {
 
 		req, _ := http.NewRequest("POST", "/api/chats", bytes.NewBuffer(createBody))
 		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+testToken(t, server, "testuser"))
 		rr := httptest.NewRecorder()
 		server.router.ServeHTTP(rr, req)
 
@@ -182,15 +188,15 @@ func TestServer(t *testing.T) {
 			t.Errorf("Failed to decode response: %v", err)
 		}
 
-		// Send message without username
+		// Send message without content
 		msgReq := models.SendMessageRequest{
-			Username: "",
-			Content:  "Hello",
+			Content: "",
 		}
 		msgBody, _ := json.Marshal(msgReq)
 
 		req, _ = http.NewRequest("POST", "/api/chats/"+chat.ID+"/messages", bytes.NewBuffer(msgBody))
 		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+testToken(t, server, "testuser"))
 		rr = httptest.NewRecorder()
 		server.router.ServeHTTP(rr, req)
 
@@ -199,3 +205,233 @@ func TestServer(t *testing.T) {
 		}
 	})
 }
+
+func TestOpenStore(t *testing.T) {
+	t.Run("Memory", func(t *testing.T) {
+		store, err := openStore(storeConfig{backend: "memory"})
+		if err != nil {
+			t.Fatalf("Failed to open memory store: %v", err)
+		}
+		if _, ok := store.(*storage.Storage); !ok {
+			t.Errorf("Expected *storage.Storage, got %T", store)
+		}
+	})
+
+	t.Run("File", func(t *testing.T) {
+		store, err := openStore(storeConfig{backend: "file", dataDir: t.TempDir(), fsync: "always"})
+		if err != nil {
+			t.Fatalf("Failed to open file store: %v", err)
+		}
+		fileStore, ok := store.(*storage.FileStore)
+		if !ok {
+			t.Fatalf("Expected *storage.FileStore, got %T", store)
+		}
+		if err := fileStore.Close(); err != nil {
+			t.Errorf("Failed to close file store: %v", err)
+		}
+	})
+
+	t.Run("File_BadPolicy", func(t *testing.T) {
+		if _, err := openStore(storeConfig{backend: "file", dataDir: t.TempDir(), fsync: "sometimes"}); err == nil {
+			t.Error("Expected error for unknown fsync policy")
+		}
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		if _, err := openStore(storeConfig{backend: "bogus"}); err == nil {
+			t.Error("Expected error for unknown backend")
+		}
+	})
+}
+
+// createTestChat creates a chat owned by the user "owner"
+func createTestChat(t *testing.T, server *Server, name string) models.Chat {
+	t.Helper()
+	body, _ := json.Marshal(models.CreateChatRequest{Name: name})
+	req, _ := http.NewRequest("POST", "/api/chats", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+testToken(t, server, "owner"))
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Failed to create chat: status %d: %s", rr.Code, rr.Body.String())
+	}
+	var chat models.Chat
+	if err := json.NewDecoder(rr.Body).Decode(&chat); err != nil {
+		t.Fatalf("Failed to decode chat: %v", err)
+	}
+	return chat
+}
+
+// sendTestMessage posts a message as username, adding them to the chat first
+// if needed
+func sendTestMessage(t *testing.T, server *Server, chatID, username, content string) models.Message {
+	t.Helper()
+	token := testToken(t, server, username)
+	joinTestChat(t, server, chatID, username)
+	body, _ := json.Marshal(models.SendMessageRequest{Content: content})
+	req, _ := http.NewRequest("POST", "/api/chats/"+chatID+"/messages", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Failed to send message: status %d: %s", rr.Code, rr.Body.String())
+	}
+	var message models.Message
+	if err := json.NewDecoder(rr.Body).Decode(&message); err != nil {
+		t.Fatalf("Failed to decode message: %v", err)
+	}
+	return message
+}
+
+// joinTestChat makes username a member of a chat unless they already belong
+// to it
+func joinTestChat(t *testing.T, server *Server, chatID, username string) {
+	t.Helper()
+	if _, ok := server.storage.GetMember(chatID, username); ok {
+		return
+	}
+	if _, err := server.storage.SetMember(chatID, username, models.RoleMember); err != nil {
+		t.Fatalf("Failed to add member: %v", err)
+	}
+}
+
+func getTestPage(t *testing.T, server *Server, url string) models.MessagePageResponse {
+	t.Helper()
+	req, _ := http.NewRequest("GET", url, nil)
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var page models.MessagePageResponse
+	if err := json.NewDecoder(rr.Body).Decode(&page); err != nil {
+		t.Fatalf("Failed to decode page: %v", err)
+	}
+	return page
+}
+
+func TestMessagePagination(t *testing.T) {
+	server := NewServer(storage.NewStorage())
+	chat := createTestChat(t, server, "Paged Chat")
+
+	ids := make([]string, 7)
+	for i := range ids {
+		ids[i] = sendTestMessage(t, server, chat.ID, "user", fmt.Sprintf("Message %d", i)).ID
+	}
+	base := "/api/chats/" + chat.ID + "/messages"
+
+	t.Run("Latest", func(t *testing.T) {
+		page := getTestPage(t, server, base+"?limit=3")
+		if len(page.Messages) != 3 {
+			t.Fatalf("Expected 3 messages, got %d", len(page.Messages))
+		}
+		if page.Messages[0].ID != ids[4] {
+			t.Errorf("Expected page to start at message 4, got '%s'", page.Messages[0].Content)
+		}
+		if page.PrevCursor != ids[4] {
+			t.Errorf("Expected prev_cursor %s, got %s", ids[4], page.PrevCursor)
+		}
+		if page.NextCursor != "" {
+			t.Errorf("Expected no next_cursor, got %s", page.NextCursor)
+		}
+	})
+
+	t.Run("WalkBackwards", func(t *testing.T) {
+		var seen []string
+		url := base + "?limit=3"
+		for {
+			page := getTestPage(t, server, url)
+			for i := len(page.Messages) - 1; i >= 0; i-- {
+				seen = append(seen, page.Messages[i].ID)
+			}
+			if page.PrevCursor == "" {
+				break
+			}
+			url = base + "?limit=3&before=" + page.PrevCursor
+		}
+		if len(seen) != len(ids) {
+			t.Fatalf("Expected %d messages in total, got %d", len(ids), len(seen))
+		}
+		for i, id := range seen {
+			if id != ids[len(ids)-1-i] {
+				t.Errorf("Unexpected message at position %d", i)
+			}
+		}
+	})
+
+	t.Run("After", func(t *testing.T) {
+		page := getTestPage(t, server, base+"?limit=2&after="+ids[1])
+		if len(page.Messages) != 2 || page.Messages[0].ID != ids[2] {
+			t.Fatalf("Expected messages 2 and 3, got %d messages", len(page.Messages))
+		}
+		if page.NextCursor != ids[3] {
+			t.Errorf("Expected next_cursor %s, got %s", ids[3], page.NextCursor)
+		}
+		if page.PrevCursor != ids[2] {
+			t.Errorf("Expected prev_cursor %s, got %s", ids[2], page.PrevCursor)
+		}
+	})
+
+	t.Run("SinceSeq", func(t *testing.T) {
+		page := getTestPage(t, server, base+"?since_seq=5")
+		if len(page.Messages) != 2 {
+			t.Fatalf("Expected 2 messages, got %d", len(page.Messages))
+		}
+		if page.Messages[0].Seq != 6 || page.Messages[1].Seq != 7 {
+			t.Errorf("Expected sequences 6 and 7, got %d and %d", page.Messages[0].Seq, page.Messages[1].Seq)
+		}
+		if page.NextCursor != "" {
+			t.Errorf("Expected no next_cursor, got %s", page.NextCursor)
+		}
+
+		page = getTestPage(t, server, base+"?since_seq=7")
+		if len(page.Messages) != 0 {
+			t.Errorf("Expected no new messages, got %d", len(page.Messages))
+		}
+	})
+
+	t.Run("InvalidSinceSeq", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", base+"?since_seq=-1", nil)
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("InvalidLimit", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", base+"?limit=zero", nil)
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("InvalidCursor", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", base+"?after=nonexistent", nil)
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("NonExistentChat", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/api/chats/nonexistent/messages?limit=10", nil)
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusNotFound {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+		}
+	})
+}
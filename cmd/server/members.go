@@ -0,0 +1,382 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"chat-app/internal/models"
+	"chat-app/internal/storage"
+
+	"github.com/gorilla/mux"
+)
+
+// roleRank orders the chat roles; each role may do everything the roles
+// below it may
+var roleRank = map[string]int{
+	models.RoleReadOnly: 1,
+	models.RoleMember:   2,
+	models.RoleAdmin:    3,
+	models.RoleOwner:    4,
+}
+
+// errNotAllowed is returned when the caller's role does not permit an action
+var errNotAllowed = errors.New("not allowed")
+
+// errArchived is returned when posting to an archived chat
+var errArchived = errors.New("chat is archived")
+
+// Length limits for chat details, in bytes
+const (
+	maxTopicLength       = 250
+	maxDescriptionLength = 4000
+)
+
+// roleOf returns the role id holds in a chat, or "" if it is not a member.
+// Bots act with the role of the user who owns their key. Chats without an
+// owner predate roles and treat every user as a member, even once some have
+// joined, as nobody could give the others their say back.
+func (s *Server) roleOf(chatID string, id *identity) string {
+	if id == nil {
+		return ""
+	}
+	username := id.Username
+	if id.Key != nil {
+		username = id.Key.Owner
+	}
+	if member, ok := s.storage.GetMember(chatID, username); ok {
+		return member.Role
+	}
+	if s.predatesRoles(chatID) {
+		return models.RoleMember
+	}
+	return ""
+}
+
+// predatesRoles reports whether a chat was created before roles existed:
+// such chats have no owner. Direct chats never have one and do not count.
+func (s *Server) predatesRoles(chatID string) bool {
+	chat, exists := s.storage.GetChat(chatID)
+	if !exists || chat.Visibility == models.VisibilityDirect {
+		return false
+	}
+	members, err := s.storage.ListMembers(chatID)
+	if err != nil {
+		return false
+	}
+	for _, member := range members {
+		if member.Role == models.RoleOwner {
+			return false
+		}
+	}
+	return true
+}
+
+// hasRole reports whether id holds at least role in a chat
+func (s *Server) hasRole(chatID string, id *identity, role string) bool {
+	return roleRank[s.roleOf(chatID, id)] >= roleRank[role]
+}
+
+// canSee reports whether id may see a chat. Private and direct chats are
+// only visible to their members.
+func (s *Server) canSee(chat *models.Chat, id *identity) bool {
+	switch chat.Visibility {
+	case models.VisibilityPrivate, models.VisibilityDirect:
+		return s.roleOf(chat.ID, id) != ""
+	}
+	return true
+}
+
+// canRead reports whether id may read chat: it must be able to see it and,
+// for an API key, the key must allow reading that chat. Endpoints that span
+// chats use it, as authenticate only checks the chat in the path.
+func (s *Server) canRead(chat *models.Chat, id *identity) bool {
+	if !s.canSee(chat, id) {
+		return false
+	}
+	return id == nil || id.can(models.ScopeRead, chat.ID)
+}
+
+// requireAccess hides private and direct chats from everyone but their
+// members.
+// Requests for them answer as if the chat did not exist; requests for chats
+// that really do not exist are left to next.
+func (s *Server) requireAccess(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		chat, exists := s.storage.GetChat(mux.Vars(r)["chatID"])
+		if exists && !s.canSee(chat, identityFrom(r)) {
+			writeError(w, http.StatusNotFound, "Chat not found")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requireActive rejects requests to next that would change an archived chat,
+// which stays read-only until it is restored
+func (s *Server) requireActive(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		chat, exists := s.storage.GetChat(mux.Vars(r)["chatID"])
+		if exists && chat.ArchivedAt != nil {
+			writeError(w, http.StatusForbidden, "This chat is archived and read-only")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// writeJSON encodes v as the response with the given status
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to encode response")
+		return
+	}
+}
+
+func (s *Server) handleGetChat(w http.ResponseWriter, r *http.Request) {
+	chat, exists := s.storage.GetChat(mux.Vars(r)["chatID"])
+	if !exists {
+		writeError(w, http.StatusNotFound, "Chat not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, chat)
+}
+
+// handleUpdateChat changes a chat's name, topic, description, archive state
+// or legal hold. Only admins may change a chat, archived or not, and only
+// its owner may place or lift a legal hold.
+func (s *Server) handleUpdateChat(w http.ResponseWriter, r *http.Request) {
+	chatID := mux.Vars(r)["chatID"]
+
+	var req models.UpdateChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	update := storage.ChatUpdate{Topic: req.Topic, Description: req.Description, Archived: req.Archived, LegalHold: req.LegalHold}
+	if req.Name != "" {
+		update.Name = &req.Name
+	}
+	if update == (storage.ChatUpdate{}) {
+		writeError(w, http.StatusBadRequest, "Name, topic, description, archived or legal_hold is required")
+		return
+	}
+	if req.Topic != nil && len(*req.Topic) > maxTopicLength {
+		invalidField(w, "topic", fmt.Sprintf("Topic must be at most %d bytes", maxTopicLength))
+		return
+	}
+	if req.Description != nil && len(*req.Description) > maxDescriptionLength {
+		invalidField(w, "description", fmt.Sprintf("Description must be at most %d bytes", maxDescriptionLength))
+		return
+	}
+
+	if _, exists := s.storage.GetChat(chatID); !exists {
+		writeError(w, http.StatusNotFound, "Chat not found")
+		return
+	}
+	if !s.hasRole(chatID, identityFrom(r), models.RoleAdmin) {
+		writeError(w, http.StatusForbidden, "Only chat admins can change the chat")
+		return
+	}
+	if req.LegalHold != nil && !s.hasRole(chatID, identityFrom(r), models.RoleOwner) {
+		writeError(w, http.StatusForbidden, "Only the chat owner can change the legal hold")
+		return
+	}
+
+	chat, err := s.storage.UpdateChat(chatID, update)
+	if errors.Is(err, storage.ErrChatNotFound) {
+		writeError(w, http.StatusNotFound, "Chat not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to update chat")
+		return
+	}
+	writeJSON(w, http.StatusOK, chat)
+}
+
+func (s *Server) handleDeleteChat(w http.ResponseWriter, r *http.Request) {
+	chatID := mux.Vars(r)["chatID"]
+
+	if _, exists := s.storage.GetChat(chatID); !exists {
+		writeError(w, http.StatusNotFound, "Chat not found")
+		return
+	}
+	if !s.hasRole(chatID, identityFrom(r), models.RoleOwner) {
+		writeError(w, http.StatusForbidden, "Only the chat owner can delete the chat")
+		return
+	}
+
+	err := s.storage.DeleteChat(chatID)
+	if errors.Is(err, storage.ErrChatNotFound) {
+		writeError(w, http.StatusNotFound, "Chat not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to delete chat")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleListMembers(w http.ResponseWriter, r *http.Request) {
+	members, err := s.storage.ListMembers(mux.Vars(r)["chatID"])
+	if errors.Is(err, storage.ErrChatNotFound) {
+		writeError(w, http.StatusNotFound, "Chat not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to list members")
+		return
+	}
+	writeJSON(w, http.StatusOK, members)
+}
+
+// handleAddMember adds a user to a chat. Admins may add users with any role
+// below their own. Any user may join a public chat as a plain member by
+// adding themselves.
+func (s *Server) handleAddMember(w http.ResponseWriter, r *http.Request) {
+	chatID := mux.Vars(r)["chatID"]
+	caller := identityFrom(r)
+
+	var req models.AddMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.Username == "" {
+		invalidField(w, "username", "Username is required")
+		return
+	}
+	if req.Role == "" {
+		req.Role = models.RoleMember
+	}
+	if _, valid := roleRank[req.Role]; !valid || req.Role == models.RoleOwner {
+		invalidField(w, "role", "Role must be admin, member or read-only")
+		return
+	}
+
+	chat, exists := s.storage.GetChat(chatID)
+	if !exists {
+		writeError(w, http.StatusNotFound, "Chat not found")
+		return
+	}
+	if existing, ok := s.storage.GetMember(chatID, req.Username); ok {
+		writeJSON(w, http.StatusOK, existing)
+		return
+	}
+
+	// Private chats are joined with an invite instead
+	joining := strings.EqualFold(req.Username, caller.Username) && req.Role == models.RoleMember &&
+		chat.Visibility != models.VisibilityPrivate
+	if !joining {
+		role := s.roleOf(chatID, caller)
+		if roleRank[role] < roleRank[models.RoleAdmin] || roleRank[req.Role] >= roleRank[role] {
+			writeError(w, http.StatusForbidden, "Not allowed to add members with this role")
+			return
+		}
+	}
+
+	s.setMember(w, chatID, req.Username, req.Role, http.StatusCreated)
+}
+
+// handleUpdateMember changes a member's role. Admins may change the roles of
+// members ranked below them, to any role below their own.
+func (s *Server) handleUpdateMember(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	chatID, username := vars["chatID"], vars["username"]
+
+	var req models.UpdateMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if _, valid := roleRank[req.Role]; !valid || req.Role == models.RoleOwner {
+		invalidField(w, "role", "Role must be admin, member or read-only")
+		return
+	}
+
+	if _, exists := s.storage.GetChat(chatID); !exists {
+		writeError(w, http.StatusNotFound, "Chat not found")
+		return
+	}
+	target, ok := s.storage.GetMember(chatID, username)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Member not found")
+		return
+	}
+	role := s.roleOf(chatID, identityFrom(r))
+	if !canManage(role, target) || roleRank[req.Role] >= roleRank[role] {
+		writeError(w, http.StatusForbidden, "Not allowed to change this member's role")
+		return
+	}
+
+	s.setMember(w, chatID, target.Username, req.Role, http.StatusOK)
+}
+
+// handleRemoveMember removes a member from a chat. Admins may remove members
+// ranked below them, and anyone but the owner may leave.
+func (s *Server) handleRemoveMember(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	chatID, username := vars["chatID"], vars["username"]
+	caller := identityFrom(r)
+
+	chat, exists := s.storage.GetChat(chatID)
+	if !exists {
+		writeError(w, http.StatusNotFound, "Chat not found")
+		return
+	}
+	if chat.Visibility == models.VisibilityDirect {
+		writeError(w, http.StatusBadRequest, "Direct chats always have their two participants")
+		return
+	}
+	target, ok := s.storage.GetMember(chatID, username)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Member not found")
+		return
+	}
+	leaving := strings.EqualFold(target.Username, caller.Username) && target.Role != models.RoleOwner
+	if !leaving && !canManage(s.roleOf(chatID, caller), target) {
+		writeError(w, http.StatusForbidden, "Not allowed to remove this member")
+		return
+	}
+
+	err := s.storage.RemoveMember(chatID, target.Username)
+	if errors.Is(err, storage.ErrChatNotFound) || errors.Is(err, storage.ErrMemberNotFound) {
+		writeError(w, http.StatusNotFound, "Member not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to remove member")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// canManage reports whether a member with role is an admin ranked above
+// target
+func canManage(role string, target *models.Member) bool {
+	return roleRank[role] >= roleRank[models.RoleAdmin] && roleRank[role] > roleRank[target.Role]
+}
+
+// setMember stores a membership and writes it as the response
+func (s *Server) setMember(w http.ResponseWriter, chatID, username, role string, status int) {
+	member, err := s.storage.SetMember(chatID, username, role)
+	if errors.Is(err, storage.ErrChatNotFound) {
+		writeError(w, http.StatusNotFound, "Chat not found")
+		return
+	}
+	if errors.Is(err, storage.ErrUserNotFound) {
+		writeError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to update member")
+		return
+	}
+	writeJSON(w, status, member)
+}
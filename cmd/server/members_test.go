@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"chat-app/internal/models"
+	"chat-app/internal/storage"
+
+	"github.com/gorilla/websocket"
+)
+
+func serveTestJSON(server *Server, method, path string, body any, header http.Header) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	req, _ := http.NewRequest(method, path, bytes.NewBuffer(data))
+	req.Header.Set("Content-Type", "application/json")
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestMembers(t *testing.T) {
+	server := NewServer(storage.NewStorage())
+	owner := testAuthHeader(t, server, "owner")
+	admin := testAuthHeader(t, server, "bob")
+	member := testAuthHeader(t, server, "carol")
+	outsider := testAuthHeader(t, server, "dave")
+
+	chat := createTestChat(t, server, "Team")
+	base := "/api/chats/" + chat.ID
+	send := models.SendMessageRequest{Content: "Hello"}
+
+	t.Run("CreateChat_RequiresLogin", func(t *testing.T) {
+		rr := postTestJSON(server, "/api/chats", models.CreateChatRequest{Name: "Anonymous"}, nil)
+		if status := rr.Code; status != http.StatusUnauthorized {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("CreatorIsOwner", func(t *testing.T) {
+		rr := serveTestRequest(server, "GET", base+"/members", nil)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		var members []*models.Member
+		if err := json.NewDecoder(rr.Body).Decode(&members); err != nil {
+			t.Fatalf("Failed to decode members: %v", err)
+		}
+		if len(members) != 1 || members[0].Username != "owner" || members[0].Role != models.RoleOwner {
+			t.Errorf("Expected the creator as the only member and owner, got %+v", members)
+		}
+	})
+
+	t.Run("Join", func(t *testing.T) {
+		if rr := postTestJSON(server, base+"/messages", send, outsider); rr.Code != http.StatusForbidden {
+			t.Errorf("Expected non-members not to post, got status %v", rr.Code)
+		}
+
+		rr := postTestJSON(server, base+"/members", models.AddMemberRequest{Username: "dave"}, outsider)
+		if status := rr.Code; status != http.StatusCreated {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+		}
+		if rr := postTestJSON(server, base+"/messages", send, outsider); rr.Code != http.StatusCreated {
+			t.Errorf("Expected members to post, got status %v", rr.Code)
+		}
+
+		// Joining again returns the existing membership
+		rr = postTestJSON(server, base+"/members", models.AddMemberRequest{Username: "dave"}, outsider)
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+
+		// Users cannot join with a higher role than member
+		rr = postTestJSON(server, base+"/members", models.AddMemberRequest{Username: "eve", Role: models.RoleAdmin}, testAuthHeader(t, server, "eve"))
+		if status := rr.Code; status != http.StatusForbidden {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusForbidden)
+		}
+	})
+
+	t.Run("Manage", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			method string
+			path   string
+			body   any
+			header http.Header
+			want   int
+		}{
+			{"OwnerAddsAdmin", "POST", base + "/members", models.AddMemberRequest{Username: "bob", Role: models.RoleAdmin}, owner, http.StatusCreated},
+			{"AdminAddsMember", "POST", base + "/members", models.AddMemberRequest{Username: "carol"}, admin, http.StatusCreated},
+			{"AdminCannotAddAdmin", "POST", base + "/members", models.AddMemberRequest{Username: "frank", Role: models.RoleAdmin}, admin, http.StatusForbidden},
+			{"MemberCannotAdd", "POST", base + "/members", models.AddMemberRequest{Username: "frank"}, member, http.StatusForbidden},
+			{"OwnerRoleCannotBeGiven", "POST", base + "/members", models.AddMemberRequest{Username: "frank", Role: models.RoleOwner}, owner, http.StatusBadRequest},
+			{"UnknownRole", "PATCH", base + "/members/carol", models.UpdateMemberRequest{Role: "king"}, owner, http.StatusBadRequest},
+			{"UnknownUser", "POST", base + "/members", models.AddMemberRequest{Username: "nobody", Role: models.RoleMember}, owner, http.StatusNotFound},
+			{"MemberCannotPromote", "PATCH", base + "/members/dave", models.UpdateMemberRequest{Role: models.RoleAdmin}, member, http.StatusForbidden},
+			{"AdminDemotesMember", "PATCH", base + "/members/carol", models.UpdateMemberRequest{Role: models.RoleReadOnly}, admin, http.StatusOK},
+			{"ReadOnlyCannotPost", "POST", base + "/messages", send, member, http.StatusForbidden},
+			{"AdminCannotDemoteOwner", "PATCH", base + "/members/owner", models.UpdateMemberRequest{Role: models.RoleMember}, admin, http.StatusForbidden},
+			{"AdminCannotKickOwner", "DELETE", base + "/members/owner", nil, admin, http.StatusForbidden},
+			{"OwnerCannotLeave", "DELETE", base + "/members/owner", nil, owner, http.StatusForbidden},
+			{"MemberCannotKick", "DELETE", base + "/members/dave", nil, member, http.StatusForbidden},
+			{"AdminKicksMember", "DELETE", base + "/members/dave", nil, admin, http.StatusNoContent},
+			{"KickedMemberCannotPost", "POST", base + "/messages", send, outsider, http.StatusForbidden},
+			{"MemberLeaves", "DELETE", base + "/members/carol", nil, member, http.StatusNoContent},
+			{"LeaveTwice", "DELETE", base + "/members/carol", nil, member, http.StatusNotFound},
+			{"UnknownChat", "POST", "/api/chats/nonexistent/members", models.AddMemberRequest{Username: "bob"}, owner, http.StatusNotFound},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				rr := serveTestJSON(server, tt.method, tt.path, tt.body, tt.header)
+				if status := rr.Code; status != tt.want {
+					t.Errorf("handler returned wrong status code: got %v want %v: %s", status, tt.want, rr.Body.String())
+				}
+			})
+		}
+	})
+
+	t.Run("BotsActAsTheirOwner", func(t *testing.T) {
+		_, token := createTestAPIKey(t, server, "dave", models.CreateAPIKeyRequest{
+			BotName: "dave-bot",
+			Scopes:  []string{models.ScopeWrite},
+		})
+		if rr := postTestJSON(server, base+"/messages", send, bearer(token)); rr.Code != http.StatusForbidden {
+			t.Errorf("Expected a bot of a non-member not to post, got status %v", rr.Code)
+		}
+		joinTestChat(t, server, chat.ID, "dave")
+		if rr := postTestJSON(server, base+"/messages", send, bearer(token)); rr.Code != http.StatusCreated {
+			t.Errorf("Expected a bot of a member to post, got status %v", rr.Code)
+		}
+	})
+
+	t.Run("Rename", func(t *testing.T) {
+		rename := models.UpdateChatRequest{Name: "Renamed"}
+		if rr := serveTestJSON(server, "PATCH", base, rename, outsider); rr.Code != http.StatusForbidden {
+			t.Errorf("Expected members not to rename, got status %v", rr.Code)
+		}
+		if rr := serveTestJSON(server, "PATCH", base, models.UpdateChatRequest{}, admin); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected an empty name to be rejected, got status %v", rr.Code)
+		}
+
+		rr := serveTestJSON(server, "PATCH", base, rename, admin)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		var renamed models.Chat
+		if err := json.NewDecoder(rr.Body).Decode(&renamed); err != nil {
+			t.Fatalf("Failed to decode chat: %v", err)
+		}
+		if renamed.Name != "Renamed" || renamed.ID != chat.ID {
+			t.Errorf("Expected chat %s to be renamed, got %+v", chat.ID, renamed)
+		}
+	})
+
+	t.Run("ChatBeforeRoles_FirstJoin", func(t *testing.T) {
+		legacy, err := server.storage.CreateChat("Legacy")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+		legacyBase := "/api/chats/" + legacy.ID
+		if rr := postTestJSON(server, legacyBase+"/members", models.AddMemberRequest{Username: "carol"}, member); rr.Code != http.StatusCreated {
+			t.Fatalf("Expected carol to join, got status %v", rr.Code)
+		}
+		// Joining must not take posting away from everyone else
+		for name, header := range map[string]http.Header{"carol": member, "dave": outsider} {
+			if rr := postTestJSON(server, legacyBase+"/messages", send, header); rr.Code != http.StatusCreated {
+				t.Errorf("Expected %s to post after the first join, got status %v", name, rr.Code)
+			}
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if rr := serveTestRequest(server, "DELETE", base, admin); rr.Code != http.StatusForbidden {
+			t.Errorf("Expected admins not to delete, got status %v", rr.Code)
+		}
+		if rr := serveTestRequest(server, "DELETE", base, owner); rr.Code != http.StatusNoContent {
+			t.Fatalf("Expected the owner to delete, got status %v", rr.Code)
+		}
+		if rr := serveTestRequest(server, "GET", base+"/messages", nil); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected the chat's messages to be gone, got status %v", rr.Code)
+		}
+		if rr := serveTestRequest(server, "DELETE", base, owner); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected deleting twice to fail, got status %v", rr.Code)
+		}
+	})
+}
+
+func TestDeleteChatEndsStreams(t *testing.T) {
+	server := NewServer(storage.NewStorage())
+	ts := httptest.NewServer(server.router)
+	t.Cleanup(ts.Close)
+	owner := testAuthHeader(t, server, "owner")
+	chat := createTestChat(t, server, "Doomed")
+	base := "/api/chats/" + chat.ID
+
+	conn := dialTestWebSocket(t, ts, base+"/ws", nil)
+	events := openTestEventStream(t, ts.URL+base+"/events", "")
+	if rr := serveTestRequest(server, "DELETE", base, owner); rr.Code != http.StatusNoContent {
+		t.Fatalf("Failed to delete chat: status %d", rr.Code)
+	}
+
+	if event := readTestEvent(t, conn); event.Type != models.EventChatDeleted || event.Chat == nil || event.Chat.ID != chat.ID {
+		t.Errorf("Expected a chat_deleted event over the WebSocket, got %+v", event)
+	}
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("Expected the WebSocket to be closed normally, got %v", err)
+	}
+
+	if event, _ := nextTestEvent(t, events); event.event != models.EventChatDeleted {
+		t.Errorf("Expected a chat_deleted event over SSE, got %+v", event)
+	}
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Error("Expected the event stream to end after the deletion")
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Expected the event stream to end")
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"chat-app/internal/metrics"
+	"chat-app/internal/storage"
+
+	"github.com/gorilla/mux"
+)
+
+// Transports of the live streams counted by chat_active_streams
+const (
+	streamWebSocket = "websocket"
+	streamSSE       = "sse"
+	streamLongPoll  = "longpoll"
+)
+
+// unmatchedRoute labels requests that match no route
+const unmatchedRoute = "unmatched"
+
+// otherMethod labels requests with a method outside the standard ones
+const otherMethod = "other"
+
+// methodLabel returns the label of a request method. Clients may send any
+// method, so only the standard ones get their own series.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodHead, http.MethodOptions:
+		return method
+	}
+	return otherMethod
+}
+
+// serverMetrics are the metrics exposed on /metrics
+type serverMetrics struct {
+	registry *metrics.Registry
+	requests *metrics.CounterVec
+	duration *metrics.HistogramVec
+	streams  *metrics.GaugeVec
+}
+
+// newServerMetrics creates the server's metrics, along with those of store
+// if it keeps any
+func newServerMetrics(store storage.Store) *serverMetrics {
+	m := &serverMetrics{
+		registry: metrics.NewRegistry(),
+		requests: metrics.NewCounterVec("chat_http_requests_total",
+			"HTTP requests served, by route, method and status.", "route", "method", "status"),
+		duration: metrics.NewHistogramVec("chat_http_request_duration_seconds",
+			"Time taken to serve HTTP requests, by route and method. Streams count until they close.",
+			metrics.DefaultBuckets, "route", "method"),
+		streams: metrics.NewGaugeVec("chat_active_streams",
+			"Open live message streams, by transport.", "transport"),
+	}
+	for _, transport := range []string{streamWebSocket, streamSSE, streamLongPoll} {
+		m.streams.With(transport)
+	}
+	m.registry.MustRegister(m.requests, m.duration, m.streams)
+	if instrumented, ok := store.(interface{ RegisterMetrics(*metrics.Registry) }); ok {
+		instrumented.RegisterMetrics(m.registry)
+	}
+	return m
+}
+
+// trackStream counts a live stream as open until the returned function is
+// called
+func (s *Server) trackStream(transport string) (done func()) {
+	gauge := s.metrics.streams.With(transport)
+	gauge.Inc()
+	return gauge.Dec
+}
+
+// routeKey is the context key of the route template a request matched
+type routeKey struct{}
+
+// measureRequests counts requests and times them by route. The route is
+// only known once the router has matched it, so the router's labelRoute
+// middleware fills it in.
+func (s *Server) measureRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		route := unmatchedRoute
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), routeKey{}, &route)))
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		method := methodLabel(r.Method)
+		s.metrics.requests.With(route, method, strconv.Itoa(rec.status)).Inc()
+		s.metrics.duration.With(route, method).ObserveDuration(time.Since(start))
+	})
+}
+
+// labelRoute records the template of the route a request matched for
+// measureRequests. Templates rather than paths keep the number of series
+// bounded.
+func labelRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route, ok := r.Context().Value(routeKey{}).(*string); ok {
+			if template, err := mux.CurrentRoute(r).GetPathTemplate(); err == nil {
+				*route = template
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleMetrics serves the metrics in the Prometheus text format
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = s.metrics.registry.WriteTo(w)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"chat-app/internal/storage"
+)
+
+func TestMetrics(t *testing.T) {
+	server := NewServer(storage.NewStorage())
+	server.logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	chat := createTestChat(t, server, "Metrics")
+	sendTestMessage(t, server, chat.ID, "alice", "Counted")
+
+	serveMethod := func(method, path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, httptest.NewRequest(method, path, nil))
+		return rr
+	}
+	serve := func(path string) *httptest.ResponseRecorder {
+		return serveMethod("GET", path)
+	}
+	scrape := func(t *testing.T) string {
+		t.Helper()
+		rr := serve("/metrics")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200 from /metrics, got %d", rr.Code)
+		}
+		if contentType := rr.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+			t.Errorf("Expected the Prometheus text format, got %q", contentType)
+		}
+		return rr.Body.String()
+	}
+	expect := func(t *testing.T, body string, lines ...string) {
+		t.Helper()
+		for _, line := range lines {
+			if !strings.Contains(body, line) {
+				t.Errorf("Expected %q in metrics:\n%s", line, body)
+			}
+		}
+	}
+
+	t.Run("Requests", func(t *testing.T) {
+		serve("/api/chats/" + chat.ID + "/messages")
+		serve("/api/chats/" + chat.ID + "/messages")
+		serve("/api/chats/nonexistent/messages")
+		serve("/api/nowhere")
+
+		expect(t, scrape(t),
+			`chat_http_requests_total{route="/api/chats/{chatID}/messages",method="GET",status="200"} 2`,
+			`chat_http_requests_total{route="/api/chats/{chatID}/messages",method="GET",status="404"} 1`,
+			`chat_http_requests_total{route="unmatched",method="GET",status="404"} 1`,
+			`chat_http_request_duration_seconds_count{route="/api/chats/{chatID}/messages",method="GET"} 3`,
+			`chat_http_request_duration_seconds_bucket{route="/api/chats/{chatID}/messages",method="GET",le="+Inf"} 3`,
+		)
+	})
+
+	t.Run("UnknownMethods", func(t *testing.T) {
+		serveMethod("BREW", "/api/nowhere")
+		serveMethod("FROB", "/api/nowhere")
+
+		body := scrape(t)
+		expect(t, body, `chat_http_requests_total{route="unmatched",method="other",status="404"} 2`)
+		if strings.Contains(body, "BREW") || strings.Contains(body, "FROB") {
+			t.Errorf("Expected made-up methods not to become labels:\n%s", body)
+		}
+	})
+
+	t.Run("Totals", func(t *testing.T) {
+		expect(t, scrape(t), "chat_chats 1\n", "chat_messages 1\n")
+	})
+
+	t.Run("Storage", func(t *testing.T) {
+		expect(t, scrape(t),
+			`chat_storage_operation_duration_seconds_count{op="Post"} 1`,
+			`chat_storage_lock_wait_seconds_count{mode="read"}`,
+			`chat_storage_lock_wait_seconds_count{mode="write"}`,
+		)
+	})
+
+	t.Run("ActiveStreams", func(t *testing.T) {
+		ts := httptest.NewServer(server)
+		defer ts.Close()
+		expect(t, scrape(t), `chat_active_streams{transport="websocket"} 0`)
+
+		conn := dialTestWebSocket(t, ts, "/api/chats/"+chat.ID+"/ws?since_seq=0", nil)
+		// The backlog arrives once the stream is being served
+		readTestEvent(t, conn)
+		expect(t, scrape(t),
+			`chat_active_streams{transport="websocket"} 1`,
+			`chat_active_streams{transport="sse"} 0`,
+		)
+
+		conn.Close()
+		deadline := time.Now().Add(2 * time.Second)
+		for !strings.Contains(scrape(t), `chat_active_streams{transport="websocket"} 0`) {
+			if time.Now().After(deadline) {
+				t.Fatal("Expected the closed stream to stop being counted")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
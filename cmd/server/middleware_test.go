@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"chat-app/internal/models"
+	"chat-app/internal/storage"
+)
+
+func TestMiddleware(t *testing.T) {
+	server := NewServer(storage.NewStorage())
+	var logs bytes.Buffer
+	logger, err := newLogger(&logs, "json", "info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.logger = logger
+	server.router.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("handler exploded")
+	})
+	chat := createTestChat(t, server, "Middleware")
+
+	serve := func(method, path string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		return rr
+	}
+
+	requestID := func(id string) http.Header {
+		header := http.Header{}
+		header.Set(requestIDHeader, id)
+		return header
+	}
+
+	// lastEntry decodes the newest log entry
+	lastEntry := func(t *testing.T) map[string]any {
+		t.Helper()
+		lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(lines[len(lines)-1]), &entry); err != nil {
+			t.Fatalf("Failed to decode log entry: %v: %s", err, logs.String())
+		}
+		return entry
+	}
+
+	t.Run("RequestID", func(t *testing.T) {
+		rr := serve("GET", "/api/chats/"+chat.ID, nil)
+		generated := rr.Header().Get(requestIDHeader)
+		if generated == "" {
+			t.Fatal("Expected a generated request ID")
+		}
+		if again := serve("GET", "/api/chats/"+chat.ID, nil).Header().Get(requestIDHeader); again == generated {
+			t.Errorf("Expected a new request ID for each request, got %q twice", again)
+		}
+
+		rr = serve("GET", "/api/chats/"+chat.ID, requestID("trace-42"))
+		if id := rr.Header().Get(requestIDHeader); id != "trace-42" {
+			t.Errorf("Expected the client's request ID to be kept, got %q", id)
+		}
+		rr = serve("GET", "/api/chats/nonexistent", requestID("trace-43"))
+		var resp models.ErrorResponse
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil || resp.Error.RequestID != "trace-43" {
+			t.Errorf("Expected errors to repeat the client's request ID, got %+v (%v)", resp.Error, err)
+		}
+
+		rr = serve("GET", "/api/chats/"+chat.ID, requestID("bad id\twith spaces"))
+		if id := rr.Header().Get(requestIDHeader); id == "" || strings.ContainsAny(id, " \t") {
+			t.Errorf("Expected an unusable request ID to be replaced, got %q", id)
+		}
+	})
+
+	t.Run("AccessLog", func(t *testing.T) {
+		logs.Reset()
+		serve("GET", "/api/chats/"+chat.ID, requestID("logged"))
+		entry := lastEntry(t)
+		if entry["msg"] != "request" || entry["level"] != "INFO" {
+			t.Errorf("Expected an info access log entry, got %v", entry)
+		}
+		if entry["request_id"] != "logged" || entry["method"] != "GET" || entry["path"] != "/api/chats/"+chat.ID {
+			t.Errorf("Expected the request in the access log, got %v", entry)
+		}
+		if entry["status"] != float64(http.StatusOK) || entry["bytes"].(float64) <= 0 {
+			t.Errorf("Expected status 200 with a body, got %v", entry)
+		}
+		if _, ok := entry["duration"].(float64); !ok {
+			t.Errorf("Expected a duration, got %v", entry)
+		}
+
+		serve("GET", "/api/nowhere", nil)
+		if entry := lastEntry(t); entry["status"] != float64(http.StatusNotFound) {
+			t.Errorf("Expected unmatched requests to be logged, got %v", entry)
+		}
+	})
+
+	t.Run("PanicRecovery", func(t *testing.T) {
+		logs.Reset()
+		rr := serve("GET", "/panic", requestID("boom"))
+		if rr.Code != http.StatusInternalServerError {
+			t.Fatalf("Expected 500 after a panic, got %d", rr.Code)
+		}
+		var resp models.ErrorResponse
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil || resp.Error.Code != models.ErrorInternal || resp.Error.RequestID != "boom" {
+			t.Errorf("Expected an internal error envelope, got %+v (%v)", resp.Error, err)
+		}
+
+		lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("Expected the panic and the request to be logged, got %s", logs.String())
+		}
+		var panicked map[string]any
+		if err := json.Unmarshal([]byte(lines[0]), &panicked); err != nil {
+			t.Fatal(err)
+		}
+		if panicked["level"] != "ERROR" || panicked["panic"] != "handler exploded" || panicked["request_id"] != "boom" {
+			t.Errorf("Expected the panic to be logged, got %v", panicked)
+		}
+		if stack, _ := panicked["stack"].(string); !strings.Contains(stack, "TestMiddleware") {
+			t.Errorf("Expected the panic's stack, got %q", stack)
+		}
+		if entry := lastEntry(t); entry["level"] != "ERROR" || entry["status"] != float64(http.StatusInternalServerError) {
+			t.Errorf("Expected the failed request to be logged as an error, got %v", entry)
+		}
+
+		// The server keeps serving
+		if rr := serve("GET", "/api/chats/"+chat.ID, nil); rr.Code != http.StatusOK {
+			t.Errorf("Expected the server to keep working after a panic, got %d", rr.Code)
+		}
+	})
+
+	t.Run("Streams", func(t *testing.T) {
+		// Stream handlers log after the subtest ends, so they get a
+		// server of their own
+		server := NewServer(storage.NewStorage())
+		server.logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+		ts := httptest.NewServer(server)
+		// Closing waits for the event stream, which is closed by cleanup
+		t.Cleanup(ts.Close)
+		chat := createTestChat(t, server, "Streams")
+
+		conn := dialTestWebSocket(t, ts, "/api/chats/"+chat.ID+"/ws", nil)
+		defer conn.Close()
+		events := openTestEventStream(t, ts.URL+"/api/chats/"+chat.ID+"/events", "")
+		sendTestMessage(t, server, chat.ID, "alice", "Through the middleware")
+
+		if event := readTestEvent(t, conn); event.Message == nil || event.Message.Content != "Through the middleware" {
+			t.Errorf("Expected the message over WebSocket, got %+v", event)
+		}
+		if _, msg := nextTestEvent(t, events); msg.Content != "Through the middleware" {
+			t.Errorf("Expected the message over SSE, got %+v", msg)
+		}
+	})
+
+	t.Run("Config", func(t *testing.T) {
+		if _, err := newLogger(&logs, "xml", "info"); err == nil {
+			t.Error("Expected an unknown log format to be rejected")
+		}
+		if _, err := newLogger(&logs, "text", "loud"); err == nil {
+			t.Error("Expected an unknown log level to be rejected")
+		}
+		logger, err := newLogger(&logs, "text", "warn")
+		if err != nil {
+			t.Fatal(err)
+		}
+		logs.Reset()
+		logger.Info("hidden")
+		logger.Warn("shown")
+		if out := logs.String(); strings.Contains(out, "hidden") || !strings.Contains(out, "msg=shown") {
+			t.Errorf("Expected only warnings in text format, got %q", out)
+		}
+	})
+}
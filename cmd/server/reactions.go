@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"chat-app/internal/models"
+	"chat-app/internal/storage"
+)
+
+// maxEmojiLength bounds the size of a reaction in bytes. It leaves room for
+// shortcodes such as ":thumbsup:" and multi-rune emoji sequences.
+const maxEmojiLength = 64
+
+// validEmoji reports whether emoji is acceptable as a reaction
+func validEmoji(emoji string) bool {
+	return emoji != "" && len(emoji) <= maxEmojiLength && !strings.ContainsFunc(emoji, unicode.IsSpace)
+}
+
+// decodeReaction reads and checks the emoji of a reaction request, writing a
+// 400 if it is unusable
+func decodeReaction(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req models.ReactionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return "", false
+	}
+	if !validEmoji(req.Emoji) {
+		invalidField(w, "emoji", "Emoji must be 1 to 64 bytes without spaces")
+		return "", false
+	}
+	return req.Emoji, true
+}
+
+// handleAddReaction reacts to a message on behalf of the caller, who must
+// be able to post in the chat
+func (s *Server) handleAddReaction(w http.ResponseWriter, r *http.Request) {
+	emoji, ok := decodeReaction(w, r)
+	if !ok {
+		return
+	}
+	msg, ok := s.chatMessage(w, r)
+	if !ok {
+		return
+	}
+	caller := identityFrom(r)
+	if !s.hasRole(msg.ChatID, caller, models.RoleMember) {
+		writeError(w, http.StatusForbidden, "Your role does not allow reacting in this chat")
+		return
+	}
+
+	updated, err := s.storage.AddReaction(msg.ID, caller.Username, emoji)
+	if errors.Is(err, storage.ErrMessageNotFound) {
+		writeError(w, http.StatusNotFound, "Message not found")
+		return
+	}
+	if errors.Is(err, storage.ErrMessageDeleted) {
+		writeError(w, http.StatusConflict, "Message was deleted")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to add reaction")
+		return
+	}
+	writeJSON(w, http.StatusOK, updated)
+}
+
+// handleRemoveReaction takes back one of the caller's reactions
+func (s *Server) handleRemoveReaction(w http.ResponseWriter, r *http.Request) {
+	emoji, ok := decodeReaction(w, r)
+	if !ok {
+		return
+	}
+	msg, ok := s.chatMessage(w, r)
+	if !ok {
+		return
+	}
+
+	updated, err := s.storage.RemoveReaction(msg.ID, identityFrom(r).Username, emoji)
+	if errors.Is(err, storage.ErrMessageNotFound) {
+		writeError(w, http.StatusNotFound, "Message not found")
+		return
+	}
+	if errors.Is(err, storage.ErrReactionNotFound) {
+		writeError(w, http.StatusNotFound, "Reaction not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to remove reaction")
+		return
+	}
+	writeJSON(w, http.StatusOK, updated)
+}
+
+func (s *Server) handleListReactions(w http.ResponseWriter, r *http.Request) {
+	msg, ok := s.chatMessage(w, r)
+	if !ok {
+		return
+	}
+	reactions, err := s.storage.ListReactions(msg.ID)
+	if errors.Is(err, storage.ErrMessageNotFound) {
+		writeError(w, http.StatusNotFound, "Message not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to list reactions")
+		return
+	}
+	writeJSON(w, http.StatusOK, reactions)
+}
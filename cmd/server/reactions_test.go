@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"chat-app/internal/models"
+	"chat-app/internal/storage"
+)
+
+func TestReactions(t *testing.T) {
+	server := NewServer(storage.NewStorage())
+	ts := httptest.NewServer(server.router)
+	defer ts.Close()
+
+	alice := testAuthHeader(t, server, "alice")
+	bob := testAuthHeader(t, server, "bob")
+	outsider := testAuthHeader(t, server, "dave")
+
+	chat := createTestChat(t, server, "Reacting Chat")
+	joinTestChat(t, server, chat.ID, "bob")
+	msg := sendTestMessage(t, server, chat.ID, "alice", "Deployed")
+	path := "/api/chats/" + chat.ID + "/messages/" + msg.ID + "/reactions"
+
+	react := func(method string, header http.Header, emoji string, want int) models.Message {
+		t.Helper()
+		rr := serveTestJSON(server, method, path, models.ReactionRequest{Emoji: emoji}, header)
+		if status := rr.Code; status != want {
+			t.Fatalf("handler returned wrong status code: got %v want %v: %s", status, want, rr.Body.String())
+		}
+		var updated models.Message
+		_ = json.NewDecoder(rr.Body).Decode(&updated)
+		return updated
+	}
+
+	t.Run("Broadcast", func(t *testing.T) {
+		conn := dialTestWebSocket(t, ts, "/api/chats/"+chat.ID+"/ws", nil)
+		react("POST", alice, ":thumbsup:", http.StatusOK)
+
+		event := readTestEvent(t, conn)
+		if event.Type != models.EventReaction || event.Message.Reactions[":thumbsup:"] != 1 {
+			t.Errorf("Expected reaction event, got %+v", event)
+		}
+	})
+
+	t.Run("Counts", func(t *testing.T) {
+		react("POST", bob, ":thumbsup:", http.StatusOK)
+		updated := react("POST", bob, "🎉", http.StatusOK)
+		if updated.Reactions[":thumbsup:"] != 2 || updated.Reactions["🎉"] != 1 {
+			t.Errorf("Expected 2 :thumbsup: and 1 🎉, got %v", updated.Reactions)
+		}
+
+		rr := serveTestRequest(server, "GET", "/api/chats/"+chat.ID+"/messages", nil)
+		var messages []*models.Message
+		if err := json.NewDecoder(rr.Body).Decode(&messages); err != nil {
+			t.Fatalf("Failed to decode messages: %v", err)
+		}
+		if len(messages) != 1 || messages[0].Reactions[":thumbsup:"] != 2 {
+			t.Errorf("Expected the counts with the message history, got %+v", messages)
+		}
+
+		rr = serveTestRequest(server, "GET", path, nil)
+		var reactions []*models.Reaction
+		if err := json.NewDecoder(rr.Body).Decode(&reactions); err != nil {
+			t.Fatalf("Failed to decode reactions: %v", err)
+		}
+		if len(reactions) != 3 || reactions[0].Username != "alice" {
+			t.Errorf("Expected 3 reactions, alice's first, got %+v", reactions)
+		}
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		updated := react("DELETE", bob, "🎉", http.StatusOK)
+		if _, present := updated.Reactions["🎉"]; present {
+			t.Errorf("Expected 🎉 to be gone, got %v", updated.Reactions)
+		}
+		react("DELETE", bob, "🎉", http.StatusNotFound)
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		react("POST", nil, ":wave:", http.StatusUnauthorized)
+		react("POST", outsider, ":wave:", http.StatusForbidden)
+		react("POST", alice, "", http.StatusBadRequest)
+		react("POST", alice, "two words", http.StatusBadRequest)
+
+		other := createTestChat(t, server, "Other Chat")
+		rr := serveTestJSON(server, "POST", "/api/chats/"+other.ID+"/messages/"+msg.ID+"/reactions",
+			models.ReactionRequest{Emoji: ":wave:"}, alice)
+		if status := rr.Code; status != http.StatusNotFound {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+		}
+	})
+}
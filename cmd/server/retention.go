@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"chat-app/internal/models"
+	"chat-app/internal/storage"
+
+	"github.com/gorilla/mux"
+)
+
+// defaultJanitorInterval is how often expired messages are removed
+const defaultJanitorInterval = time.Minute
+
+// handleGetRetention describes the retention policy in force in a chat: its
+// own, or the server's if it has none
+func (s *Server) handleGetRetention(w http.ResponseWriter, r *http.Request) {
+	chat, exists := s.storage.GetChat(mux.Vars(r)["chatID"])
+	if !exists {
+		writeError(w, http.StatusNotFound, "Chat not found")
+		return
+	}
+	resp := models.RetentionResponse{RetentionPolicy: s.retention, Inherited: true, LegalHold: chat.LegalHold}
+	if chat.Retention != nil {
+		resp.RetentionPolicy = *chat.Retention
+		resp.Inherited = false
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleSetRetention gives a chat its own retention policy. Zero fields set
+// no limit, so an empty policy keeps the chat's messages for good whatever
+// the server's policy is.
+func (s *Server) handleSetRetention(w http.ResponseWriter, r *http.Request) {
+	var policy models.RetentionPolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if policy.MaxMessages < 0 {
+		invalidField(w, "max_messages", "max_messages must not be negative")
+		return
+	}
+	if policy.MaxAge < 0 {
+		invalidField(w, "max_age", "max_age must not be negative")
+		return
+	}
+	s.updateRetention(w, r, &policy)
+}
+
+// handleClearRetention makes a chat follow the server's retention policy
+func (s *Server) handleClearRetention(w http.ResponseWriter, r *http.Request) {
+	s.updateRetention(w, r, nil)
+}
+
+func (s *Server) updateRetention(w http.ResponseWriter, r *http.Request, policy *models.RetentionPolicy) {
+	chatID := mux.Vars(r)["chatID"]
+	if _, exists := s.storage.GetChat(chatID); !exists {
+		writeError(w, http.StatusNotFound, "Chat not found")
+		return
+	}
+	if !s.hasRole(chatID, identityFrom(r), models.RoleAdmin) {
+		writeError(w, http.StatusForbidden, "Only chat admins can change the retention policy")
+		return
+	}
+
+	_, err := s.storage.SetRetention(chatID, policy)
+	if errors.Is(err, storage.ErrChatNotFound) {
+		writeError(w, http.StatusNotFound, "Chat not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to update retention policy")
+		return
+	}
+	s.handleGetRetention(w, r)
+}
+
+// runJanitor removes expired messages every interval until ctx is done. An
+// interval of 0 or less disables it.
+func (s *Server) runJanitor(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			expired, err := s.storage.ApplyRetention(s.retention, time.Now())
+			if err != nil {
+				s.logger.Error("Failed to apply retention", "error", err)
+			}
+			if len(expired) > 0 {
+				s.logger.Info("Removed expired messages", "count", len(expired))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
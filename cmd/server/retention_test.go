@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"chat-app/internal/models"
+	"chat-app/internal/storage"
+)
+
+func TestRetention(t *testing.T) {
+	server := NewServer(storage.NewStorage())
+	server.retention = models.RetentionPolicy{MaxMessages: 2}
+	ts := httptest.NewServer(server.router)
+	defer ts.Close()
+
+	owner := testAuthHeader(t, server, "owner")
+	alice := testAuthHeader(t, server, "alice")
+	chat := createTestChat(t, server, "Ephemeral")
+	joinTestChat(t, server, chat.ID, "alice")
+	path := "/api/chats/" + chat.ID + "/retention"
+
+	getRetention := func() models.RetentionResponse {
+		t.Helper()
+		rr := serveTestRequest(server, "GET", path, nil)
+		var resp models.RetentionResponse
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode retention: %v", err)
+		}
+		return resp
+	}
+
+	t.Run("Policy", func(t *testing.T) {
+		if resp := getRetention(); !resp.Inherited || resp.MaxMessages != 2 {
+			t.Errorf("Expected the server's policy, got %+v", resp)
+		}
+
+		policy := map[string]any{"max_messages": 10, "max_age": "720h"}
+		if rr := serveTestJSON(server, "PUT", path, policy, alice); rr.Code != http.StatusForbidden {
+			t.Errorf("Expected members not to change retention, got status %v", rr.Code)
+		}
+		rr := serveTestJSON(server, "PUT", path, policy, owner)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+		}
+		if resp := getRetention(); resp.Inherited || resp.MaxMessages != 10 || time.Duration(resp.MaxAge) != 720*time.Hour {
+			t.Errorf("Expected the chat's own policy, got %+v", resp)
+		}
+		for _, bad := range []map[string]any{{"max_age": "soon"}, {"max_messages": -1}} {
+			if rr := serveTestJSON(server, "PUT", path, bad, owner); rr.Code != http.StatusBadRequest {
+				t.Errorf("Expected %v to be refused, got status %v", bad, rr.Code)
+			}
+		}
+
+		if rr := serveTestRequest(server, "DELETE", path, owner); rr.Code != http.StatusOK {
+			t.Fatalf("Failed to clear retention: status %d", rr.Code)
+		}
+		if resp := getRetention(); !resp.Inherited {
+			t.Errorf("Expected the server's policy again, got %+v", resp)
+		}
+	})
+
+	t.Run("LegalHold", func(t *testing.T) {
+		hold, lift := true, false
+		if rr := serveTestJSON(server, "PATCH", "/api/chats/"+chat.ID, models.UpdateChatRequest{LegalHold: &hold}, alice); rr.Code != http.StatusForbidden {
+			t.Errorf("Expected members not to place a legal hold, got status %v", rr.Code)
+		}
+		if rr := serveTestJSON(server, "PATCH", "/api/chats/"+chat.ID, models.UpdateChatRequest{LegalHold: &hold}, owner); rr.Code != http.StatusOK {
+			t.Fatalf("Failed to place legal hold: status %d", rr.Code)
+		}
+		for i := 0; i < 3; i++ {
+			sendTestMessage(t, server, chat.ID, "alice", "Evidence")
+		}
+		if expired, _ := server.storage.ApplyRetention(server.retention, time.Now()); len(expired) != 0 {
+			t.Errorf("Expected nothing to expire under legal hold, got %d messages", len(expired))
+		}
+		if rr := serveTestJSON(server, "PATCH", "/api/chats/"+chat.ID, models.UpdateChatRequest{LegalHold: &lift}, owner); rr.Code != http.StatusOK {
+			t.Fatalf("Failed to lift legal hold: status %d", rr.Code)
+		}
+	})
+
+	t.Run("Janitor", func(t *testing.T) {
+		conn := dialTestWebSocket(t, ts, "/api/chats/"+chat.ID+"/ws", nil)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go server.runJanitor(ctx, 10*time.Millisecond)
+
+		event := readTestEvent(t, conn)
+		if event.Type != models.EventExpire || len(event.Messages) != 1 || event.Messages[0].Seq != 1 {
+			t.Errorf("Expected the oldest message to expire, got %+v", event)
+		}
+	})
+
+	t.Run("JanitorDisabled", func(t *testing.T) {
+		done := make(chan struct{})
+		go func() {
+			server.runJanitor(context.Background(), 0)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Error("Expected an interval of 0 to disable the janitor")
+		}
+	})
+}
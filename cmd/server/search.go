@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"chat-app/internal/storage"
+)
+
+// handleSearch finds messages in the chats the caller can see. q holds the
+// words to look for; chat, user, from and to narrow the search and limit
+// caps the number of results.
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	query := storage.SearchQuery{
+		Text:     values.Get("q"),
+		Username: values.Get("user"),
+		Limit:    defaultPageLimit,
+	}
+	if query.Text == "" {
+		invalidField(w, "q", "q is required")
+		return
+	}
+	if raw := values.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 {
+			invalidField(w, "limit", "limit must be a positive integer")
+			return
+		}
+		query.Limit = min(limit, maxPageLimit)
+	}
+	for name, bound := range map[string]*time.Time{"from": &query.From, "to": &query.To} {
+		raw := values.Get(name)
+		if raw == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			invalidField(w, name, name+" must be an RFC 3339 time")
+			return
+		}
+		*bound = t
+	}
+
+	caller := identityFrom(r)
+	if chatID := values.Get("chat"); chatID != "" {
+		chat, exists := s.storage.GetChat(chatID)
+		if !exists || !s.canRead(chat, caller) {
+			writeError(w, http.StatusNotFound, "Chat not found")
+			return
+		}
+		query.ChatIDs = []string{chatID}
+	} else {
+		for _, chat := range s.storage.ListChats() {
+			if s.canRead(chat, caller) {
+				query.ChatIDs = append(query.ChatIDs, chat.ID)
+			}
+		}
+	}
+
+	writeJSON(w, http.StatusOK, s.storage.Search(query))
+}
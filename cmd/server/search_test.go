@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"chat-app/internal/models"
+	"chat-app/internal/storage"
+)
+
+func TestSearch(t *testing.T) {
+	server := NewServer(storage.NewStorage())
+	owner := testAuthHeader(t, server, "owner")
+	outsider := testAuthHeader(t, server, "mallory")
+
+	rr := postTestJSON(server, "/api/chats", models.CreateChatRequest{Name: "Secret", Visibility: models.VisibilityPrivate}, owner)
+	var secret models.Chat
+	if err := json.NewDecoder(rr.Body).Decode(&secret); err != nil {
+		t.Fatalf("Failed to decode chat: %v", err)
+	}
+	lobby := createTestChat(t, server, "Lobby")
+	link := sendTestMessage(t, server, lobby.ID, "alice", "The deploy link is https://ci.example.com/42")
+	sendTestMessage(t, server, lobby.ID, "bob", "Deploying now")
+	sendTestMessage(t, server, secret.ID, "owner", "Secret deploy plans")
+
+	search := func(header http.Header, params url.Values, want int) []*models.SearchResult {
+		t.Helper()
+		rr := serveTestRequest(server, "GET", "/api/search?"+params.Encode(), header)
+		if status := rr.Code; status != want {
+			t.Fatalf("handler returned wrong status code: got %v want %v: %s", status, want, rr.Body.String())
+		}
+		var results []*models.SearchResult
+		_ = json.NewDecoder(rr.Body).Decode(&results)
+		return results
+	}
+
+	t.Run("Visibility", func(t *testing.T) {
+		if results := search(owner, url.Values{"q": {"deploy"}}, http.StatusOK); len(results) != 3 {
+			t.Errorf("Expected members to find private messages, got %d results", len(results))
+		}
+		if results := search(outsider, url.Values{"q": {"deploy"}}, http.StatusOK); len(results) != 2 {
+			t.Errorf("Expected private messages to be hidden, got %d results", len(results))
+		}
+		search(outsider, url.Values{"q": {"deploy"}, "chat": {secret.ID}}, http.StatusNotFound)
+	})
+
+	t.Run("APIKeyScope", func(t *testing.T) {
+		_, token := createTestAPIKey(t, server, "owner", models.CreateAPIKeyRequest{
+			BotName: "search-bot", Scopes: []string{models.ScopeRead}, Chats: []string{lobby.ID},
+		})
+		results := search(bearer(token), url.Values{"q": {"deploy"}}, http.StatusOK)
+		if len(results) != 2 {
+			t.Fatalf("Expected only the key's chats to be searched, got %d results", len(results))
+		}
+		for _, result := range results {
+			if result.Message.ChatID != lobby.ID {
+				t.Errorf("Expected results from the key's chat only, got one from %s", result.Message.ChatID)
+			}
+		}
+		search(bearer(token), url.Values{"q": {"deploy"}, "chat": {secret.ID}}, http.StatusNotFound)
+	})
+
+	t.Run("Ranked", func(t *testing.T) {
+		results := search(nil, url.Values{"q": {"deploy link"}, "chat": {lobby.ID}}, http.StatusOK)
+		if len(results) != 1 || results[0].Message.ID != link.ID {
+			t.Fatalf("Expected only the link, got %+v", results)
+		}
+		if want := "The **deploy** **link** is https://ci.example.com/42"; results[0].Highlight != want {
+			t.Errorf("Expected highlight %q, got %q", want, results[0].Highlight)
+		}
+		results = search(nil, url.Values{"q": {"deploy"}, "user": {"bob"}}, http.StatusOK)
+		if len(results) != 1 || results[0].Message.Username != "bob" {
+			t.Errorf("Expected only bob's message, got %+v", results)
+		}
+	})
+
+	t.Run("TimeRange", func(t *testing.T) {
+		from := link.Timestamp.Add(time.Nanosecond).Format(time.RFC3339Nano)
+		results := search(nil, url.Values{"q": {"deploy"}, "from": {from}}, http.StatusOK)
+		if len(results) != 1 || results[0].Message.ID == link.ID {
+			t.Errorf("Expected only the later public message, got %+v", results)
+		}
+		to := link.Timestamp.Format(time.RFC3339Nano)
+		if results := search(nil, url.Values{"q": {"deploy"}, "to": {to}}, http.StatusOK); len(results) != 0 {
+			t.Errorf("Expected nothing before the first message, got %+v", results)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		search(nil, url.Values{}, http.StatusBadRequest)
+		search(nil, url.Values{"q": {"deploy"}, "from": {"yesterday"}}, http.StatusBadRequest)
+		search(nil, url.Values{"q": {"deploy"}, "limit": {"-1"}}, http.StatusBadRequest)
+		search(nil, url.Values{"q": {"deploy"}, "chat": {"nonexistent"}}, http.StatusNotFound)
+	})
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"chat-app/internal/models"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// sseWriteWait is the time allowed to write one event. Each write
+	// extends the deadline, so streams outlive the server's WriteTimeout.
+	sseWriteWait = 10 * time.Second
+	// sseHeartbeat is how often a comment is sent to keep idle streams open
+	sseHeartbeat = 15 * time.Second
+	// sseRetry is the reconnection delay suggested to clients
+	sseRetry = 2 * time.Second
+)
+
+// handleEvents streams a chat's messages as Server-Sent Events. Each event
+// carries the message's sequence number as its ID, so a reconnecting client
+// that sends Last-Event-ID (or since_seq) resumes without gaps.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	chatID := vars["chatID"]
+
+	sinceSeq := int64(-1)
+	for _, param := range []struct{ name, value string }{
+		{"since_seq", r.URL.Query().Get("since_seq")},
+		{"Last-Event-ID", r.Header.Get("Last-Event-ID")},
+	} {
+		if param.value == "" {
+			continue
+		}
+		seq, err := parseSeq(param.value)
+		if err != nil {
+			invalidField(w, param.name, param.name+" "+err.Error())
+			return
+		}
+		sinceSeq = seq
+	}
+
+	// Subscribe before reading the backlog so nothing falls in between, and
+	// before checking the chat so that a deletion ends the stream
+	sub := s.storage.Subscribe(chatID)
+	defer sub.Close()
+
+	if _, exists := s.storage.GetChat(chatID); !exists {
+		writeError(w, http.StatusNotFound, "Chat not found")
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	defer s.trackStream(streamSSE)()
+
+	write := func(format string, args ...any) bool {
+		// Not every ResponseWriter supports deadlines; the stream still
+		// works without one
+		_ = rc.SetWriteDeadline(time.Now().Add(sseWriteWait))
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+	send := func(event *models.Event) bool {
+		var payload any = event.Message
+		switch event.Type {
+		case models.EventExpire:
+			payload = event.Messages
+		case models.EventChatDeleted:
+			payload = event.Chat
+		}
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return false
+		}
+		// Edits, deletions and expiries refer back to earlier messages, so
+		// they must not move the client's Last-Event-ID backwards
+		if event.Type != models.EventMessage {
+			return write("event: %s\ndata: %s\n\n", event.Type, data)
+		}
+		return write("id: %d\nevent: %s\ndata: %s\n\n", event.Message.Seq, event.Type, data)
+	}
+
+	if !write("retry: %d\n\n", sseRetry.Milliseconds()) {
+		return
+	}
+
+	lastSeq := sinceSeq
+	if sinceSeq >= 0 {
+		var ok bool
+		lastSeq, ok = s.streamBacklog(chatID, sinceSeq, func(msg *models.Message) bool {
+			return send(&models.Event{Type: models.EventMessage, Message: msg})
+		})
+		if !ok {
+			return
+		}
+	}
+
+	ticker := time.NewTicker(sseHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event, ok := <-sub.Events():
+			// A dropped subscription ends the stream; the client
+			// reconnects with Last-Event-ID and catches up
+			if !ok {
+				return
+			}
+			if isStale(event, lastSeq) {
+				continue
+			}
+			if !send(event) {
+				return
+			}
+		case <-ticker.C:
+			if !write(": heartbeat\n\n") {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-s.quit:
+			// The client reconnects to another server and catches up
+			return
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"chat-app/internal/models"
+	"chat-app/internal/storage"
+)
+
+// sseEvent is one parsed Server-Sent Event
+type sseEvent struct {
+	id    string
+	event string
+	data  string
+}
+
+// openTestEventStream connects to an event stream and returns a channel of
+// parsed events that is closed when the stream ends
+func openTestEventStream(t *testing.T, url, lastEventID string) <-chan sseEvent {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to open event stream: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Expected text/event-stream, got %s", ct)
+	}
+
+	events := make(chan sseEvent)
+	go func() {
+		defer close(events)
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+
+		var current sseEvent
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				if current.data != "" {
+					select {
+					case events <- current:
+					case <-ctx.Done():
+						return
+					}
+				}
+				current = sseEvent{}
+			case strings.HasPrefix(line, "id: "):
+				current.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				current.event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				current.data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}()
+	return events
+}
+
+func nextTestEvent(t *testing.T, events <-chan sseEvent) (sseEvent, models.Message) {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("Event stream ended unexpectedly")
+		}
+		var msg models.Message
+		if err := json.Unmarshal([]byte(event.data), &msg); err != nil {
+			t.Fatalf("Failed to decode event data: %v", err)
+		}
+		return event, msg
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for event")
+	}
+	return sseEvent{}, models.Message{}
+}
+
+func TestEvents(t *testing.T) {
+	server := NewServer(storage.NewStorage())
+	ts := httptest.NewServer(server.router)
+	defer ts.Close()
+
+	chat := createTestChat(t, server, "Streamed Chat")
+	url := ts.URL + "/api/chats/" + chat.ID + "/events"
+
+	t.Run("LiveMessages", func(t *testing.T) {
+		events := openTestEventStream(t, url, "")
+		sent := sendTestMessage(t, server, chat.ID, "alice", "Hello stream")
+
+		event, msg := nextTestEvent(t, events)
+		if event.event != models.EventMessage {
+			t.Errorf("Expected message event, got %s", event.event)
+		}
+		if event.id != "1" {
+			t.Errorf("Expected event ID 1, got %s", event.id)
+		}
+		if msg.ID != sent.ID {
+			t.Errorf("Expected message %s, got %s", sent.ID, msg.ID)
+		}
+	})
+
+	t.Run("ResumeWithLastEventID", func(t *testing.T) {
+		sendTestMessage(t, server, chat.ID, "alice", "Missed while away")
+		sendTestMessage(t, server, chat.ID, "alice", "Also missed")
+
+		events := openTestEventStream(t, url, "1")
+		for _, want := range []string{"2", "3"} {
+			event, _ := nextTestEvent(t, events)
+			if event.id != want {
+				t.Errorf("Expected event ID %s, got %s", want, event.id)
+			}
+		}
+
+		sendTestMessage(t, server, chat.ID, "alice", "Live again")
+		if event, _ := nextTestEvent(t, events); event.id != "4" {
+			t.Errorf("Expected event ID 4, got %s", event.id)
+		}
+	})
+
+	t.Run("EditsAndDeletes", func(t *testing.T) {
+		sent := sendTestMessage(t, server, chat.ID, "alice", "Typo")
+		events := openTestEventStream(t, url, "")
+		header := testAuthHeader(t, server, "alice")
+		path := "/api/chats/" + chat.ID + "/messages/" + sent.ID
+
+		if rr := serveTestJSON(server, "PATCH", path, models.EditMessageRequest{Content: "Fixed"}, header); rr.Code != http.StatusOK {
+			t.Fatalf("Failed to edit message: status %d", rr.Code)
+		}
+		event, msg := nextTestEvent(t, events)
+		if event.event != models.EventEdit || msg.Content != "Fixed" {
+			t.Errorf("Expected edit event, got %s %+v", event.event, msg)
+		}
+		// Resuming from an edit must not replay newer messages
+		if event.id != "" {
+			t.Errorf("Expected no event ID for edits, got %s", event.id)
+		}
+
+		if rr := serveTestRequest(server, "DELETE", path, header); rr.Code != http.StatusOK {
+			t.Fatalf("Failed to delete message: status %d", rr.Code)
+		}
+		if event, msg := nextTestEvent(t, events); event.event != models.EventDelete || msg.DeletedAt == nil {
+			t.Errorf("Expected delete event, got %s %+v", event.event, msg)
+		}
+	})
+
+	t.Run("OutlivesWriteTimeout", func(t *testing.T) {
+		slow := httptest.NewUnstartedServer(server.router)
+		slow.Config.WriteTimeout = 100 * time.Millisecond
+		slow.Start()
+		// Registered before the stream so that the stream is closed first
+		t.Cleanup(slow.Close)
+
+		events := openTestEventStream(t, slow.URL+"/api/chats/"+chat.ID+"/events", "")
+		time.Sleep(300 * time.Millisecond)
+		sent := sendTestMessage(t, server, chat.ID, "alice", "Still here")
+
+		if _, msg := nextTestEvent(t, events); msg.ID != sent.ID {
+			t.Errorf("Expected message %s, got %s", sent.ID, msg.ID)
+		}
+	})
+
+	t.Run("InvalidLastEventID", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/api/chats/"+chat.ID+"/events", nil)
+		req.Header.Set("Last-Event-ID", "bogus")
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("NonExistentChat", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/api/chats/nonexistent/events", nil)
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusNotFound {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+		}
+	})
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+
+	"chat-app/internal/models"
+	"chat-app/internal/storage"
+)
+
+// parseSeq parses a message sequence number from a request
+func parseSeq(raw string) (int64, error) {
+	seq, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || seq < 0 {
+		return 0, errors.New("must be a non-negative integer")
+	}
+	return seq, nil
+}
+
+// streamBacklog sends every message of a chat after sinceSeq, oldest first,
+// and returns the sequence number of the last one sent. It stops early and
+// reports false if send fails or the chat disappears. Streaming handlers
+// subscribe before calling it and skip live messages at or below the
+// returned sequence number, so nothing is lost or repeated in between.
+func (s *Server) streamBacklog(chatID string, sinceSeq int64, send func(*models.Message) bool) (int64, bool) {
+	lastSeq := sinceSeq
+	for {
+		page, err := s.storage.GetMessagePage(chatID, storage.PageQuery{AfterSeq: lastSeq, HasAfterSeq: true, Limit: maxPageLimit})
+		if err != nil {
+			return lastSeq, false
+		}
+		for _, msg := range page.Messages {
+			if !send(msg) {
+				return lastSeq, false
+			}
+			lastSeq = msg.Seq
+		}
+		if !page.HasNewer {
+			return lastSeq, true
+		}
+	}
+}
+
+// isStale reports whether a live event repeats a message already sent from
+// the backlog. Edits, deletions and expiries are always new, whatever
+// messages they refer to.
+func isStale(event *models.Event, lastSeq int64) bool {
+	return event.Type == models.EventMessage && event.Message.Seq <= lastSeq
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"chat-app/internal/storage"
+)
+
+// handleGetThread lists the thread a message belongs to: the message that
+// started it, unless it has expired, followed by every reply, oldest first
+func (s *Server) handleGetThread(w http.ResponseWriter, r *http.Request) {
+	msg, ok := s.chatMessage(w, r)
+	if !ok {
+		return
+	}
+	thread, err := s.storage.GetThread(msg.ID)
+	if errors.Is(err, storage.ErrMessageNotFound) {
+		writeError(w, http.StatusNotFound, "Message not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to get thread")
+		return
+	}
+	writeJSON(w, http.StatusOK, thread)
+}
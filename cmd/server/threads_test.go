@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"chat-app/internal/models"
+	"chat-app/internal/storage"
+)
+
+func TestThreads(t *testing.T) {
+	server := NewServer(storage.NewStorage())
+	alice := testAuthHeader(t, server, "alice")
+
+	chat := createTestChat(t, server, "Threaded Chat")
+	other := createTestChat(t, server, "Other Chat")
+	root := sendTestMessage(t, server, chat.ID, "alice", "Lunch?")
+	base := "/api/chats/" + chat.ID
+
+	reply := func(req models.SendMessageRequest, want int) models.Message {
+		t.Helper()
+		rr := postTestJSON(server, base+"/messages", req, alice)
+		if status := rr.Code; status != want {
+			t.Fatalf("handler returned wrong status code: got %v want %v: %s", status, want, rr.Body.String())
+		}
+		var msg models.Message
+		_ = json.NewDecoder(rr.Body).Decode(&msg)
+		return msg
+	}
+
+	first := reply(models.SendMessageRequest{Content: "Sure", ReplyTo: root.ID}, http.StatusCreated)
+	second := reply(models.SendMessageRequest{Content: "Where?", ThreadID: root.ID}, http.StatusCreated)
+	nested := reply(models.SendMessageRequest{Content: "The usual", ReplyTo: first.ID, ThreadID: root.ID}, http.StatusCreated)
+
+	t.Run("Reply", func(t *testing.T) {
+		if first.ReplyTo != root.ID || first.ThreadID != root.ID {
+			t.Errorf("Expected a reply to the root, got %+v", first)
+		}
+		if second.ReplyTo != root.ID || second.ThreadID != root.ID {
+			t.Errorf("Expected thread_id alone to reply to the root, got %+v", second)
+		}
+		if nested.ReplyTo != first.ID || nested.ThreadID != root.ID {
+			t.Errorf("Expected a nested reply in the root's thread, got %+v", nested)
+		}
+	})
+
+	t.Run("ListThread", func(t *testing.T) {
+		rr := serveTestRequest(server, "GET", base+"/messages/"+nested.ID+"/thread", nil)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		var thread []*models.Message
+		if err := json.NewDecoder(rr.Body).Decode(&thread); err != nil {
+			t.Fatalf("Failed to decode thread: %v", err)
+		}
+		if len(thread) != 4 || thread[0].ID != root.ID || thread[3].ID != nested.ID {
+			t.Fatalf("Expected the root and three replies, got %+v", thread)
+		}
+		if thread[0].ReplyCount != 3 {
+			t.Errorf("Expected a reply count of 3 on the root, got %d", thread[0].ReplyCount)
+		}
+
+		rr = serveTestRequest(server, "GET", "/api/chats/"+other.ID+"/messages/"+root.ID+"/thread", nil)
+		if status := rr.Code; status != http.StatusNotFound {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+		}
+	})
+
+	t.Run("Reply_Errors", func(t *testing.T) {
+		elsewhere := sendTestMessage(t, server, other.ID, "alice", "Elsewhere")
+		reply(models.SendMessageRequest{Content: "Hi", ReplyTo: "nonexistent"}, http.StatusBadRequest)
+		reply(models.SendMessageRequest{Content: "Hi", ReplyTo: elsewhere.ID}, http.StatusBadRequest)
+
+		unrelated := sendTestMessage(t, server, chat.ID, "alice", "Unrelated")
+		reply(models.SendMessageRequest{Content: "Hi", ReplyTo: unrelated.ID, ThreadID: root.ID}, http.StatusBadRequest)
+
+		if rr := serveTestRequest(server, "DELETE", base+"/messages/"+unrelated.ID, alice); rr.Code != http.StatusOK {
+			t.Fatalf("Failed to delete message: status %d", rr.Code)
+		}
+		reply(models.SendMessageRequest{Content: "Hi", ReplyTo: unrelated.ID}, http.StatusConflict)
+	})
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"chat-app/internal/models"
+	"chat-app/internal/storage"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// wsWriteWait is the time allowed to write a frame to the peer
+	wsWriteWait = 10 * time.Second
+	// wsPongWait is the time allowed between pongs from the peer
+	wsPongWait = 60 * time.Second
+	// wsPingPeriod is how often pings are sent; it must be below wsPongWait
+	wsPingPeriod = wsPongWait * 9 / 10
+	// wsMaxMessageSize is the largest frame accepted from the peer
+	wsMaxMessageSize = 64 << 10
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:   1024,
+	WriteBufferSize:  1024,
+	HandshakeTimeout: 10 * time.Second,
+}
+
+// handleWebSocket streams a chat's messages over a WebSocket and accepts
+// SendMessageRequest frames from an authenticated peer. With since_seq set,
+// messages after that sequence number are sent before live ones.
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	chatID := vars["chatID"]
+
+	sinceSeq := int64(-1)
+	if raw := r.URL.Query().Get("since_seq"); raw != "" {
+		seq, err := parseSeq(raw)
+		if err != nil {
+			invalidField(w, "since_seq", "since_seq "+err.Error())
+			return
+		}
+		sinceSeq = seq
+	}
+
+	// Subscribe before completing the handshake and reading the backlog so
+	// that nothing posted in between is missed, and before checking the chat
+	// so that a deletion ends the stream
+	sub := s.storage.Subscribe(chatID)
+	defer sub.Close()
+
+	if _, exists := s.storage.GetChat(chatID); !exists {
+		writeError(w, http.StatusNotFound, "Chat not found")
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied to the client
+		return
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	defer s.trackStream(streamWebSocket)()
+
+	replies := make(chan *models.Event, 1)
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	defer close(quit)
+	go func() {
+		defer close(done)
+		s.wsReadLoop(conn, chatID, identityFrom(r), replies, quit)
+	}()
+
+	s.wsWriteLoop(conn, chatID, sinceSeq, sub, replies, done)
+}
+
+// wsReadLoop stores the messages sent by the peer until the connection
+// fails. Problems with individual frames are reported on replies. Anonymous
+// peers and API keys without the write scope may only read.
+func (s *Server) wsReadLoop(conn *websocket.Conn, chatID string, user *identity, replies chan<- *models.Event, quit <-chan struct{}) {
+	conn.SetReadLimit(wsMaxMessageSize)
+	_ = conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	reply := func(text string) bool {
+		select {
+		case replies <- &models.Event{Type: models.EventError, Error: text}:
+			return true
+		case <-quit:
+			return false
+		}
+	}
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		if user == nil {
+			if !reply("Authentication required") {
+				return
+			}
+			continue
+		}
+		if !user.can(models.ScopeWrite, chatID) {
+			if !reply("API key does not allow posting") {
+				return
+			}
+			continue
+		}
+		var req models.SendMessageRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			if !reply("Invalid request body") {
+				return
+			}
+			continue
+		}
+		if req.Content == "" {
+			if !reply("Content is required") {
+				return
+			}
+			continue
+		}
+		if _, err := s.postMessage(chatID, user, req); err != nil {
+			if !reply(messageErrorText(err)) {
+				return
+			}
+		}
+	}
+}
+
+// wsWriteLoop sends the backlog and then live events to the peer until the
+// connection fails, the read loop ends, the subscriber is dropped or the chat
+// is deleted
+func (s *Server) wsWriteLoop(conn *websocket.Conn, chatID string, sinceSeq int64, sub *storage.Subscription, replies <-chan *models.Event, done <-chan struct{}) {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer ticker.Stop()
+
+	write := func(event *models.Event) bool {
+		_ = conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		return conn.WriteJSON(event) == nil
+	}
+
+	lastSeq := sinceSeq
+	if sinceSeq >= 0 {
+		var ok bool
+		lastSeq, ok = s.streamBacklog(chatID, sinceSeq, func(msg *models.Message) bool {
+			return write(&models.Event{Type: models.EventMessage, Message: msg})
+		})
+		if !ok {
+			return
+		}
+	}
+
+	for {
+		select {
+		case event, ok := <-sub.Events():
+			if !ok {
+				if sub.Overflowed() {
+					_ = conn.WriteControl(websocket.CloseMessage,
+						websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "subscriber too slow"),
+						time.Now().Add(wsWriteWait))
+				}
+				return
+			}
+			// Skip messages already sent as part of the backlog
+			if isStale(event, lastSeq) {
+				continue
+			}
+			if !write(event) {
+				return
+			}
+			if event.Type == models.EventChatDeleted {
+				_ = conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseNormalClosure, "chat deleted"),
+					time.Now().Add(wsWriteWait))
+				return
+			}
+		case event := <-replies:
+			if !write(event) {
+				return
+			}
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				return
+			}
+		case <-done:
+			return
+		case <-s.quit:
+			_ = conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"),
+				time.Now().Add(wsWriteWait))
+			return
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"chat-app/internal/models"
+	"chat-app/internal/storage"
+
+	"github.com/gorilla/websocket"
+)
+
+func dialTestWebSocket(t *testing.T, ts *httptest.Server, path string, header http.Header) *websocket.Conn {
+	t.Helper()
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+path, header)
+	if err != nil {
+		t.Fatalf("Failed to dial websocket: %v", err)
+	}
+	if err := resp.Body.Close(); err != nil {
+		t.Errorf("Failed to close handshake body: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return conn
+}
+
+func readTestEvent(t *testing.T, conn *websocket.Conn) models.Event {
+	t.Helper()
+	if err := conn.SetReadDeadline(time.Now().Add(2 * time.Second)); err != nil {
+		t.Fatalf("Failed to set read deadline: %v", err)
+	}
+	var event models.Event
+	if err := conn.ReadJSON(&event); err != nil {
+		t.Fatalf("Failed to read event: %v", err)
+	}
+	return event
+}
+
+func TestWebSocket(t *testing.T) {
+	server := NewServer(storage.NewStorage())
+	ts := httptest.NewServer(server.router)
+	defer ts.Close()
+
+	chat := createTestChat(t, server, "Live Chat")
+
+	t.Run("ReceivesPostedMessages", func(t *testing.T) {
+		conn := dialTestWebSocket(t, ts, "/api/chats/"+chat.ID+"/ws", nil)
+
+		sent := sendTestMessage(t, server, chat.ID, "alice", "Hello over HTTP")
+
+		event := readTestEvent(t, conn)
+		if event.Type != models.EventMessage {
+			t.Fatalf("Expected message event, got %s", event.Type)
+		}
+		if event.Message.ID != sent.ID {
+			t.Errorf("Expected message %s, got %s", sent.ID, event.Message.ID)
+		}
+	})
+
+	t.Run("SendsMessages", func(t *testing.T) {
+		sender := dialTestWebSocket(t, ts, "/api/chats/"+chat.ID+"/ws", testAuthHeader(t, server, "bob"))
+		joinTestChat(t, server, chat.ID, "bob")
+		listener := dialTestWebSocket(t, ts, "/api/chats/"+chat.ID+"/ws", nil)
+
+		if err := sender.WriteJSON(models.SendMessageRequest{Content: "Hello over WebSocket"}); err != nil {
+			t.Fatalf("Failed to send message: %v", err)
+		}
+
+		for _, conn := range []*websocket.Conn{sender, listener} {
+			event := readTestEvent(t, conn)
+			if event.Message == nil || event.Message.Content != "Hello over WebSocket" {
+				t.Errorf("Expected the sent message to be delivered, got %+v", event)
+			} else if event.Message.Username != "bob" {
+				t.Errorf("Expected message from bob, got %s", event.Message.Username)
+			}
+		}
+
+		messages, _ := server.storage.GetMessages(chat.ID)
+		if last := messages[len(messages)-1]; last.Content != "Hello over WebSocket" {
+			t.Errorf("Expected message to be stored, last is '%s'", last.Content)
+		}
+	})
+
+	t.Run("InvalidFrame", func(t *testing.T) {
+		conn := dialTestWebSocket(t, ts, "/api/chats/"+chat.ID+"/ws", testAuthHeader(t, server, "bob"))
+
+		if err := conn.WriteJSON(models.SendMessageRequest{}); err != nil {
+			t.Fatalf("Failed to send message: %v", err)
+		}
+		event := readTestEvent(t, conn)
+		if event.Type != models.EventError {
+			t.Errorf("Expected error event, got %s", event.Type)
+		}
+	})
+
+	t.Run("AnonymousCannotSend", func(t *testing.T) {
+		conn := dialTestWebSocket(t, ts, "/api/chats/"+chat.ID+"/ws", nil)
+
+		if err := conn.WriteJSON(models.SendMessageRequest{Content: "Who am I?"}); err != nil {
+			t.Fatalf("Failed to send message: %v", err)
+		}
+		event := readTestEvent(t, conn)
+		if event.Type != models.EventError || event.Error != "Authentication required" {
+			t.Errorf("Expected authentication error, got %+v", event)
+		}
+	})
+
+	t.Run("ReadOnlyMemberCannotSend", func(t *testing.T) {
+		conn := dialTestWebSocket(t, ts, "/api/chats/"+chat.ID+"/ws", testAuthHeader(t, server, "lurker"))
+		if _, err := server.storage.SetMember(chat.ID, "lurker", models.RoleReadOnly); err != nil {
+			t.Fatalf("Failed to add member: %v", err)
+		}
+
+		if err := conn.WriteJSON(models.SendMessageRequest{Content: "Can I speak?"}); err != nil {
+			t.Fatalf("Failed to send message: %v", err)
+		}
+		event := readTestEvent(t, conn)
+		if event.Type != models.EventError || event.Error != "Your role does not allow posting in this chat" {
+			t.Errorf("Expected permission error, got %+v", event)
+		}
+	})
+
+	t.Run("ReadOnlyKeyCannotSend", func(t *testing.T) {
+		_, token := createTestAPIKey(t, server, "alice", models.CreateAPIKeyRequest{
+			BotName: "watch-bot",
+			Scopes:  []string{models.ScopeRead},
+		})
+		conn := dialTestWebSocket(t, ts, "/api/chats/"+chat.ID+"/ws", bearer(token))
+
+		if err := conn.WriteJSON(models.SendMessageRequest{Content: "Let me in"}); err != nil {
+			t.Fatalf("Failed to send message: %v", err)
+		}
+		event := readTestEvent(t, conn)
+		if event.Type != models.EventError || event.Error != "API key does not allow posting" {
+			t.Errorf("Expected permission error, got %+v", event)
+		}
+	})
+
+	t.Run("Backlog", func(t *testing.T) {
+		backlog := createTestChat(t, server, "Backlog Chat")
+		for _, content := range []string{"one", "two", "three"} {
+			sendTestMessage(t, server, backlog.ID, "alice", content)
+		}
+
+		conn := dialTestWebSocket(t, ts, "/api/chats/"+backlog.ID+"/ws?since_seq=1", nil)
+		for _, want := range []string{"two", "three"} {
+			event := readTestEvent(t, conn)
+			if event.Message == nil || event.Message.Content != want {
+				t.Errorf("Expected backlog message '%s', got %+v", want, event)
+			}
+		}
+	})
+
+	t.Run("BacklogFromStart", func(t *testing.T) {
+		// More than a page of history is replayed from the very first
+		// message
+		history := createTestChat(t, server, "Long History")
+		for i := 1; i <= maxPageLimit+1; i++ {
+			if _, err := server.storage.AddMessage(history.ID, "alice", fmt.Sprintf("message %d", i)); err != nil {
+				t.Fatalf("Failed to add message: %v", err)
+			}
+		}
+
+		conn := dialTestWebSocket(t, ts, "/api/chats/"+history.ID+"/ws?since_seq=0", nil)
+		for seq := int64(1); seq <= maxPageLimit+1; seq++ {
+			event := readTestEvent(t, conn)
+			if event.Message == nil || event.Message.Seq != seq {
+				t.Fatalf("Expected backlog message %d, got %+v", seq, event)
+			}
+		}
+	})
+
+	t.Run("NonExistentChat", func(t *testing.T) {
+		_, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/api/chats/nonexistent/ws", nil)
+		if err == nil {
+			t.Fatal("Expected dial to fail")
+		}
+		if resp == nil || resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected 404 response, got %v", resp)
+		}
+	})
+}
@@ -5,4 +5,9 @@ go 1.21
 require (
 	github.com/google/uuid v1.5.0
 	github.com/gorilla/mux v1.8.1
+	github.com/gorilla/websocket v1.5.3
+	golang.org/x/crypto v0.33.0
+	golang.org/x/term v0.29.0
 )
+
+require golang.org/x/sys v0.30.0 // indirect
@@ -0,0 +1,122 @@
+// Package auth implements password hashing, credential rules and session
+// tokens for user accounts.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Credential limits
+const (
+	MinUsernameLength = 3
+	MaxUsernameLength = 32
+	MinPasswordLength = 8
+	// MaxPasswordLength is the most bcrypt can hash; longer passwords would
+	// be silently truncated
+	MaxPasswordLength = 72
+)
+
+// tokenBytes is the number of random bytes in a token
+const tokenBytes = 32
+
+// APIKeyPrefix starts every API key token, telling them apart from session
+// tokens
+const APIKeyPrefix = "key_"
+
+// ErrInvalidUsername is returned for usernames that break the naming rules
+var ErrInvalidUsername = fmt.Errorf("username must be %d to %d letters, digits, '.', '-' or '_'",
+	MinUsernameLength, MaxUsernameLength)
+
+// ErrInvalidPassword is returned for passwords of the wrong length
+var ErrInvalidPassword = fmt.Errorf("password must be %d to %d bytes", MinPasswordLength, MaxPasswordLength)
+
+// ValidateUsername checks that a username is acceptable for a new account
+func ValidateUsername(username string) error {
+	if len(username) < MinUsernameLength || len(username) > MaxUsernameLength {
+		return ErrInvalidUsername
+	}
+	for _, r := range username {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '.', r == '-', r == '_':
+		default:
+			return ErrInvalidUsername
+		}
+	}
+	return nil
+}
+
+// ValidatePassword checks that a password is acceptable for a new account
+func ValidatePassword(password string) error {
+	if len(password) < MinPasswordLength || len(password) > MaxPasswordLength {
+		return ErrInvalidPassword
+	}
+	return nil
+}
+
+// HashPassword returns a bcrypt hash of password
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+var (
+	dummyOnce sync.Once
+	dummyHash []byte
+)
+
+// CheckPassword reports whether password matches hash. An empty hash never
+// matches, but is still compared against a dummy hash so that logging in
+// as an unknown user takes as long as using a wrong password.
+func CheckPassword(hash, password string) bool {
+	if hash == "" {
+		dummyOnce.Do(func() {
+			dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+		})
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return false
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	return err == nil
+}
+
+// NewToken returns a random bearer token
+func NewToken() (string, error) {
+	buf := make([]byte, tokenBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// NewAPIKey returns a random API key token
+func NewAPIKey() (string, error) {
+	token, err := NewToken()
+	if err != nil {
+		return "", err
+	}
+	return APIKeyPrefix + token, nil
+}
+
+// IsAPIKey reports whether token has the form of an API key
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
+
+// HashToken returns the form of a token that is stored, so that a leaked
+// data directory does not reveal usable tokens
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateUsername(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		valid    bool
+	}{
+		{"Simple", "alice", true},
+		{"Punctuation", "build-bot_2.0", true},
+		{"TooShort", "al", false},
+		{"TooLong", strings.Repeat("a", MaxUsernameLength+1), false},
+		{"Space", "alice smith", false},
+		{"NonASCII", "alicé", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateUsername(tt.username)
+			if (err == nil) != tt.valid {
+				t.Errorf("Expected valid=%v for %q, got error %v", tt.valid, tt.username, err)
+			}
+		})
+	}
+}
+
+func TestValidatePassword(t *testing.T) {
+	if err := ValidatePassword("long enough"); err != nil {
+		t.Errorf("Expected password to be valid, got %v", err)
+	}
+	if err := ValidatePassword("short"); err == nil {
+		t.Error("Expected short password to be rejected")
+	}
+	if err := ValidatePassword(strings.Repeat("x", MaxPasswordLength+1)); err == nil {
+		t.Error("Expected overlong password to be rejected")
+	}
+}
+
+func TestPasswordHashing(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	if hash == "correct horse" {
+		t.Fatal("Expected hash to differ from the password")
+	}
+
+	if !CheckPassword(hash, "correct horse") {
+		t.Error("Expected correct password to match")
+	}
+	if CheckPassword(hash, "wrong horse") {
+		t.Error("Expected wrong password not to match")
+	}
+	if CheckPassword("", "correct horse") {
+		t.Error("Expected empty hash never to match")
+	}
+}
+
+func TestTokens(t *testing.T) {
+	a, err := NewToken()
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+	b, err := NewToken()
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+	if a == b {
+		t.Error("Expected tokens to be unique")
+	}
+
+	if HashToken(a) != HashToken(a) {
+		t.Error("Expected token hash to be deterministic")
+	}
+	if HashToken(a) == HashToken(b) || HashToken(a) == a {
+		t.Error("Expected distinct hashes that differ from the token")
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	key, err := NewAPIKey()
+	if err != nil {
+		t.Fatalf("Failed to create API key: %v", err)
+	}
+	if !IsAPIKey(key) {
+		t.Errorf("Expected %q to be recognised as an API key", key)
+	}
+
+	token, err := NewToken()
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+	if IsAPIKey(token) {
+		t.Errorf("Expected session token %q not to be an API key", token)
+	}
+}
@@ -0,0 +1,372 @@
+// Package metrics records counters, gauges and histograms and writes them in
+// the Prometheus text exposition format, so that the server can be scraped
+// without an external collector.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultBuckets are the upper bounds of histogram buckets for latencies
+// in seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Collector is a metric family that can be registered
+type Collector interface {
+	// Name returns the metric family's name
+	Name() string
+	// write appends the family in the text format to buf
+	write(buf *bytes.Buffer)
+}
+
+// Registry holds the metric families to expose
+type Registry struct {
+	mu         sync.Mutex
+	collectors []Collector
+	names      map[string]bool
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+// MustRegister adds collectors to the registry. It panics if a name is
+// registered twice.
+func (r *Registry) MustRegister(collectors ...Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range collectors {
+		if r.names[c.Name()] {
+			panic("metrics: duplicate metric " + c.Name())
+		}
+		r.names[c.Name()] = true
+		r.collectors = append(r.collectors, c)
+	}
+}
+
+// WriteTo writes every registered family to w in the text format, in the
+// order they were registered
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	collectors := append([]Collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	var buf bytes.Buffer
+	for _, c := range collectors {
+		c.write(&buf)
+	}
+	return buf.WriteTo(w)
+}
+
+// family holds what every metric family has in common
+type family struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (f *family) Name() string { return f.name }
+
+func (f *family) writeHeader(buf *bytes.Buffer) {
+	fmt.Fprintf(buf, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(buf, "# TYPE %s %s\n", f.name, f.kind)
+}
+
+// vec holds the series of a family, one per combination of label values
+type vec[T any] struct {
+	family
+	mu     sync.Mutex
+	series map[string]*entry[T]
+	create func() *T
+}
+
+type entry[T any] struct {
+	values []string
+	metric *T
+}
+
+func newVec[T any](name, help, kind string, labels []string, create func() *T) vec[T] {
+	return vec[T]{
+		family: family{name: name, help: help, kind: kind, labels: labels},
+		series: make(map[string]*entry[T]),
+		create: create,
+	}
+}
+
+// with returns the series with the given label values, creating it if
+// needed. It panics if the number of values does not match the labels.
+func (v *vec[T]) with(values []string) *T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	e, ok := v.series[key]
+	if !ok {
+		e = &entry[T]{values: append([]string(nil), values...), metric: v.create()}
+		v.series[key] = e
+	}
+	return e.metric
+}
+
+// entries returns the series sorted by their label values
+func (v *vec[T]) entries() []*entry[T] {
+	v.mu.Lock()
+	entries := make([]*entry[T], 0, len(v.series))
+	for _, e := range v.series {
+		entries = append(entries, e)
+	}
+	v.mu.Unlock()
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i].values, entries[j].values
+		for k := range a {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return false
+	})
+	return entries
+}
+
+// value is a float64 that can be changed concurrently
+type value struct {
+	bits atomic.Uint64
+}
+
+func (v *value) add(delta float64) {
+	for {
+		old := v.bits.Load()
+		if v.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+func (v *value) load() float64 {
+	return math.Float64frombits(v.bits.Load())
+}
+
+// Counter is a value that only goes up
+type Counter struct {
+	v value
+}
+
+// Inc adds one to the counter
+func (c *Counter) Inc() { c.v.add(1) }
+
+// Add adds delta, which must not be negative, to the counter
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	c.v.add(delta)
+}
+
+// Value returns the counter's current value
+func (c *Counter) Value() float64 { return c.v.load() }
+
+// CounterVec is a family of counters told apart by labels
+type CounterVec struct {
+	vec[Counter]
+}
+
+// NewCounterVec creates a counter family with the given label names
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{newVec(name, help, "counter", labels, func() *Counter { return &Counter{} })}
+}
+
+// With returns the counter with the given label values
+func (v *CounterVec) With(values ...string) *Counter { return v.with(values) }
+
+func (v *CounterVec) write(buf *bytes.Buffer) {
+	v.writeHeader(buf)
+	for _, e := range v.entries() {
+		writeSample(buf, v.name, v.labels, e.values, "", "", e.metric.Value())
+	}
+}
+
+// Gauge is a value that goes up and down
+type Gauge struct {
+	v value
+}
+
+// Inc adds one to the gauge
+func (g *Gauge) Inc() { g.v.add(1) }
+
+// Dec subtracts one from the gauge
+func (g *Gauge) Dec() { g.v.add(-1) }
+
+// Add adds delta to the gauge
+func (g *Gauge) Add(delta float64) { g.v.add(delta) }
+
+// Value returns the gauge's current value
+func (g *Gauge) Value() float64 { return g.v.load() }
+
+// GaugeVec is a family of gauges told apart by labels
+type GaugeVec struct {
+	vec[Gauge]
+}
+
+// NewGaugeVec creates a gauge family with the given label names
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{newVec(name, help, "gauge", labels, func() *Gauge { return &Gauge{} })}
+}
+
+// With returns the gauge with the given label values
+func (v *GaugeVec) With(values ...string) *Gauge { return v.with(values) }
+
+func (v *GaugeVec) write(buf *bytes.Buffer) {
+	v.writeHeader(buf)
+	for _, e := range v.entries() {
+		writeSample(buf, v.name, v.labels, e.values, "", "", e.metric.Value())
+	}
+}
+
+// GaugeFunc is a gauge whose value is read when metrics are written
+type GaugeFunc struct {
+	family
+	fn func() float64
+}
+
+// NewGaugeFunc creates a gauge that reports the result of fn
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	return &GaugeFunc{family: family{name: name, help: help, kind: "gauge"}, fn: fn}
+}
+
+func (g *GaugeFunc) write(buf *bytes.Buffer) {
+	g.writeHeader(buf)
+	writeSample(buf, g.name, nil, nil, "", "", g.fn())
+}
+
+// Histogram counts observations in buckets
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64 // per bucket, not cumulative; the last is +Inf
+	sum     float64
+	count   uint64
+}
+
+// Observe records one observation
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.mu.Lock()
+	h.counts[i]++
+	h.sum += v
+	h.count++
+	h.mu.Unlock()
+}
+
+// ObserveDuration records d in seconds
+func (h *Histogram) ObserveDuration(d time.Duration) {
+	h.Observe(d.Seconds())
+}
+
+// Count returns the number of observations
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+// HistogramVec is a family of histograms told apart by labels
+type HistogramVec struct {
+	vec[Histogram]
+	buckets []float64
+}
+
+// NewHistogramVec creates a histogram family with the given bucket upper
+// bounds, in increasing order, and label names
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	buckets = append([]float64(nil), buckets...)
+	if !sort.Float64sAreSorted(buckets) {
+		panic("metrics: buckets of " + name + " are not sorted")
+	}
+	return &HistogramVec{
+		vec: newVec(name, help, "histogram", labels, func() *Histogram {
+			return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets)+1)}
+		}),
+		buckets: buckets,
+	}
+}
+
+// With returns the histogram with the given label values
+func (v *HistogramVec) With(values ...string) *Histogram { return v.with(values) }
+
+func (v *HistogramVec) write(buf *bytes.Buffer) {
+	v.writeHeader(buf)
+	for _, e := range v.entries() {
+		h := e.metric
+		h.mu.Lock()
+		counts := append([]uint64(nil), h.counts...)
+		sum, count := h.sum, h.count
+		h.mu.Unlock()
+
+		var cumulative uint64
+		for i, bound := range v.buckets {
+			cumulative += counts[i]
+			writeSample(buf, v.name+"_bucket", v.labels, e.values, "le", formatFloat(bound), float64(cumulative))
+		}
+		writeSample(buf, v.name+"_bucket", v.labels, e.values, "le", "+Inf", float64(count))
+		writeSample(buf, v.name+"_sum", v.labels, e.values, "", "", sum)
+		writeSample(buf, v.name+"_count", v.labels, e.values, "", "", float64(count))
+	}
+}
+
+// writeSample writes one sample line. extra is an additional label, such as
+// a histogram bucket's le, if not empty.
+func writeSample(buf *bytes.Buffer, name string, labels, values []string, extra, extraValue string, v float64) {
+	buf.WriteString(name)
+	if len(labels) > 0 || extra != "" {
+		buf.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			fmt.Fprintf(buf, "%s=\"%s\"", label, escapeLabel(values[i]))
+		}
+		if extra != "" {
+			if len(labels) > 0 {
+				buf.WriteByte(',')
+			}
+			fmt.Fprintf(buf, "%s=\"%s\"", extra, extraValue)
+		}
+		buf.WriteByte('}')
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(formatFloat(v))
+	buf.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
@@ -0,0 +1,141 @@
+package metrics
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func scrape(t *testing.T, reg *Registry) string {
+	t.Helper()
+	var out strings.Builder
+	if _, err := reg.WriteTo(&out); err != nil {
+		t.Fatalf("Failed to write metrics: %v", err)
+	}
+	return out.String()
+}
+
+func TestMetrics(t *testing.T) {
+	t.Run("Counter", func(t *testing.T) {
+		reg := NewRegistry()
+		requests := NewCounterVec("requests_total", "Requests served.", "route", "status")
+		reg.MustRegister(requests)
+
+		requests.With("/b", "200").Inc()
+		requests.With("/a", "200").Add(2)
+		requests.With("/a", "200").Inc()
+
+		want := `# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{route="/a",status="200"} 3
+requests_total{route="/b",status="200"} 1
+`
+		if got := scrape(t, reg); got != want {
+			t.Errorf("Unexpected output:\n%s\nwant:\n%s", got, want)
+		}
+	})
+
+	t.Run("Gauge", func(t *testing.T) {
+		reg := NewRegistry()
+		open := NewGaugeVec("open", "Open things.", "kind")
+		size := NewGaugeFunc("size", "Size\nof things.", func() float64 { return 1.5 })
+		reg.MustRegister(open, size)
+
+		open.With("a").Inc()
+		open.With("a").Inc()
+		open.With("a").Dec()
+		open.With("b")
+
+		want := `# HELP open Open things.
+# TYPE open gauge
+open{kind="a"} 1
+open{kind="b"} 0
+# HELP size Size\nof things.
+# TYPE size gauge
+size 1.5
+`
+		if got := scrape(t, reg); got != want {
+			t.Errorf("Unexpected output:\n%s\nwant:\n%s", got, want)
+		}
+	})
+
+	t.Run("Histogram", func(t *testing.T) {
+		reg := NewRegistry()
+		latency := NewHistogramVec("latency_seconds", "Latency.", []float64{0.1, 1}, "op")
+		reg.MustRegister(latency)
+
+		h := latency.With("get")
+		h.Observe(0.05)
+		h.Observe(0.1)
+		h.Observe(0.5)
+		h.Observe(3)
+
+		want := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{op="get",le="0.1"} 2
+latency_seconds_bucket{op="get",le="1"} 3
+latency_seconds_bucket{op="get",le="+Inf"} 4
+latency_seconds_sum{op="get"} 3.65
+latency_seconds_count{op="get"} 4
+`
+		if got := scrape(t, reg); got != want {
+			t.Errorf("Unexpected output:\n%s\nwant:\n%s", got, want)
+		}
+	})
+
+	t.Run("LabelEscaping", func(t *testing.T) {
+		reg := NewRegistry()
+		paths := NewCounterVec("paths_total", "Paths.", "path")
+		reg.MustRegister(paths)
+		paths.With("a\"b\\c\nd").Inc()
+
+		if got := scrape(t, reg); !strings.Contains(got, `paths_total{path="a\"b\\c\nd"} 1`) {
+			t.Errorf("Expected the label value to be escaped, got:\n%s", got)
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		reg := NewRegistry()
+		hits := NewCounterVec("hits_total", "Hits.", "shard")
+		latency := NewHistogramVec("hit_seconds", "Hit latency.", DefaultBuckets)
+		reg.MustRegister(hits, latency)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 1000; j++ {
+					hits.With("a").Inc()
+					latency.With().Observe(0.01)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if got := hits.With("a").Value(); got != 8000 {
+			t.Errorf("Expected 8000 hits, got %v", got)
+		}
+		if got := latency.With().Count(); got != 8000 {
+			t.Errorf("Expected 8000 observations, got %v", got)
+		}
+	})
+
+	t.Run("Misuse", func(t *testing.T) {
+		mustPanic := func(name string, f func()) {
+			t.Helper()
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected %s to panic", name)
+				}
+			}()
+			f()
+		}
+		reg := NewRegistry()
+		reg.MustRegister(NewCounterVec("dup_total", "Duplicate."))
+		mustPanic("a duplicate name", func() { reg.MustRegister(NewGaugeVec("dup_total", "Duplicate.")) })
+		mustPanic("a wrong number of label values", func() { NewCounterVec("c_total", "C.", "a").With("x", "y") })
+		mustPanic("a decreasing counter", func() { NewCounterVec("d_total", "D.").With().Add(-1) })
+		mustPanic("unsorted buckets", func() { NewHistogramVec("h", "H.", []float64{1, 0.5}) })
+	})
+}
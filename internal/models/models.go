@@ -1,14 +1,53 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
 
 // Message represents a chat message
 type Message struct {
-	ID        string    `json:"id"`
-	ChatID    string    `json:"chat_id"`
+	ID     string `json:"id"`
+	ChatID string `json:"chat_id"`
+	// Seq is the message's position in its chat. Sequence numbers start at
+	// 1 and increase by one with every message added to the chat.
+	Seq       int64     `json:"seq"`
 	Username  string    `json:"username"`
 	Content   string    `json:"content"`
 	Timestamp time.Time `json:"timestamp"`
+	// Bot is set on messages posted with an API key rather than by a user
+	Bot bool `json:"bot,omitempty"`
+	// EditedAt is set once the message has been edited
+	EditedAt *time.Time `json:"edited_at,omitempty"`
+	// DeletedAt is set on the tombstone left by deleting a message. It
+	// keeps the message's place in the chat but has no content.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// ReplyTo is the message this one answers. Replies to a reply belong
+	// to the same thread, named by ThreadID after the message that
+	// started it.
+	ReplyTo  string `json:"reply_to,omitempty"`
+	ThreadID string `json:"thread_id,omitempty"`
+	// ReplyCount is the number of replies in the thread a message starts
+	ReplyCount int `json:"reply_count,omitempty"`
+	// Reactions counts the users who reacted to the message with each
+	// emoji
+	Reactions map[string]int `json:"reactions,omitempty"`
+}
+
+// Revision is an earlier version of an edited message
+type Revision struct {
+	Content string `json:"content"`
+	// ReplacedAt is when an edit replaced this version
+	ReplacedAt time.Time `json:"replaced_at"`
+}
+
+// Reaction is one user's reaction to a message
+type Reaction struct {
+	MessageID string    `json:"message_id"`
+	Username  string    `json:"username"`
+	Emoji     string    `json:"emoji"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 // Chat represents a chat room
@@ -16,15 +55,384 @@ type Chat struct {
 	ID        string    `json:"id"`
 	Name      string    `json:"name"`
 	CreatedAt time.Time `json:"created_at"`
+	// Visibility is VisibilityPublic, VisibilityPrivate or
+	// VisibilityDirect. Chats created before visibility existed have none
+	// and are public.
+	Visibility string `json:"visibility,omitempty"`
+	// Participants are the two users of a direct chat
+	Participants []string `json:"participants,omitempty"`
+	Topic        string   `json:"topic,omitempty"`
+	Description  string   `json:"description,omitempty"`
+	// ArchivedAt is set while the chat is archived, which makes it
+	// read-only and leaves it out of chat listings
+	ArchivedAt *time.Time `json:"archived_at,omitempty"`
+	// Retention overrides the server's retention policy for this chat
+	Retention *RetentionPolicy `json:"retention,omitempty"`
+	// LegalHold suspends retention: no message of the chat expires while
+	// it is set
+	LegalHold bool `json:"legal_hold,omitempty"`
+}
+
+// RetentionPolicy limits how many messages a chat keeps and for how long.
+// Older messages expire and are removed for good. Zero fields set no limit.
+type RetentionPolicy struct {
+	// MaxMessages is the number of newest messages kept, counting deleted
+	// ones
+	MaxMessages int `json:"max_messages,omitempty"`
+	// MaxAge is how long messages are kept after being posted
+	MaxAge Duration `json:"max_age,omitempty"`
+}
+
+// RetentionResponse describes the retention policy in force in a chat
+type RetentionResponse struct {
+	RetentionPolicy
+	// Inherited reports whether the policy is the server's, the chat not
+	// having one of its own
+	Inherited bool `json:"inherited"`
+	LegalHold bool `json:"legal_hold"`
+}
+
+// Duration is a time.Duration written to JSON as a string such as "720h0m0s"
+type Duration time.Duration
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON reads a duration string such as "24h"
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("duration must be a string such as \"24h\": %w", err)
+	}
+	parsed, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Chat visibilities
+const (
+	// VisibilityPublic chats are listed to everyone and open to join
+	VisibilityPublic = "public"
+	// VisibilityPrivate chats are only seen by their members and joined
+	// with an invite
+	VisibilityPrivate = "private"
+	// VisibilityDirect chats are direct messages between two users, seen
+	// only by them
+	VisibilityDirect = "direct"
+)
+
+// ChatSummary is a chat together with figures about its messages, as
+// returned by chat listings
+type ChatSummary struct {
+	*Chat
+	// MessageCount is the number of messages in the chat, not counting
+	// deleted ones
+	MessageCount int `json:"message_count"`
+	// LastActivityAt is when the last message was posted, or when the
+	// chat was created if it has none
+	LastActivityAt time.Time `json:"last_activity_at"`
+	// LastMessage is a preview of the newest message that was not deleted
+	LastMessage *MessagePreview `json:"last_message,omitempty"`
+}
+
+// MessagePreview is the start of a message, shown in chat listings
+type MessagePreview struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	Content   string    `json:"content"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ChatPageResponse is a page of chats. Pass NextCursor as "cursor" to fetch
+// the next page; it is omitted on the last one.
+type ChatPageResponse struct {
+	Chats      []*ChatSummary `json:"chats"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// SearchResult is a message matching a search, best matches first
+type SearchResult struct {
+	Message *Message `json:"message"`
+	// Score ranks the result; higher is a better match
+	Score float64 `json:"score"`
+	// Highlight is the message content with every matching word wrapped
+	// in ** markers
+	Highlight string `json:"highlight"`
+}
+
+// MessagePageResponse is a page of messages returned when paging through a
+// chat's history. Cursors are message IDs: pass PrevCursor as "before" to
+// fetch older messages and NextCursor as "after" to fetch newer ones. A
+// cursor is omitted when there is nothing further in that direction.
+type MessagePageResponse struct {
+	Messages   []*Message `json:"messages"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+	PrevCursor string     `json:"prev_cursor,omitempty"`
 }
 
 // CreateChatRequest represents a request to create a new chat
 type CreateChatRequest struct {
 	Name string `json:"name"`
+	// Visibility defaults to VisibilityPublic
+	Visibility string `json:"visibility,omitempty"`
+}
+
+// DirectChatRequest represents a request for the direct chat with a user
+type DirectChatRequest struct {
+	Username string `json:"username"`
+}
+
+// UpdateChatRequest represents a request to change a chat. Fields left out
+// are not changed; an empty topic or description clears it.
+type UpdateChatRequest struct {
+	Name        string  `json:"name,omitempty"`
+	Topic       *string `json:"topic,omitempty"`
+	Description *string `json:"description,omitempty"`
+	Archived    *bool   `json:"archived,omitempty"`
+	LegalHold   *bool   `json:"legal_hold,omitempty"`
+}
+
+// Chat member roles, from most to least privileged
+const (
+	// RoleOwner is held by the chat's creator, who may do anything
+	RoleOwner = "owner"
+	// RoleAdmin may rename the chat and manage members below admin
+	RoleAdmin = "admin"
+	// RoleMember may post messages
+	RoleMember = "member"
+	// RoleReadOnly may only read
+	RoleReadOnly = "read-only"
+)
+
+// Member is a user's membership of a chat
+type Member struct {
+	ChatID   string    `json:"chat_id"`
+	Username string    `json:"username"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// AddMemberRequest represents a request to add a user to a chat. Role
+// defaults to member.
+type AddMemberRequest struct {
+	Username string `json:"username"`
+	Role     string `json:"role,omitempty"`
+}
+
+// UpdateMemberRequest represents a request to change a member's role
+type UpdateMemberRequest struct {
+	Role string `json:"role"`
+}
+
+// Invite grants membership of a chat to whoever redeems its token
+type Invite struct {
+	ID        string    `json:"id"`
+	ChatID    string    `json:"chat_id"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	// ExpiresAt is nil for invites that do not expire
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// MaxUses is 0 for invites that may be redeemed any number of times
+	MaxUses int `json:"max_uses,omitempty"`
+	Uses    int `json:"uses"`
+}
+
+// CreateInviteRequest represents a request to create an invite. ExpiresIn is
+// a duration such as "24h"; without it the invite does not expire.
+type CreateInviteRequest struct {
+	ExpiresIn string `json:"expires_in,omitempty"`
+	MaxUses   int    `json:"max_uses,omitempty"`
+}
+
+// CreateInviteResponse carries a new invite and its token. The token is
+// only ever returned here.
+type CreateInviteResponse struct {
+	Invite *Invite `json:"invite"`
+	Token  string  `json:"token"`
+}
+
+// AcceptInviteRequest represents a request to redeem an invite token
+type AcceptInviteRequest struct {
+	Token string `json:"token"`
+}
+
+// EditMessageRequest represents a request to change a message's content
+type EditMessageRequest struct {
+	Content string `json:"content"`
 }
 
-// SendMessageRequest represents a request to send a message
+// ReactionRequest represents a request to add or remove a reaction, such as
+// ":thumbsup:" or an emoji character
+type ReactionRequest struct {
+	Emoji string `json:"emoji"`
+}
+
+// SendMessageRequest represents a request to send a message. The author is
+// the authenticated user, not a field of the request.
 type SendMessageRequest struct {
+	Content string `json:"content"`
+	// ReplyTo makes the message a reply. ThreadID alone replies to the
+	// message that started the thread.
+	ReplyTo  string `json:"reply_to,omitempty"`
+	ThreadID string `json:"thread_id,omitempty"`
+}
+
+// User represents a registered user
+type User struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RegisterRequest represents a request to create a user account
+type RegisterRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// LoginRequest represents a request to start a session
+type LoginRequest struct {
 	Username string `json:"username"`
-	Content  string `json:"content"`
+	Password string `json:"password"`
+}
+
+// SessionResponse is returned on login. Token is sent back in an
+// "Authorization: Bearer" header to authenticate later requests.
+type SessionResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	User      *User     `json:"user"`
+}
+
+// API key scopes
+const (
+	// ScopeRead allows reading chats and their messages
+	ScopeRead = "read"
+	// ScopeWrite allows posting messages
+	ScopeWrite = "write"
+)
+
+// APIKey is a credential for programs such as CI bots. Requests made with it
+// act as the bot named BotName, limited to Scopes and, if Chats is not
+// empty, to the listed chats.
+type APIKey struct {
+	ID        string     `json:"id"`
+	BotName   string     `json:"bot_name"`
+	Owner     string     `json:"owner"`
+	Chats     []string   `json:"chats,omitempty"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// CreateAPIKeyRequest represents a request to create an API key
+type CreateAPIKeyRequest struct {
+	BotName string   `json:"bot_name"`
+	Chats   []string `json:"chats,omitempty"`
+	Scopes  []string `json:"scopes"`
+}
+
+// CreateAPIKeyResponse is returned when an API key is created. The token is
+// only ever shown here.
+type CreateAPIKeyResponse struct {
+	Key   *APIKey `json:"key"`
+	Token string  `json:"token"`
+}
+
+// Event types pushed to streaming clients
+const (
+	// EventMessage carries a newly added message
+	EventMessage = "message"
+	// EventEdit carries a message whose content was edited
+	EventEdit = "edit"
+	// EventDelete carries the tombstone of a deleted message
+	EventDelete = "delete"
+	// EventReaction carries a message whose reaction counts changed
+	EventReaction = "reaction"
+	// EventExpire carries the tombstones of messages removed by retention,
+	// all from one pass over a chat
+	EventExpire = "expire"
+	// EventChatDeleted carries a chat that was deleted. It is the last
+	// event of its stream.
+	EventChatDeleted = "chat_deleted"
+	// EventError reports a problem with a request sent over the stream
+	EventError = "error"
+)
+
+// ErrorResponse is the body of every error response
+type ErrorResponse struct {
+	Error *APIError `json:"error"`
+}
+
+// APIError describes why a request failed
+type APIError struct {
+	// Code is one of the Error constants, for programs to act on
+	Code string `json:"code"`
+	// Message explains the error to people
+	Message string `json:"message"`
+	// Details lists the request fields that failed validation
+	Details []FieldError `json:"details,omitempty"`
+	// RequestID identifies the request in the server's logs
+	RequestID string `json:"request_id"`
+}
+
+// FieldError explains what is wrong with one field of a request
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error codes
+const (
+	// ErrorBadRequest is for requests that cannot be read, such as a
+	// malformed JSON body
+	ErrorBadRequest = "bad_request"
+	// ErrorValidation is for requests with fields that are missing or out
+	// of range; Details names them
+	ErrorValidation = "validation_failed"
+	// ErrorUnauthorized is for requests without valid credentials
+	ErrorUnauthorized = "unauthorized"
+	// ErrorForbidden is for requests the caller is not allowed to make
+	ErrorForbidden = "forbidden"
+	// ErrorNotFound is for requests about something that does not exist
+	ErrorNotFound = "not_found"
+	// ErrorMethodNotAllowed is for requests using the wrong method for a
+	// path
+	ErrorMethodNotAllowed = "method_not_allowed"
+	// ErrorConflict is for changes that clash with the current state, such
+	// as a username already in use
+	ErrorConflict = "conflict"
+	// ErrorGone is for requests about something that no longer applies,
+	// such as an expired invite
+	ErrorGone = "gone"
+	// ErrorQuota is for requests that would exceed a limit
+	ErrorQuota = "quota_exceeded"
+	// ErrorInternal is for failures of the server
+	ErrorInternal = "internal"
+)
+
+// Event is a real-time notification pushed to streaming clients
+type Event struct {
+	Type     string     `json:"type"`
+	Message  *Message   `json:"message,omitempty"`
+	Messages []*Message `json:"messages,omitempty"`
+	Chat     *Chat      `json:"chat,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// Health statuses reported by /healthz and /readyz
+const (
+	HealthOK       = "ok"
+	HealthReady    = "ready"
+	HealthDraining = "draining"
+)
+
+// HealthResponse is the body of /healthz and /readyz
+type HealthResponse struct {
+	Status string `json:"status"`
 }
@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"sort"
+	"time"
+
+	"chat-app/internal/models"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrBotNameTaken is returned by CreateAPIKey when the bot name belongs
+	// to a user or to another user's bot
+	ErrBotNameTaken = newError(ErrConflict, "bot name already taken")
+	// ErrAPIKeyNotFound is returned when an API key does not exist or
+	// belongs to someone else
+	ErrAPIKeyNotFound = newError(ErrNotFound, "API key not found")
+	// ErrAPIKeyQuota is returned by CreateAPIKey when the owner already has
+	// MaxAPIKeys unrevoked keys
+	ErrAPIKeyQuota = newError(ErrQuota, "too many API keys")
+)
+
+// MaxAPIKeys is the number of unrevoked API keys a user may have
+const MaxAPIKeys = 20
+
+// StoredAPIKey is an API key together with the hash of its token
+type StoredAPIKey struct {
+	Key       *models.APIKey `json:"key"`
+	TokenHash string         `json:"token_hash"`
+}
+
+// CreateAPIKey stores a new API key identified by the hash of its token.
+// The ID and creation time of key are assigned here.
+func (s *Storage) CreateAPIKey(tokenHash string, key models.APIKey) (*models.APIKey, error) {
+	defer s.lock("CreateAPIKey")()
+
+	if _, exists := s.accounts[accountKey(key.BotName)]; exists {
+		return nil, ErrBotNameTaken
+	}
+	if owner, exists := s.botOwners[accountKey(key.BotName)]; exists && owner != key.Owner {
+		return nil, ErrBotNameTaken
+	}
+	active := 0
+	for _, stored := range s.apiKeys {
+		if stored.Key.Owner == key.Owner && stored.Key.RevokedAt == nil {
+			active++
+		}
+	}
+	if active >= MaxAPIKeys {
+		return nil, ErrAPIKeyQuota
+	}
+
+	key.ID = uuid.New().String()
+	key.CreatedAt = time.Now()
+	key.RevokedAt = nil
+	stored := &StoredAPIKey{Key: &key, TokenHash: tokenHash}
+	if err := s.commit(&record{Op: opCreateAPIKey, APIKey: stored}); err != nil {
+		return nil, err
+	}
+	return stored.Key, nil
+}
+
+// GetAPIKey retrieves an unrevoked API key by the hash of its token
+func (s *Storage) GetAPIKey(tokenHash string) (*models.APIKey, bool) {
+	defer s.rlock("GetAPIKey")()
+
+	stored, exists := s.apiKeys[s.keyIDs[tokenHash]]
+	if !exists || stored.Key.RevokedAt != nil {
+		return nil, false
+	}
+	return stored.Key, true
+}
+
+// ListAPIKeys returns the API keys created by owner, revoked ones included,
+// oldest first
+func (s *Storage) ListAPIKeys(owner string) []*models.APIKey {
+	defer s.rlock("ListAPIKeys")()
+
+	keys := []*models.APIKey{}
+	for _, stored := range s.apiKeys {
+		if stored.Key.Owner == owner {
+			keys = append(keys, stored.Key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys
+}
+
+// RevokeAPIKey revokes one of owner's API keys. Revoking a key twice keeps
+// the original revocation time.
+func (s *Storage) RevokeAPIKey(owner, keyID string) (*models.APIKey, error) {
+	defer s.lock("RevokeAPIKey")()
+
+	stored, exists := s.apiKeys[keyID]
+	if !exists || stored.Key.Owner != owner {
+		return nil, ErrAPIKeyNotFound
+	}
+	if stored.Key.RevokedAt != nil {
+		return stored.Key, nil
+	}
+
+	// Stored keys are replaced rather than modified in place
+	key := *stored.Key
+	now := time.Now()
+	key.RevokedAt = &now
+	revoked := &StoredAPIKey{Key: &key, TokenHash: stored.TokenHash}
+	if err := s.commit(&record{Op: opRevokeAPIKey, APIKey: revoked}); err != nil {
+		return nil, err
+	}
+	return revoked.Key, nil
+}
@@ -0,0 +1,37 @@
+package storage_test
+
+import (
+	"testing"
+	"time"
+
+	"chat-app/internal/storage"
+	"chat-app/internal/storage/storagetest"
+)
+
+func TestStorageConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Store {
+		return storage.NewStorage()
+	})
+}
+
+func TestFileStoreConformance(t *testing.T) {
+	for _, policy := range []storage.SyncPolicy{storage.SyncAlways, storage.SyncInterval, storage.SyncNever} {
+		t.Run(string(policy), func(t *testing.T) {
+			storagetest.Run(t, func(t *testing.T) storage.Store {
+				s, err := storage.OpenFileStore(t.TempDir(), storage.FileOptions{
+					Sync:         policy,
+					SyncInterval: 10 * time.Millisecond,
+				})
+				if err != nil {
+					t.Fatalf("Failed to open file store: %v", err)
+				}
+				t.Cleanup(func() {
+					if err := s.Close(); err != nil {
+						t.Errorf("Failed to close file store: %v", err)
+					}
+				})
+				return s
+			})
+		})
+	}
+}
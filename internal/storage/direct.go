@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"chat-app/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// ErrSameUser is returned by OpenDirectChat when both users are the same
+var ErrSameUser = newError(ErrValidation, "direct chat needs two different users")
+
+// directKey is the key a direct chat is indexed under. It is the same
+// whichever order the two users are given in.
+func directKey(a, b string) string {
+	a, b = accountKey(a), accountKey(b)
+	if a > b {
+		a, b = b, a
+	}
+	return a + "\x00" + b
+}
+
+// OpenDirectChat returns the direct chat between two users, creating it if
+// it does not exist yet. The second result reports whether it was created.
+func (s *Storage) OpenDirectChat(a, b string) (*models.Chat, bool, error) {
+	defer s.lock("OpenDirectChat")()
+
+	first, exists := s.accounts[accountKey(a)]
+	if !exists {
+		return nil, false, ErrUserNotFound
+	}
+	second, exists := s.accounts[accountKey(b)]
+	if !exists {
+		return nil, false, ErrUserNotFound
+	}
+	if first == second {
+		return nil, false, ErrSameUser
+	}
+	if chatID, exists := s.directChats[directKey(a, b)]; exists {
+		return s.chats[chatID], false, nil
+	}
+
+	now := time.Now()
+	participants := []string{first.User.Username, second.User.Username}
+	chat := &models.Chat{
+		ID:           uuid.New().String(),
+		Name:         strings.Join(participants, ", "),
+		CreatedAt:    now,
+		Visibility:   models.VisibilityDirect,
+		Participants: participants,
+	}
+	rec := &record{Op: opCreateChat, Chat: chat}
+	for _, username := range participants {
+		rec.Members = append(rec.Members, &models.Member{
+			ChatID:   chat.ID,
+			Username: username,
+			Role:     models.RoleMember,
+			JoinedAt: now,
+		})
+	}
+	if err := s.commit(rec); err != nil {
+		return nil, false, err
+	}
+	return chat, true, nil
+}
+
+// ListDirectChats returns the direct chats username takes part in, oldest
+// first
+func (s *Storage) ListDirectChats(username string) []*models.Chat {
+	defer s.rlock("ListDirectChats")()
+
+	chats := []*models.Chat{}
+	for _, chatID := range s.directChats {
+		chat := s.chats[chatID]
+		for _, participant := range chat.Participants {
+			if accountKey(participant) == accountKey(username) {
+				chats = append(chats, chat)
+				break
+			}
+		}
+	}
+	sort.Slice(chats, func(i, j int) bool {
+		return chats[i].CreatedAt.Before(chats[j].CreatedAt)
+	})
+	return chats
+}
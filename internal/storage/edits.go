@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"time"
+
+	"chat-app/internal/models"
+)
+
+var (
+	// ErrMessageNotFound is returned when a message does not exist
+	ErrMessageNotFound = newError(ErrNotFound, "message not found")
+	// ErrMessageDeleted is returned when editing a deleted message
+	ErrMessageDeleted = newError(ErrConflict, "message deleted")
+)
+
+// GetMessage retrieves a message by ID
+func (s *Storage) GetMessage(messageID string) (*models.Message, bool) {
+	defer s.rlock("GetMessage")()
+
+	msg, exists := s.byID[messageID]
+	return msg, exists
+}
+
+// EditMessage replaces the content of a message, keeping the previous
+// content as a revision
+func (s *Storage) EditMessage(messageID, content string) (*models.Message, error) {
+	defer s.lock("EditMessage")()
+
+	msg, exists := s.byID[messageID]
+	if !exists {
+		return nil, ErrMessageNotFound
+	}
+	if msg.DeletedAt != nil {
+		return nil, ErrMessageDeleted
+	}
+
+	// Messages are replaced rather than modified in place
+	now := time.Now()
+	edited := *msg
+	edited.Content = content
+	edited.EditedAt = &now
+	rec := &record{
+		Op:       opEditMessage,
+		Message:  &edited,
+		Revision: &models.Revision{Content: msg.Content, ReplacedAt: now},
+	}
+	if err := s.commit(rec); err != nil {
+		return nil, err
+	}
+	return &edited, nil
+}
+
+// DeleteMessage replaces a message with a tombstone and drops its revision
+// history. The tombstone keeps the message's ID, sequence number, author,
+// time and thread so that cursors and threads stay valid. Deleting a
+// tombstone returns it again.
+func (s *Storage) DeleteMessage(messageID string) (*models.Message, error) {
+	defer s.lock("DeleteMessage")()
+
+	msg, exists := s.byID[messageID]
+	if !exists {
+		return nil, ErrMessageNotFound
+	}
+	if msg.DeletedAt != nil {
+		return msg, nil
+	}
+
+	now := time.Now()
+	tombstone := &models.Message{
+		ID:        msg.ID,
+		ChatID:    msg.ChatID,
+		Seq:       msg.Seq,
+		Username:  msg.Username,
+		Timestamp: msg.Timestamp,
+		Bot:       msg.Bot,
+		DeletedAt: &now,
+
+		ReplyTo:    msg.ReplyTo,
+		ThreadID:   msg.ThreadID,
+		ReplyCount: msg.ReplyCount,
+	}
+	if err := s.commit(&record{Op: opDeleteMessage, Message: tombstone}); err != nil {
+		return nil, err
+	}
+	return tombstone, nil
+}
+
+// GetRevisions returns the earlier versions of a message, oldest first
+func (s *Storage) GetRevisions(messageID string) ([]*models.Revision, error) {
+	defer s.rlock("GetRevisions")()
+
+	if _, exists := s.byID[messageID]; !exists {
+		return nil, ErrMessageNotFound
+	}
+	return append([]*models.Revision{}, s.revisions[messageID]...), nil
+}
+
+// replaceMessage swaps the stored version of a message for msg. The caller
+// must hold the write lock.
+func (s *Storage) replaceMessage(msg *models.Message) {
+	messages := s.messages[msg.ChatID]
+	if i := indexOfSeq(messages, msg.Seq); i < len(messages) && messages[i].ID == msg.ID {
+		messages[i] = msg
+	}
+	s.byID[msg.ID] = msg
+}
@@ -0,0 +1,33 @@
+package storage
+
+import "errors"
+
+// Error kinds. Every error the store returns because of the request rather
+// than a failure of the store wraps one of these, so callers can tell them
+// apart with errors.Is without knowing each specific error.
+var (
+	// ErrNotFound is wrapped by errors about something that does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is wrapped by errors about a change that clashes with the
+	// current state, such as a name already in use
+	ErrConflict = errors.New("conflict")
+	// ErrValidation is wrapped by errors about an argument that can never
+	// be valid
+	ErrValidation = errors.New("invalid argument")
+	// ErrQuota is wrapped by errors about a limit that has been reached
+	ErrQuota = errors.New("quota exceeded")
+)
+
+// kindError is an error of one of the kinds above
+type kindError struct {
+	text string
+	kind error
+}
+
+func newError(kind error, text string) error {
+	return &kindError{text: text, kind: kind}
+}
+
+func (e *kindError) Error() string { return e.text }
+
+func (e *kindError) Unwrap() error { return e.kind }
@@ -0,0 +1,277 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// legacyWALFileName is the single log file written before the log was split
+// into segments. It is adopted as the first segment on open.
+const legacyWALFileName = "chat.wal"
+
+// DefaultSnapshotRetain is the number of snapshots kept when
+// FileOptions.SnapshotRetain is not set
+const DefaultSnapshotRetain = 2
+
+// FileOptions configures a FileStore
+type FileOptions struct {
+	// Sync controls when appended records are fsynced
+	Sync SyncPolicy
+	// SyncInterval is how often the log is fsynced under SyncInterval
+	SyncInterval time.Duration
+	// SnapshotInterval is how often a snapshot is taken in the background.
+	// Zero disables periodic snapshots.
+	SnapshotInterval time.Duration
+	// SnapshotRetain is how many snapshots to keep. Log segments older than
+	// the oldest retained snapshot are deleted.
+	SnapshotRetain int
+	// ErrorLog receives errors from background snapshots. If nil, the
+	// standard logger is used.
+	ErrorLog *log.Logger
+}
+
+// FileStore is a durable store that keeps its state in memory and appends
+// every mutation to a write-ahead log. On startup the latest valid snapshot
+// is loaded and the log segments written after it are replayed.
+type FileStore struct {
+	*Storage
+	dir    string
+	opts   FileOptions
+	wal    *wal
+	replay ReplayStats
+
+	snapshotMu sync.Mutex // serializes snapshots
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+// Ensure FileStore implements Store
+var _ Store = (*FileStore)(nil)
+
+// OpenFileStore opens or creates a file-backed store in dir
+func OpenFileStore(dir string, opts FileOptions) (*FileStore, error) {
+	if opts.Sync == "" {
+		opts.Sync = SyncAlways
+	}
+	if opts.SnapshotRetain <= 0 {
+		opts.SnapshotRetain = DefaultSnapshotRetain
+	}
+	if opts.ErrorLog == nil {
+		opts.ErrorLog = log.Default()
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create data directory: %w", err)
+	}
+	if err := adoptLegacyWAL(dir); err != nil {
+		return nil, err
+	}
+
+	mem := NewStorage()
+	stats, segment, err := recoverState(dir, mem)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := openWAL(dir, segment, opts.Sync, opts.SyncInterval)
+	if err != nil {
+		return nil, err
+	}
+	mem.journal = w.append
+
+	f := &FileStore{
+		Storage: mem,
+		dir:     dir,
+		opts:    opts,
+		wal:     w,
+		replay:  stats,
+	}
+	if opts.SnapshotInterval > 0 {
+		f.stop = make(chan struct{})
+		f.done = make(chan struct{})
+		go f.snapshotLoop(opts.SnapshotInterval)
+	}
+	return f, nil
+}
+
+// adoptLegacyWAL renames a pre-segment log to the first segment
+func adoptLegacyWAL(dir string) error {
+	legacy := filepath.Join(dir, legacyWALFileName)
+	if _, err := os.Stat(legacy); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	segments, err := listSegments(dir)
+	if err != nil {
+		return err
+	}
+	if len(segments) > 0 {
+		return fmt.Errorf("found both %s and log segments in %s", legacyWALFileName, dir)
+	}
+	if err := os.Rename(legacy, filepath.Join(dir, segmentName(1))); err != nil {
+		return fmt.Errorf("adopt legacy log: %w", err)
+	}
+	return syncDir(dir)
+}
+
+// recoverState loads the newest valid snapshot into mem and replays the log
+// segments after it. It returns the segment new records should go to.
+func recoverState(dir string, mem *Storage) (ReplayStats, uint64, error) {
+	var stats ReplayStats
+
+	snapshots, err := listSnapshots(dir)
+	if err != nil {
+		return stats, 0, err
+	}
+	var snapshotErr error
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		state, err := readSnapshot(dir, snapshots[i])
+		if err == nil {
+			err = mem.restoreState(state)
+		}
+		if err != nil {
+			snapshotErr = errors.Join(snapshotErr, fmt.Errorf("%s: %w", snapshotName(snapshots[i]), err))
+			continue
+		}
+		stats.Snapshot = snapshots[i]
+		break
+	}
+	if len(snapshots) > 0 && stats.Snapshot == 0 {
+		return stats, 0, fmt.Errorf("no valid snapshot: %w", snapshotErr)
+	}
+
+	segments, err := listSegments(dir)
+	if err != nil {
+		return stats, 0, err
+	}
+	var replay []uint64
+	for _, seg := range segments {
+		if seg >= stats.Snapshot {
+			replay = append(replay, seg)
+		}
+	}
+
+	// The snapshot covers every segment before its own number, so replay
+	// must start exactly there and continue without gaps
+	next := stats.Snapshot
+	if next == 0 {
+		next = 1
+	}
+	for i, seg := range replay {
+		if seg != next {
+			return stats, 0, fmt.Errorf("%w: missing log segment %s", ErrCorrupt, segmentName(next))
+		}
+		path := filepath.Join(dir, segmentName(seg))
+		if err := replaySegment(path, i == len(replay)-1, &stats, mem.replay); err != nil {
+			return stats, 0, err
+		}
+		stats.Segments++
+		next++
+	}
+	if len(replay) > 0 {
+		next--
+	}
+	return stats, next, nil
+}
+
+// Recovered reports what was loaded from disk when the store was opened
+func (f *FileStore) Recovered() ReplayStats {
+	return f.replay
+}
+
+// Snapshot writes the full state to a new snapshot, then deletes snapshots
+// beyond the retention limit and the log segments they alone needed.
+func (f *FileStore) Snapshot() error {
+	f.snapshotMu.Lock()
+	defer f.snapshotMu.Unlock()
+
+	// Rotating under the storage lock makes the captured state match
+	// exactly the records in the segments before the new one
+	unlock := f.Storage.lock("Snapshot")
+	seq, err := f.wal.rotate()
+	if err != nil {
+		unlock()
+		return err
+	}
+	state := f.Storage.captureState()
+	unlock()
+
+	if err := writeSnapshot(f.dir, seq, state); err != nil {
+		return err
+	}
+	return f.compact()
+}
+
+// compact removes snapshots beyond the retention limit and every log
+// segment older than the oldest remaining snapshot
+func (f *FileStore) compact() error {
+	snapshots, err := listSnapshots(f.dir)
+	if err != nil {
+		return err
+	}
+	if len(snapshots) == 0 {
+		return nil
+	}
+	if excess := len(snapshots) - f.opts.SnapshotRetain; excess > 0 {
+		for _, seq := range snapshots[:excess] {
+			if err := os.Remove(filepath.Join(f.dir, snapshotName(seq))); err != nil {
+				return fmt.Errorf("remove snapshot: %w", err)
+			}
+		}
+		snapshots = snapshots[excess:]
+	}
+
+	segments, err := listSegments(f.dir)
+	if err != nil {
+		return err
+	}
+	for _, seg := range segments {
+		if seg >= snapshots[0] {
+			break
+		}
+		if err := os.Remove(filepath.Join(f.dir, segmentName(seg))); err != nil {
+			return fmt.Errorf("remove log segment: %w", err)
+		}
+	}
+	return syncDir(f.dir)
+}
+
+func (f *FileStore) snapshotLoop(interval time.Duration) {
+	defer close(f.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !f.wal.pending() {
+				continue
+			}
+			if err := f.Snapshot(); err != nil {
+				f.opts.ErrorLog.Printf("storage: snapshot failed: %v", err)
+			}
+		case <-f.stop:
+			return
+		}
+	}
+}
+
+// Sync flushes any records not yet fsynced to disk
+func (f *FileStore) Sync() error {
+	return f.wal.sync()
+}
+
+// Close stops background snapshots and flushes and closes the log. Writes
+// after Close fail with ErrClosed.
+func (f *FileStore) Close() error {
+	if f.stop != nil {
+		close(f.stop)
+		<-f.done
+		f.stop = nil
+	}
+	return f.wal.close()
+}
@@ -0,0 +1,823 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"chat-app/internal/models"
+)
+
+func openTestFileStore(t *testing.T, dir string) *FileStore {
+	t.Helper()
+	s, err := OpenFileStore(dir, FileOptions{Sync: SyncAlways})
+	if err != nil {
+		t.Fatalf("Failed to open file store: %v", err)
+	}
+	return s
+}
+
+func closeTestFileStore(t *testing.T, s *FileStore) {
+	t.Helper()
+	if err := s.Close(); err != nil {
+		t.Errorf("Failed to close store: %v", err)
+	}
+}
+
+// failingFile writes half of the next frame and then fails, as a full disk
+// would. With failTruncate the torn frame cannot be cut off either.
+type failingFile struct {
+	segmentFile
+	failWrite    bool
+	failTruncate bool
+}
+
+func (f *failingFile) Write(b []byte) (int, error) {
+	if !f.failWrite {
+		return f.segmentFile.Write(b)
+	}
+	f.failWrite = false
+	n, _ := f.segmentFile.Write(b[:len(b)/2])
+	return n, errors.New("no space left on device")
+}
+
+func (f *failingFile) Truncate(size int64) error {
+	if f.failTruncate {
+		return errors.New("input/output error")
+	}
+	return f.segmentFile.Truncate(size)
+}
+
+func TestFileStore(t *testing.T) {
+	t.Run("RecoversAfterReopen", func(t *testing.T) {
+		dir := t.TempDir()
+		s := openTestFileStore(t, dir)
+
+		chat, err := s.CreateChat("Durable Chat")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+		for _, content := range []string{"one", "two"} {
+			if _, err := s.AddMessage(chat.ID, "user", content); err != nil {
+				t.Fatalf("Failed to add message: %v", err)
+			}
+		}
+		if err := s.Close(); err != nil {
+			t.Fatalf("Failed to close store: %v", err)
+		}
+
+		s = openTestFileStore(t, dir)
+		defer closeTestFileStore(t, s)
+
+		if got := s.Recovered().Records; got != 3 {
+			t.Errorf("Expected 3 recovered records, got %d", got)
+		}
+
+		restored, exists := s.GetChat(chat.ID)
+		if !exists {
+			t.Fatal("Expected chat to survive reopen")
+		}
+		if restored.Name != "Durable Chat" {
+			t.Errorf("Expected chat name 'Durable Chat', got '%s'", restored.Name)
+		}
+
+		messages, _ := s.GetMessages(chat.ID)
+		if len(messages) != 2 {
+			t.Fatalf("Expected 2 messages, got %d", len(messages))
+		}
+		if messages[0].Content != "one" || messages[1].Content != "two" {
+			t.Errorf("Expected messages in order, got '%s', '%s'", messages[0].Content, messages[1].Content)
+		}
+
+		msg, err := s.AddMessage(chat.ID, "user", "three")
+		if err != nil {
+			t.Fatalf("Failed to add message: %v", err)
+		}
+		if msg.Seq != 3 {
+			t.Errorf("Expected sequence numbering to continue at 3, got %d", msg.Seq)
+		}
+	})
+
+	t.Run("RecoversUsersAndSessions", func(t *testing.T) {
+		dir := t.TempDir()
+		s := openTestFileStore(t, dir)
+
+		user, err := s.CreateUser("alice", "hash")
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		if _, err := s.CreateSession("before-snapshot", "alice", time.Hour); err != nil {
+			t.Fatalf("Failed to create session: %v", err)
+		}
+		if _, err := s.CreateSession("expired", "alice", -time.Second); err != nil {
+			t.Fatalf("Failed to create session: %v", err)
+		}
+		if err := s.Snapshot(); err != nil {
+			t.Fatalf("Failed to snapshot: %v", err)
+		}
+		if _, err := s.CreateSession("after-snapshot", "alice", time.Hour); err != nil {
+			t.Fatalf("Failed to create session: %v", err)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatalf("Failed to close store: %v", err)
+		}
+
+		s = openTestFileStore(t, dir)
+		defer closeTestFileStore(t, s)
+
+		account, exists := s.GetAccount("alice")
+		if !exists {
+			t.Fatal("Expected account to survive reopen")
+		}
+		if account.User.ID != user.ID || account.PasswordHash != "hash" {
+			t.Errorf("Expected account %s with hash 'hash', got %+v", user.ID, account)
+		}
+		for _, hash := range []string{"before-snapshot", "after-snapshot"} {
+			if _, exists := s.GetSession(hash); !exists {
+				t.Errorf("Expected session %s to survive reopen", hash)
+			}
+		}
+		if _, exists := s.sessions["expired"]; exists {
+			t.Error("Expected expired session to be dropped by the snapshot")
+		}
+	})
+
+	t.Run("RecoversAPIKeys", func(t *testing.T) {
+		dir := t.TempDir()
+		s := openTestFileStore(t, dir)
+
+		kept, err := s.CreateAPIKey("kept", models.APIKey{BotName: "ci-bot", Owner: "alice", Scopes: []string{models.ScopeWrite}})
+		if err != nil {
+			t.Fatalf("Failed to create API key: %v", err)
+		}
+		revoked, err := s.CreateAPIKey("revoked", models.APIKey{BotName: "ci-bot", Owner: "alice", Scopes: []string{models.ScopeRead}})
+		if err != nil {
+			t.Fatalf("Failed to create API key: %v", err)
+		}
+		if err := s.Snapshot(); err != nil {
+			t.Fatalf("Failed to snapshot: %v", err)
+		}
+		if _, err := s.RevokeAPIKey("alice", revoked.ID); err != nil {
+			t.Fatalf("Failed to revoke API key: %v", err)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatalf("Failed to close store: %v", err)
+		}
+
+		s = openTestFileStore(t, dir)
+		defer closeTestFileStore(t, s)
+
+		if key, exists := s.GetAPIKey("kept"); !exists || key.ID != kept.ID {
+			t.Errorf("Expected key %s to survive reopen, got %+v", kept.ID, key)
+		}
+		if _, exists := s.GetAPIKey("revoked"); exists {
+			t.Error("Expected revocation to survive reopen")
+		}
+		if _, err := s.CreateAPIKey("other", models.APIKey{BotName: "ci-bot", Owner: "bob", Scopes: []string{models.ScopeRead}}); !errors.Is(err, ErrBotNameTaken) {
+			t.Errorf("Expected bot ownership to survive reopen, got %v", err)
+		}
+	})
+
+	t.Run("RecoversChatChanges", func(t *testing.T) {
+		dir := t.TempDir()
+		s := openTestFileStore(t, dir)
+
+		for _, name := range []string{"alice", "bob"} {
+			if _, err := s.CreateUser(name, "hash"); err != nil {
+				t.Fatalf("Failed to create user: %v", err)
+			}
+		}
+		chat, err := s.CreateChatWithOwner("Team", "alice", models.VisibilityPrivate)
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+		doomed, err := s.CreateChatWithOwner("Doomed", "alice", "")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+		if _, err := s.SetMember(chat.ID, "bob", models.RoleMember); err != nil {
+			t.Fatalf("Failed to add member: %v", err)
+		}
+		if _, err := s.CreateInvite("before", models.Invite{ChatID: chat.ID, MaxUses: 2}); err != nil {
+			t.Fatalf("Failed to create invite: %v", err)
+		}
+		if _, err := s.CreateInvite("doomed", models.Invite{ChatID: doomed.ID}); err != nil {
+			t.Fatalf("Failed to create invite: %v", err)
+		}
+		if err := s.Snapshot(); err != nil {
+			t.Fatalf("Failed to snapshot: %v", err)
+		}
+		if _, err := s.SetMember(chat.ID, "bob", models.RoleAdmin); err != nil {
+			t.Fatalf("Failed to change role: %v", err)
+		}
+		if _, err := s.CreateUser("carol", "hash"); err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		if _, err := s.RedeemInvite("before", "carol"); err != nil {
+			t.Fatalf("Failed to redeem invite: %v", err)
+		}
+		name := "Renamed Team"
+		if _, err := s.UpdateChat(chat.ID, ChatUpdate{Name: &name}); err != nil {
+			t.Fatalf("Failed to rename chat: %v", err)
+		}
+		topic, archive := "Planning", true
+		if _, err := s.UpdateChat(chat.ID, ChatUpdate{Topic: &topic, Archived: &archive}); err != nil {
+			t.Fatalf("Failed to update chat: %v", err)
+		}
+		if err := s.DeleteChat(doomed.ID); err != nil {
+			t.Fatalf("Failed to delete chat: %v", err)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatalf("Failed to close store: %v", err)
+		}
+
+		s = openTestFileStore(t, dir)
+		defer closeTestFileStore(t, s)
+
+		if got, _ := s.GetChat(chat.ID); got == nil || got.Name != "Renamed Team" || got.Visibility != models.VisibilityPrivate {
+			t.Errorf("Expected the rename to survive reopen, got %+v", got)
+		} else if got.Topic != "Planning" || got.ArchivedAt == nil {
+			t.Errorf("Expected the topic and archive state to survive reopen, got %+v", got)
+		}
+		if _, exists := s.GetChat(doomed.ID); exists {
+			t.Error("Expected the deletion to survive reopen")
+		}
+		if member, _ := s.GetMember(chat.ID, "bob"); member == nil || member.Role != models.RoleAdmin {
+			t.Errorf("Expected bob to be an admin after reopen, got %+v", member)
+		}
+		if member, _ := s.GetMember(chat.ID, "alice"); member == nil || member.Role != models.RoleOwner {
+			t.Errorf("Expected alice to be the owner after reopen, got %+v", member)
+		}
+		if _, exists := s.GetMember(chat.ID, "carol"); !exists {
+			t.Error("Expected carol's redemption to survive reopen")
+		}
+		// The invite had two uses, one of which carol took
+		if _, err := s.CreateUser("dave", "hash"); err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		if _, err := s.RedeemInvite("before", "dave"); err != nil {
+			t.Errorf("Expected the invite's last use to remain, got %v", err)
+		}
+		if _, err := s.RedeemInvite("before", "alice"); err != nil {
+			t.Errorf("Expected members to redeem without error, got %v", err)
+		}
+		if _, err := s.RedeemInvite("doomed", "dave"); !errors.Is(err, ErrInviteNotFound) {
+			t.Errorf("Expected the deleted chat's invite to be gone, got %v", err)
+		}
+	})
+
+	t.Run("RecoversDirectChats", func(t *testing.T) {
+		dir := t.TempDir()
+		s := openTestFileStore(t, dir)
+
+		for _, name := range []string{"alice", "bob", "carol"} {
+			if _, err := s.CreateUser(name, "hash"); err != nil {
+				t.Fatalf("Failed to create user: %v", err)
+			}
+		}
+		snapped, _, err := s.OpenDirectChat("alice", "bob")
+		if err != nil {
+			t.Fatalf("Failed to open direct chat: %v", err)
+		}
+		if err := s.Snapshot(); err != nil {
+			t.Fatalf("Failed to snapshot: %v", err)
+		}
+		logged, _, err := s.OpenDirectChat("carol", "alice")
+		if err != nil {
+			t.Fatalf("Failed to open direct chat: %v", err)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatalf("Failed to close store: %v", err)
+		}
+
+		s = openTestFileStore(t, dir)
+		defer closeTestFileStore(t, s)
+
+		for _, want := range []*models.Chat{snapped, logged} {
+			a, b := want.Participants[0], want.Participants[1]
+			chat, created, err := s.OpenDirectChat(b, a)
+			if err != nil || created || chat.ID != want.ID {
+				t.Errorf("Expected direct chat %s after reopen, got %+v, %v, %v", want.ID, chat, created, err)
+			}
+			if _, exists := s.GetMember(want.ID, b); !exists {
+				t.Errorf("Expected %s to be a member after reopen", b)
+			}
+		}
+	})
+
+	t.Run("RecoversEdits", func(t *testing.T) {
+		dir := t.TempDir()
+		s := openTestFileStore(t, dir)
+
+		chat, err := s.CreateChat("Edited")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+		var ids []string
+		for _, content := range []string{"First", "Second", "Third"} {
+			msg, err := s.AddMessage(chat.ID, "user", content)
+			if err != nil {
+				t.Fatalf("Failed to add message: %v", err)
+			}
+			ids = append(ids, msg.ID)
+		}
+		if _, err := s.EditMessage(ids[0], "First, edited"); err != nil {
+			t.Fatalf("Failed to edit message: %v", err)
+		}
+		if err := s.Snapshot(); err != nil {
+			t.Fatalf("Failed to snapshot: %v", err)
+		}
+		if _, err := s.EditMessage(ids[0], "First, edited again"); err != nil {
+			t.Fatalf("Failed to edit message: %v", err)
+		}
+		if _, err := s.DeleteMessage(ids[1]); err != nil {
+			t.Fatalf("Failed to delete message: %v", err)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatalf("Failed to close store: %v", err)
+		}
+
+		s = openTestFileStore(t, dir)
+		defer closeTestFileStore(t, s)
+
+		messages, _ := s.GetMessages(chat.ID)
+		if len(messages) != 3 {
+			t.Fatalf("Expected 3 messages after reopen, got %d", len(messages))
+		}
+		if messages[0].Content != "First, edited again" || messages[0].EditedAt == nil {
+			t.Errorf("Expected the edits to survive reopen, got %+v", messages[0])
+		}
+		if messages[1].DeletedAt == nil || messages[1].Content != "" {
+			t.Errorf("Expected the tombstone to survive reopen, got %+v", messages[1])
+		}
+		if revisions, _ := s.GetRevisions(ids[0]); len(revisions) != 2 {
+			t.Errorf("Expected 2 revisions after reopen, got %d", len(revisions))
+		}
+		if msg, _ := s.AddMessage(chat.ID, "user", "Fourth"); msg == nil || msg.Seq != 4 {
+			t.Errorf("Expected numbering to continue at 4, got %+v", msg)
+		}
+	})
+
+	t.Run("RecoversThreads", func(t *testing.T) {
+		dir := t.TempDir()
+		s := openTestFileStore(t, dir)
+
+		chat, err := s.CreateChat("Threaded")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+		root, err := s.AddMessage(chat.ID, "alice", "Root")
+		if err != nil {
+			t.Fatalf("Failed to add message: %v", err)
+		}
+		if _, err := s.Post(NewMessage{ChatID: chat.ID, Username: "bob", Content: "First reply", ReplyTo: root.ID}); err != nil {
+			t.Fatalf("Failed to post reply: %v", err)
+		}
+		if err := s.Snapshot(); err != nil {
+			t.Fatalf("Failed to snapshot: %v", err)
+		}
+		if _, err := s.Post(NewMessage{ChatID: chat.ID, Username: "bob", Content: "Second reply", ReplyTo: root.ID}); err != nil {
+			t.Fatalf("Failed to post reply: %v", err)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatalf("Failed to close store: %v", err)
+		}
+
+		s = openTestFileStore(t, dir)
+		defer closeTestFileStore(t, s)
+
+		thread, err := s.GetThread(root.ID)
+		if err != nil {
+			t.Fatalf("Failed to get thread: %v", err)
+		}
+		if len(thread) != 3 || thread[0].ReplyCount != 2 || thread[2].Content != "Second reply" {
+			t.Errorf("Expected the thread to survive reopen, got %+v", thread)
+		}
+	})
+
+	t.Run("RecoversReactions", func(t *testing.T) {
+		dir := t.TempDir()
+		s := openTestFileStore(t, dir)
+
+		chat, err := s.CreateChat("Reacted")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+		msg, err := s.AddMessage(chat.ID, "alice", "Shipped")
+		if err != nil {
+			t.Fatalf("Failed to add message: %v", err)
+		}
+		if _, err := s.AddReaction(msg.ID, "alice", ":tada:"); err != nil {
+			t.Fatalf("Failed to add reaction: %v", err)
+		}
+		if err := s.Snapshot(); err != nil {
+			t.Fatalf("Failed to snapshot: %v", err)
+		}
+		if _, err := s.AddReaction(msg.ID, "bob", ":tada:"); err != nil {
+			t.Fatalf("Failed to add reaction: %v", err)
+		}
+		if _, err := s.RemoveReaction(msg.ID, "alice", ":tada:"); err != nil {
+			t.Fatalf("Failed to remove reaction: %v", err)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatalf("Failed to close store: %v", err)
+		}
+
+		s = openTestFileStore(t, dir)
+		defer closeTestFileStore(t, s)
+
+		got, _ := s.GetMessage(msg.ID)
+		if got.Reactions[":tada:"] != 1 {
+			t.Errorf("Expected 1 :tada: after reopen, got %v", got.Reactions)
+		}
+		reactions, _ := s.ListReactions(msg.ID)
+		if len(reactions) != 1 || reactions[0].Username != "bob" {
+			t.Errorf("Expected bob's reaction after reopen, got %+v", reactions)
+		}
+	})
+
+	t.Run("RecoversRetention", func(t *testing.T) {
+		dir := t.TempDir()
+		s := openTestFileStore(t, dir)
+
+		chat, err := s.CreateChat("Expiring")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+		root, err := s.AddMessage(chat.ID, "alice", "Root")
+		if err != nil {
+			t.Fatalf("Failed to add message: %v", err)
+		}
+		if _, err := s.Post(NewMessage{ChatID: chat.ID, Username: "bob", Content: "Reply", ReplyTo: root.ID}); err != nil {
+			t.Fatalf("Failed to post reply: %v", err)
+		}
+		if _, err := s.SetRetention(chat.ID, &models.RetentionPolicy{MaxMessages: 1}); err != nil {
+			t.Fatalf("Failed to set retention: %v", err)
+		}
+		// The thread's root expires before its reply
+		if _, err := s.ApplyRetention(models.RetentionPolicy{}, time.Now()); err != nil {
+			t.Fatalf("Failed to apply retention: %v", err)
+		}
+		if err := s.Snapshot(); err != nil {
+			t.Fatalf("Failed to snapshot: %v", err)
+		}
+		last, err := s.AddMessage(chat.ID, "alice", "Last")
+		if err != nil {
+			t.Fatalf("Failed to add message: %v", err)
+		}
+		if _, err := s.ApplyRetention(models.RetentionPolicy{}, time.Now()); err != nil {
+			t.Fatalf("Failed to apply retention: %v", err)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatalf("Failed to close store: %v", err)
+		}
+
+		s = openTestFileStore(t, dir)
+		defer closeTestFileStore(t, s)
+
+		messages, _ := s.GetMessages(chat.ID)
+		if len(messages) != 1 || messages[0].ID != last.ID {
+			t.Errorf("Expected only the last message to survive reopen, got %+v", messages)
+		}
+		if summary, _ := s.GetChatSummary(chat.ID); summary.MessageCount != 1 {
+			t.Errorf("Expected a message count of 1 after reopen, got %d", summary.MessageCount)
+		}
+		if got, _ := s.GetChat(chat.ID); got.Retention == nil || got.Retention.MaxMessages != 1 {
+			t.Errorf("Expected the chat's retention policy to survive reopen, got %+v", got)
+		}
+	})
+
+	t.Run("RecoversThreadWithExpiredRoot", func(t *testing.T) {
+		dir := t.TempDir()
+		s := openTestFileStore(t, dir)
+
+		chat, err := s.CreateChat("Expiring Thread")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+		root, err := s.AddMessage(chat.ID, "alice", "Root")
+		if err != nil {
+			t.Fatalf("Failed to add message: %v", err)
+		}
+		reply, err := s.Post(NewMessage{ChatID: chat.ID, Username: "bob", Content: "Reply", ReplyTo: root.ID})
+		if err != nil {
+			t.Fatalf("Failed to post reply: %v", err)
+		}
+		if _, err := s.ApplyRetention(models.RetentionPolicy{MaxMessages: 1}, time.Now()); err != nil {
+			t.Fatalf("Failed to apply retention: %v", err)
+		}
+		nested, err := s.Post(NewMessage{ChatID: chat.ID, Username: "alice", Content: "Nested", ReplyTo: reply.ID})
+		if err != nil {
+			t.Fatalf("Failed to reply to the surviving reply: %v", err)
+		}
+		if nested.ThreadID != root.ID {
+			t.Errorf("Expected the reply to stay in thread %s, got %s", root.ID, nested.ThreadID)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatalf("Failed to close store: %v", err)
+		}
+
+		s = openTestFileStore(t, dir)
+		defer func() { closeTestFileStore(t, s) }()
+
+		thread, err := s.GetThread(reply.ID)
+		if err != nil {
+			t.Fatalf("Failed to get thread: %v", err)
+		}
+		if len(thread) != 2 || thread[0].ID != reply.ID || thread[1].ID != nested.ID {
+			t.Errorf("Expected the remaining replies as the thread, got %+v", thread)
+		}
+
+		// The same holds when the thread is restored from a snapshot
+		if err := s.Snapshot(); err != nil {
+			t.Fatalf("Failed to snapshot: %v", err)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatalf("Failed to close store: %v", err)
+		}
+		s = openTestFileStore(t, dir)
+		if thread, _ := s.GetThread(nested.ID); len(thread) != 2 {
+			t.Errorf("Expected the thread to survive a snapshot, got %+v", thread)
+		}
+	})
+
+	t.Run("RecoversSearchIndex", func(t *testing.T) {
+		dir := t.TempDir()
+		s := openTestFileStore(t, dir)
+
+		chat, err := s.CreateChat("Searchable")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+		before, err := s.AddMessage(chat.ID, "alice", "Release notes are up")
+		if err != nil {
+			t.Fatalf("Failed to add message: %v", err)
+		}
+		if err := s.Snapshot(); err != nil {
+			t.Fatalf("Failed to snapshot: %v", err)
+		}
+		after, err := s.AddMessage(chat.ID, "bob", "Release is out")
+		if err != nil {
+			t.Fatalf("Failed to add message: %v", err)
+		}
+		if _, err := s.EditMessage(before.ID, "Changelog is up"); err != nil {
+			t.Fatalf("Failed to edit message: %v", err)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatalf("Failed to close store: %v", err)
+		}
+
+		s = openTestFileStore(t, dir)
+		defer closeTestFileStore(t, s)
+
+		results := s.Search(SearchQuery{Text: "release", ChatIDs: []string{chat.ID}})
+		if len(results) != 1 || results[0].Message.ID != after.ID {
+			t.Errorf("Expected only the unedited message to match, got %+v", results)
+		}
+		if results := s.Search(SearchQuery{Text: "changelog", ChatIDs: []string{chat.ID}}); len(results) != 1 {
+			t.Errorf("Expected the edited message to match its new content, got %+v", results)
+		}
+	})
+
+	t.Run("TruncatedFinalRecord", func(t *testing.T) {
+		dir := t.TempDir()
+		s := openTestFileStore(t, dir)
+
+		chat, _ := s.CreateChat("Crash Chat")
+		if _, err := s.AddMessage(chat.ID, "user", "kept"); err != nil {
+			t.Fatalf("Failed to add message: %v", err)
+		}
+		if _, err := s.AddMessage(chat.ID, "user", "torn"); err != nil {
+			t.Fatalf("Failed to add message: %v", err)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatalf("Failed to close store: %v", err)
+		}
+
+		// Simulate a crash in the middle of writing the last record
+		path := filepath.Join(dir, segmentName(1))
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Failed to stat log: %v", err)
+		}
+		if err := os.Truncate(path, info.Size()-5); err != nil {
+			t.Fatalf("Failed to truncate log: %v", err)
+		}
+
+		s = openTestFileStore(t, dir)
+		stats := s.Recovered()
+		if stats.Records != 2 {
+			t.Errorf("Expected 2 recovered records, got %d", stats.Records)
+		}
+		if stats.TruncatedBytes == 0 {
+			t.Error("Expected truncated bytes to be reported")
+		}
+
+		messages, _ := s.GetMessages(chat.ID)
+		if len(messages) != 1 || messages[0].Content != "kept" {
+			t.Fatalf("Expected only the complete message to survive, got %d messages", len(messages))
+		}
+
+		// The log must accept new records after the torn one was dropped
+		if _, err := s.AddMessage(chat.ID, "user", "after"); err != nil {
+			t.Fatalf("Failed to add message after recovery: %v", err)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatalf("Failed to close store: %v", err)
+		}
+
+		s = openTestFileStore(t, dir)
+		defer closeTestFileStore(t, s)
+		if got := s.Recovered().Records; got != 3 {
+			t.Errorf("Expected 3 recovered records, got %d", got)
+		}
+	})
+
+	t.Run("FailedWrite", func(t *testing.T) {
+		dir := t.TempDir()
+		s := openTestFileStore(t, dir)
+
+		chat, _ := s.CreateChat("Full Disk")
+		s.wal.file = &failingFile{segmentFile: s.wal.file, failWrite: true}
+		if _, err := s.AddMessage(chat.ID, "user", "torn"); err == nil {
+			t.Fatal("Expected the failed write to be reported")
+		}
+		if _, err := s.AddMessage(chat.ID, "user", "after"); err != nil {
+			t.Fatalf("Failed to add message after the failed write: %v", err)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatalf("Failed to close store: %v", err)
+		}
+
+		// The torn frame was cut off, so the log replays cleanly
+		s = openTestFileStore(t, dir)
+		defer closeTestFileStore(t, s)
+		if stats := s.Recovered(); stats.Records != 2 || stats.TruncatedBytes != 0 {
+			t.Errorf("Expected 2 clean records, got %+v", stats)
+		}
+		messages, _ := s.GetMessages(chat.ID)
+		if len(messages) != 1 || messages[0].Content != "after" {
+			t.Errorf("Expected only the later message, got %+v", messages)
+		}
+	})
+
+	t.Run("FailedWriteNotUndone", func(t *testing.T) {
+		dir := t.TempDir()
+		s := openTestFileStore(t, dir)
+
+		chat, _ := s.CreateChat("Broken Disk")
+		if _, err := s.AddMessage(chat.ID, "user", "kept"); err != nil {
+			t.Fatalf("Failed to add message: %v", err)
+		}
+		s.wal.file = &failingFile{segmentFile: s.wal.file, failWrite: true, failTruncate: true}
+		if _, err := s.AddMessage(chat.ID, "user", "torn"); err == nil {
+			t.Fatal("Expected the failed write to be reported")
+		}
+		// Nothing may follow the torn frame
+		if _, err := s.AddMessage(chat.ID, "user", "refused"); err == nil {
+			t.Error("Expected later writes to be refused")
+		}
+		if err := s.Snapshot(); err == nil {
+			t.Error("Expected the log not to rotate")
+		}
+		if err := s.Close(); err != nil {
+			t.Fatalf("Failed to close store: %v", err)
+		}
+
+		// The torn frame is the last one, which recovery tolerates
+		s = openTestFileStore(t, dir)
+		defer closeTestFileStore(t, s)
+		messages, _ := s.GetMessages(chat.ID)
+		if len(messages) != 1 || messages[0].Content != "kept" {
+			t.Errorf("Expected only the message before the failure, got %+v", messages)
+		}
+	})
+
+	t.Run("CorruptRecordInMiddle", func(t *testing.T) {
+		dir := t.TempDir()
+		s := openTestFileStore(t, dir)
+
+		chat, _ := s.CreateChat("Corrupt Chat")
+		if _, err := s.AddMessage(chat.ID, "user", "message"); err != nil {
+			t.Fatalf("Failed to add message: %v", err)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatalf("Failed to close store: %v", err)
+		}
+
+		path := filepath.Join(dir, segmentName(1))
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read log: %v", err)
+		}
+		data[frameHeaderSize+2] ^= 0xff
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatalf("Failed to write log: %v", err)
+		}
+
+		if _, err := OpenFileStore(dir, FileOptions{}); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("Expected ErrCorrupt, got %v", err)
+		}
+	})
+
+	t.Run("CorruptRecordInOlderSegment", func(t *testing.T) {
+		dir := t.TempDir()
+		s := openTestFileStore(t, dir)
+
+		chat, _ := s.CreateChat("Segment Chat")
+		if _, err := s.wal.rotate(); err != nil {
+			t.Fatalf("Failed to rotate log: %v", err)
+		}
+		if _, err := s.AddMessage(chat.ID, "user", "message"); err != nil {
+			t.Fatalf("Failed to add message: %v", err)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatalf("Failed to close store: %v", err)
+		}
+
+		// A torn record is only tolerated at the end of the newest segment
+		path := filepath.Join(dir, segmentName(1))
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Failed to stat log: %v", err)
+		}
+		if err := os.Truncate(path, info.Size()-5); err != nil {
+			t.Fatalf("Failed to truncate log: %v", err)
+		}
+
+		if _, err := OpenFileStore(dir, FileOptions{}); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("Expected ErrCorrupt, got %v", err)
+		}
+	})
+
+	t.Run("AdoptsLegacyLog", func(t *testing.T) {
+		dir := t.TempDir()
+		s := openTestFileStore(t, dir)
+		if _, err := s.CreateChat("Legacy Chat"); err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatalf("Failed to close store: %v", err)
+		}
+		if err := os.Rename(filepath.Join(dir, segmentName(1)), filepath.Join(dir, legacyWALFileName)); err != nil {
+			t.Fatalf("Failed to rename log: %v", err)
+		}
+
+		s = openTestFileStore(t, dir)
+		defer closeTestFileStore(t, s)
+		if chats := s.ListChats(); len(chats) != 1 {
+			t.Errorf("Expected 1 chat from legacy log, got %d", len(chats))
+		}
+	})
+
+	t.Run("ReplaysRenameRecords", func(t *testing.T) {
+		dir := t.TempDir()
+		s := openTestFileStore(t, dir)
+		chat, err := s.CreateChat("Old Name")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+		// Older versions logged renames with their own record
+		renamed := *chat
+		renamed.Name = "New Name"
+		if err := s.wal.append(&record{Op: opRenameChat, Chat: &renamed}); err != nil {
+			t.Fatalf("Failed to append rename: %v", err)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatalf("Failed to close store: %v", err)
+		}
+
+		s = openTestFileStore(t, dir)
+		defer closeTestFileStore(t, s)
+		if got, _ := s.GetChat(chat.ID); got == nil || got.Name != "New Name" {
+			t.Errorf("Expected the logged rename to be replayed, got %+v", got)
+		}
+	})
+
+	t.Run("WriteAfterClose", func(t *testing.T) {
+		s := openTestFileStore(t, t.TempDir())
+		if err := s.Close(); err != nil {
+			t.Fatalf("Failed to close store: %v", err)
+		}
+
+		if _, err := s.CreateChat("Too Late"); !errors.Is(err, ErrClosed) {
+			t.Errorf("Expected ErrClosed, got %v", err)
+		}
+		if chats := s.ListChats(); len(chats) != 0 {
+			t.Errorf("Expected failed write to leave no chat, got %d", len(chats))
+		}
+	})
+
+	t.Run("ParseSyncPolicy", func(t *testing.T) {
+		for _, name := range []string{"always", "interval", "never"} {
+			if _, err := ParseSyncPolicy(name); err != nil {
+				t.Errorf("Expected %q to parse: %v", name, err)
+			}
+		}
+		if _, err := ParseSyncPolicy("sometimes"); err == nil {
+			t.Error("Expected error for unknown policy")
+		}
+	})
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"time"
+
+	"chat-app/internal/models"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrInviteNotFound is returned when no invite has the given token
+	ErrInviteNotFound = newError(ErrNotFound, "invite not found")
+	// ErrInviteExpired is returned when redeeming an invite that has
+	// expired or been used up
+	ErrInviteExpired = newError(ErrConflict, "invite expired or used up")
+)
+
+// StoredInvite is an invite together with the hash of its token
+type StoredInvite struct {
+	Invite    *models.Invite `json:"invite"`
+	TokenHash string         `json:"token_hash"`
+}
+
+// usable reports whether the invite may still be redeemed at now
+func (inv *StoredInvite) usable(now time.Time) bool {
+	if inv.Invite.ExpiresAt != nil && !now.Before(*inv.Invite.ExpiresAt) {
+		return false
+	}
+	return inv.Invite.MaxUses == 0 || inv.Invite.Uses < inv.Invite.MaxUses
+}
+
+// CreateInvite stores a new invite identified by the hash of its token. The
+// ID and creation time of invite are assigned here.
+func (s *Storage) CreateInvite(tokenHash string, invite models.Invite) (*models.Invite, error) {
+	defer s.lock("CreateInvite")()
+
+	if _, exists := s.chats[invite.ChatID]; !exists {
+		return nil, ErrChatNotFound
+	}
+
+	invite.ID = uuid.New().String()
+	invite.CreatedAt = time.Now()
+	invite.Uses = 0
+	stored := &StoredInvite{Invite: &invite, TokenHash: tokenHash}
+	if err := s.commit(&record{Op: opCreateInvite, Invite: stored}); err != nil {
+		return nil, err
+	}
+	return stored.Invite, nil
+}
+
+// RedeemInvite makes username a member of the invite's chat and counts the
+// use. Users who already belong to the chat keep their membership, which is
+// returned without using up the invite.
+func (s *Storage) RedeemInvite(tokenHash, username string) (*models.Member, error) {
+	defer s.lock("RedeemInvite")()
+
+	stored, exists := s.invites[tokenHash]
+	if !exists {
+		return nil, ErrInviteNotFound
+	}
+	account, exists := s.accounts[accountKey(username)]
+	if !exists {
+		return nil, ErrUserNotFound
+	}
+	chatID := stored.Invite.ChatID
+	if member, exists := s.members[chatID][accountKey(username)]; exists {
+		return member, nil
+	}
+	now := time.Now()
+	if !stored.usable(now) {
+		return nil, ErrInviteExpired
+	}
+
+	// Invites are replaced rather than modified in place
+	invite := *stored.Invite
+	invite.Uses++
+	member := &models.Member{
+		ChatID:   chatID,
+		Username: account.User.Username,
+		Role:     models.RoleMember,
+		JoinedAt: now,
+	}
+	rec := &record{
+		Op:     opRedeemInvite,
+		Invite: &StoredInvite{Invite: &invite, TokenHash: tokenHash},
+		Member: member,
+	}
+	if err := s.commit(rec); err != nil {
+		return nil, err
+	}
+	return member, nil
+}
@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"sort"
+	"time"
+
+	"chat-app/internal/models"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrChatNotFound is returned when a chat does not exist
+	ErrChatNotFound = newError(ErrNotFound, "chat not found")
+	// ErrMemberNotFound is returned when a user is not a member of a chat
+	ErrMemberNotFound = newError(ErrNotFound, "member not found")
+)
+
+// CreateChatWithOwner creates a new chat with owner as its first member,
+// holding the owner role. An empty visibility makes the chat public.
+func (s *Storage) CreateChatWithOwner(name, owner, visibility string) (*models.Chat, error) {
+	defer s.lock("CreateChatWithOwner")()
+
+	account, exists := s.accounts[accountKey(owner)]
+	if !exists {
+		return nil, ErrUserNotFound
+	}
+
+	now := time.Now()
+	if visibility == "" {
+		visibility = models.VisibilityPublic
+	}
+	chat := &models.Chat{
+		ID:         uuid.New().String(),
+		Name:       name,
+		CreatedAt:  now,
+		Visibility: visibility,
+	}
+	member := &models.Member{
+		ChatID:   chat.ID,
+		Username: account.User.Username,
+		Role:     models.RoleOwner,
+		JoinedAt: now,
+	}
+	if err := s.commit(&record{Op: opCreateChat, Chat: chat, Member: member}); err != nil {
+		return nil, err
+	}
+	return chat, nil
+}
+
+// GetMember retrieves a user's membership of a chat
+func (s *Storage) GetMember(chatID, username string) (*models.Member, bool) {
+	defer s.rlock("GetMember")()
+
+	member, exists := s.members[chatID][accountKey(username)]
+	return member, exists
+}
+
+// ListMembers returns the members of a chat in the order they joined
+func (s *Storage) ListMembers(chatID string) ([]*models.Member, error) {
+	defer s.rlock("ListMembers")()
+
+	if _, exists := s.chats[chatID]; !exists {
+		return nil, ErrChatNotFound
+	}
+	members := make([]*models.Member, 0, len(s.members[chatID]))
+	for _, member := range s.members[chatID] {
+		members = append(members, member)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if !members[i].JoinedAt.Equal(members[j].JoinedAt) {
+			return members[i].JoinedAt.Before(members[j].JoinedAt)
+		}
+		return members[i].Username < members[j].Username
+	})
+	return members, nil
+}
+
+// SetMember adds a registered user to a chat with role, or changes the role
+// of an existing member
+func (s *Storage) SetMember(chatID, username, role string) (*models.Member, error) {
+	defer s.lock("SetMember")()
+
+	if _, exists := s.chats[chatID]; !exists {
+		return nil, ErrChatNotFound
+	}
+	account, exists := s.accounts[accountKey(username)]
+	if !exists {
+		return nil, ErrUserNotFound
+	}
+
+	member := &models.Member{
+		ChatID:   chatID,
+		Username: account.User.Username,
+		Role:     role,
+		JoinedAt: time.Now(),
+	}
+	// Members are replaced rather than modified in place
+	if existing, exists := s.members[chatID][accountKey(username)]; exists {
+		member.JoinedAt = existing.JoinedAt
+	}
+	if err := s.commit(&record{Op: opSetMember, Member: member}); err != nil {
+		return nil, err
+	}
+	return member, nil
+}
+
+// RemoveMember removes a user from a chat
+func (s *Storage) RemoveMember(chatID, username string) error {
+	defer s.lock("RemoveMember")()
+
+	if _, exists := s.chats[chatID]; !exists {
+		return ErrChatNotFound
+	}
+	member, exists := s.members[chatID][accountKey(username)]
+	if !exists {
+		return ErrMemberNotFound
+	}
+	return s.commit(&record{Op: opRemoveMember, Member: member})
+}
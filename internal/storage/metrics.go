@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"time"
+
+	"chat-app/internal/metrics"
+)
+
+// lockBuckets are the histogram buckets for lock waits, which are mostly
+// far shorter than requests
+var lockBuckets = []float64{.000001, .00001, .0001, .001, .01, .1, 1}
+
+// storageMetrics times the operations of a Storage and its lock waits
+type storageMetrics struct {
+	operations *metrics.HistogramVec
+	lockWait   *metrics.HistogramVec
+	readWait   *metrics.Histogram
+	writeWait  *metrics.Histogram
+}
+
+func newStorageMetrics() *storageMetrics {
+	m := &storageMetrics{
+		operations: metrics.NewHistogramVec("chat_storage_operation_duration_seconds",
+			"Time storage operations took, including waiting for the lock.", metrics.DefaultBuckets, "op"),
+		lockWait: metrics.NewHistogramVec("chat_storage_lock_wait_seconds",
+			"Time storage operations waited for the lock.", lockBuckets, "mode"),
+	}
+	m.readWait = m.lockWait.With("read")
+	m.writeWait = m.lockWait.With("write")
+	return m
+}
+
+// lock takes the write lock for the operation op. The returned function
+// releases it and records how long op took.
+func (s *Storage) lock(op string) (unlock func()) {
+	start := time.Now()
+	s.mu.Lock()
+	s.metrics.writeWait.ObserveDuration(time.Since(start))
+	return func() {
+		s.mu.Unlock()
+		s.metrics.operations.With(op).ObserveDuration(time.Since(start))
+	}
+}
+
+// rlock is lock for operations that only read
+func (s *Storage) rlock(op string) (unlock func()) {
+	start := time.Now()
+	s.mu.RLock()
+	s.metrics.readWait.ObserveDuration(time.Since(start))
+	return func() {
+		s.mu.RUnlock()
+		s.metrics.operations.With(op).ObserveDuration(time.Since(start))
+	}
+}
+
+// counts returns the number of chats and stored messages
+func (s *Storage) counts() (chats, messages int) {
+	defer s.rlock("counts")()
+	return len(s.chats), len(s.byID)
+}
+
+// RegisterMetrics adds the storage's metrics to reg: operation latencies,
+// lock waits and the number of chats and messages
+func (s *Storage) RegisterMetrics(reg *metrics.Registry) {
+	reg.MustRegister(
+		s.metrics.operations,
+		s.metrics.lockWait,
+		metrics.NewGaugeFunc("chat_chats", "Chats stored.", func() float64 {
+			chats, _ := s.counts()
+			return float64(chats)
+		}),
+		metrics.NewGaugeFunc("chat_messages", "Messages stored, including deleted ones.", func() float64 {
+			_, messages := s.counts()
+			return float64(messages)
+		}),
+	)
+}
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"sort"
+
+	"chat-app/internal/models"
+)
+
+// ErrInvalidCursor is returned when a page cursor does not name a message in
+// the chat being paged
+var ErrInvalidCursor = newError(ErrValidation, "storage: invalid cursor")
+
+// PageQuery selects a page of messages. Cursors are message IDs and are
+// exclusive. With no lower bound (After or HasAfterSeq) set, the newest
+// messages are returned.
+type PageQuery struct {
+	// Limit is the maximum number of messages to return
+	Limit int
+	// Before selects the newest messages older than this message
+	Before string
+	// After selects the oldest messages newer than this message
+	After string
+	// AfterSeq selects the oldest messages with a sequence number greater
+	// than this one if HasAfterSeq is set. 0 then pages from the start.
+	AfterSeq    int64
+	HasAfterSeq bool
+}
+
+// MessagePage is a window of a chat's messages in chronological order
+type MessagePage struct {
+	Messages []*models.Message
+	// HasOlder reports whether messages exist before the first one returned
+	HasOlder bool
+	// HasNewer reports whether messages exist after the last one returned
+	HasNewer bool
+}
+
+// GetMessagePage returns a page of messages for a chat, copying only the
+// messages in the page
+func (s *Storage) GetMessagePage(chatID string, query PageQuery) (*MessagePage, error) {
+	defer s.rlock("GetMessagePage")()
+
+	messages, exists := s.messages[chatID]
+	if !exists {
+		return nil, ErrChatNotFound
+	}
+
+	// [start, end) is the candidate range before applying the limit
+	start, end := 0, len(messages)
+	if query.After != "" {
+		i, ok := s.cursorIndex(chatID, query.After)
+		if !ok {
+			return nil, ErrInvalidCursor
+		}
+		start = i + 1
+	}
+	if query.HasAfterSeq {
+		start = max(start, indexOfSeq(messages, query.AfterSeq+1))
+	}
+	if query.Before != "" {
+		i, ok := s.cursorIndex(chatID, query.Before)
+		if !ok {
+			return nil, ErrInvalidCursor
+		}
+		end = i
+	}
+	if end < start {
+		end = start
+	}
+
+	if query.Limit > 0 && end-start > query.Limit {
+		if query.After != "" || query.HasAfterSeq {
+			end = start + query.Limit
+		} else {
+			start = end - query.Limit
+		}
+	}
+
+	page := &MessagePage{
+		Messages: make([]*models.Message, end-start),
+		HasOlder: start > 0,
+		HasNewer: end < len(messages),
+	}
+	copy(page.Messages, messages[start:end])
+	return page, nil
+}
+
+// cursorIndex returns the position of a cursor message within a chat. The
+// caller must hold the lock.
+func (s *Storage) cursorIndex(chatID, messageID string) (int, bool) {
+	msg, ok := s.byID[messageID]
+	if !ok || msg.ChatID != chatID {
+		return 0, false
+	}
+	messages := s.messages[chatID]
+	i := indexOfSeq(messages, msg.Seq)
+	if i >= len(messages) || messages[i].ID != messageID {
+		return 0, false
+	}
+	return i, true
+}
+
+// indexOfSeq returns the index of the first message with a sequence number
+// of at least seq. Messages are stored in sequence order.
+func indexOfSeq(messages []*models.Message, seq int64) int {
+	return sort.Search(len(messages), func(i int) bool {
+		return messages[i].Seq >= seq
+	})
+}
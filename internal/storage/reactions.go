@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"chat-app/internal/models"
+)
+
+// ErrReactionNotFound is returned when removing a reaction that was not made
+var ErrReactionNotFound = newError(ErrNotFound, "reaction not found")
+
+// AddReaction records username's reaction to a message with emoji and
+// returns the message with its updated counts. Reacting twice with the same
+// emoji changes nothing.
+func (s *Storage) AddReaction(messageID, username, emoji string) (*models.Message, error) {
+	defer s.lock("AddReaction")()
+
+	msg, exists := s.byID[messageID]
+	if !exists {
+		return nil, ErrMessageNotFound
+	}
+	if msg.DeletedAt != nil {
+		return nil, ErrMessageDeleted
+	}
+	if s.findReaction(messageID, username, emoji) >= 0 {
+		return msg, nil
+	}
+
+	reaction := &models.Reaction{
+		MessageID: messageID,
+		Username:  username,
+		Emoji:     emoji,
+		CreatedAt: time.Now(),
+	}
+	if err := s.commit(&record{Op: opAddReaction, Reaction: reaction}); err != nil {
+		return nil, err
+	}
+	return s.byID[messageID], nil
+}
+
+// RemoveReaction takes back username's reaction to a message with emoji and
+// returns the message with its updated counts
+func (s *Storage) RemoveReaction(messageID, username, emoji string) (*models.Message, error) {
+	defer s.lock("RemoveReaction")()
+
+	if _, exists := s.byID[messageID]; !exists {
+		return nil, ErrMessageNotFound
+	}
+	i := s.findReaction(messageID, username, emoji)
+	if i < 0 {
+		return nil, ErrReactionNotFound
+	}
+
+	if err := s.commit(&record{Op: opRemoveReaction, Reaction: s.reactions[messageID][i]}); err != nil {
+		return nil, err
+	}
+	return s.byID[messageID], nil
+}
+
+// ListReactions returns the reactions to a message, oldest first
+func (s *Storage) ListReactions(messageID string) ([]*models.Reaction, error) {
+	defer s.rlock("ListReactions")()
+
+	if _, exists := s.byID[messageID]; !exists {
+		return nil, ErrMessageNotFound
+	}
+	return append([]*models.Reaction{}, s.reactions[messageID]...), nil
+}
+
+// findReaction returns the index of username's reaction to a message with
+// emoji, or -1. Usernames are compared ignoring case.
+func (s *Storage) findReaction(messageID, username, emoji string) int {
+	return slices.IndexFunc(s.reactions[messageID], func(r *models.Reaction) bool {
+		return r.Emoji == emoji && strings.EqualFold(r.Username, username)
+	})
+}
+
+// removeReaction drops a reaction from its message. The caller must hold
+// the write lock.
+func (s *Storage) removeReaction(reaction *models.Reaction) {
+	if i := s.findReaction(reaction.MessageID, reaction.Username, reaction.Emoji); i >= 0 {
+		s.reactions[reaction.MessageID] = slices.Delete(s.reactions[reaction.MessageID], i, i+1)
+	}
+	if len(s.reactions[reaction.MessageID]) == 0 {
+		delete(s.reactions, reaction.MessageID)
+	}
+}
+
+// countReactions replaces a message with a copy carrying fresh reaction
+// counts. The caller must hold the write lock.
+func (s *Storage) countReactions(messageID string) {
+	msg, exists := s.byID[messageID]
+	if !exists {
+		return
+	}
+	counted := *msg
+	counted.Reactions = nil
+	for _, reaction := range s.reactions[messageID] {
+		if counted.Reactions == nil {
+			counted.Reactions = make(map[string]int)
+		}
+		counted.Reactions[reaction.Emoji]++
+	}
+	s.replaceMessage(&counted)
+}
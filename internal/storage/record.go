@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"fmt"
+
+	"chat-app/internal/models"
+)
+
+// Record operations
+const (
+	opCreateChat     = "create_chat"
+	opUpdateChat     = "update_chat"
+	opDeleteChat     = "delete_chat"
+	opSetMember      = "set_member"
+	opRemoveMember   = "remove_member"
+	opAddMessage     = "add_message"
+	opEditMessage    = "edit_message"
+	opDeleteMessage  = "delete_message"
+	opExpireMessages = "expire_messages"
+	opAddReaction    = "add_reaction"
+	opRemoveReaction = "remove_reaction"
+	opCreateUser     = "create_user"
+	opCreateSession  = "create_session"
+	opCreateAPIKey   = "create_api_key"
+	opRevokeAPIKey   = "revoke_api_key"
+	opCreateInvite   = "create_invite"
+	opRedeemInvite   = "redeem_invite"
+)
+
+// opRenameChat is only replayed from older logs; renames are now written as
+// chat updates
+const opRenameChat = "rename_chat"
+
+// record describes a single mutation of the store. Records are what the
+// write-ahead log persists and what replay feeds back into apply.
+type record struct {
+	Op      string          `json:"op"`
+	Chat    *models.Chat    `json:"chat,omitempty"`
+	Message *models.Message `json:"message,omitempty"`
+	Member  *models.Member  `json:"member,omitempty"`
+	Account *Account        `json:"account,omitempty"`
+	Session *Session        `json:"session,omitempty"`
+	APIKey  *StoredAPIKey   `json:"api_key,omitempty"`
+	Invite  *StoredInvite   `json:"invite,omitempty"`
+
+	// Members are the participants of a new direct chat
+	Members []*models.Member `json:"members,omitempty"`
+	// Revision is the version of a message replaced by an edit
+	Revision *models.Revision `json:"revision,omitempty"`
+	// Reaction is the reaction added or removed
+	Reaction *models.Reaction `json:"reaction,omitempty"`
+	// Expired holds the tombstones of the messages removed by retention
+	Expired []*models.Message `json:"expired,omitempty"`
+}
+
+// validate checks that a record decoded from disk carries the payload its
+// operation requires
+func (r *record) validate() error {
+	switch r.Op {
+	case opCreateChat, opRenameChat, opUpdateChat, opDeleteChat:
+		if r.Chat == nil {
+			return fmt.Errorf("%s record without chat", r.Op)
+		}
+	case opExpireMessages:
+		if r.Chat == nil || len(r.Expired) == 0 {
+			return fmt.Errorf("%s record without chat and messages", r.Op)
+		}
+	case opAddMessage, opDeleteMessage:
+		if r.Message == nil {
+			return fmt.Errorf("%s record without message", r.Op)
+		}
+	case opEditMessage:
+		if r.Message == nil || r.Revision == nil {
+			return fmt.Errorf("%s record without message and revision", r.Op)
+		}
+	case opAddReaction, opRemoveReaction:
+		if r.Reaction == nil {
+			return fmt.Errorf("%s record without reaction", r.Op)
+		}
+	case opSetMember, opRemoveMember:
+		if r.Member == nil {
+			return fmt.Errorf("%s record without member", r.Op)
+		}
+	case opCreateUser:
+		if r.Account == nil || r.Account.User == nil {
+			return fmt.Errorf("%s record without account", r.Op)
+		}
+	case opCreateSession:
+		if r.Session == nil {
+			return fmt.Errorf("%s record without session", r.Op)
+		}
+	case opCreateAPIKey, opRevokeAPIKey:
+		if r.APIKey == nil || r.APIKey.Key == nil {
+			return fmt.Errorf("%s record without API key", r.Op)
+		}
+	case opCreateInvite:
+		if r.Invite == nil || r.Invite.Invite == nil {
+			return fmt.Errorf("%s record without invite", r.Op)
+		}
+	case opRedeemInvite:
+		if r.Invite == nil || r.Invite.Invite == nil || r.Member == nil {
+			return fmt.Errorf("%s record without invite and member", r.Op)
+		}
+	default:
+		return fmt.Errorf("unknown record op %q", r.Op)
+	}
+	return nil
+}
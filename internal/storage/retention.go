@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"time"
+
+	"chat-app/internal/models"
+)
+
+// SetRetention gives a chat its own retention policy in place of the
+// server's. A nil policy makes the chat follow the server's again.
+func (s *Storage) SetRetention(chatID string, policy *models.RetentionPolicy) (*models.Chat, error) {
+	defer s.lock("SetRetention")()
+
+	chat, exists := s.chats[chatID]
+	if !exists {
+		return nil, ErrChatNotFound
+	}
+	updated := *chat
+	updated.Retention = policy
+	if err := s.commit(&record{Op: opUpdateChat, Chat: &updated}); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// ApplyRetention removes the messages that have expired at now under each
+// chat's retention policy, or under defaults for chats without one. Chats
+// under legal hold are skipped. It returns the tombstones of the removed
+// messages, which subscribers receive as deletions.
+func (s *Storage) ApplyRetention(defaults models.RetentionPolicy, now time.Time) ([]*models.Message, error) {
+	defer s.lock("ApplyRetention")()
+
+	var expired []*models.Message
+	for chatID, chat := range s.chats {
+		if chat.LegalHold {
+			continue
+		}
+		policy := defaults
+		if chat.Retention != nil {
+			policy = *chat.Retention
+		}
+		messages := s.messages[chatID]
+		cut := 0
+		if policy.MaxMessages > 0 {
+			cut = max(cut, len(messages)-policy.MaxMessages)
+		}
+		if policy.MaxAge > 0 {
+			cutoff := now.Add(-time.Duration(policy.MaxAge))
+			for cut < len(messages) && messages[cut].Timestamp.Before(cutoff) {
+				cut++
+			}
+		}
+		if cut == 0 {
+			continue
+		}
+
+		tombstones := make([]*models.Message, cut)
+		for i, msg := range messages[:cut] {
+			tombstones[i] = &models.Message{
+				ID:        msg.ID,
+				ChatID:    msg.ChatID,
+				Seq:       msg.Seq,
+				Username:  msg.Username,
+				Timestamp: msg.Timestamp,
+				Bot:       msg.Bot,
+				DeletedAt: &now,
+				ReplyTo:   msg.ReplyTo,
+				ThreadID:  msg.ThreadID,
+			}
+		}
+		if err := s.commit(&record{Op: opExpireMessages, Chat: chat, Expired: tombstones}); err != nil {
+			return expired, err
+		}
+		expired = append(expired, tombstones...)
+	}
+	return expired, nil
+}
+
+// expireMessages removes messages of a chat together with everything kept
+// about them. The caller must hold the write lock.
+func (s *Storage) expireMessages(chatID string, expired []*models.Message) {
+	gone := make(map[string]bool, len(expired))
+	for _, msg := range expired {
+		gone[msg.ID] = true
+		if stored, exists := s.byID[msg.ID]; exists && stored.DeletedAt == nil {
+			s.live[chatID]--
+		}
+		delete(s.byID, msg.ID)
+		delete(s.revisions, msg.ID)
+		delete(s.reactions, msg.ID)
+		s.search.remove(msg.ID)
+	}
+	// Replies outlive the message that started their thread, so the thread
+	// is kept for as long as any of them remain
+	for _, msg := range expired {
+		threadID := threadOf(msg)
+		replies, exists := s.threads[threadID]
+		if !exists {
+			continue
+		}
+		kept := make([]string, 0, len(replies))
+		for _, id := range replies {
+			if !gone[id] {
+				kept = append(kept, id)
+			}
+		}
+		if len(kept) == 0 {
+			delete(s.threads, threadID)
+		} else {
+			s.threads[threadID] = kept
+		}
+	}
+	// A new slice lets the memory of the old one be reclaimed
+	messages := s.messages[chatID]
+	kept := make([]*models.Message, 0, len(messages)-len(gone))
+	for _, msg := range messages {
+		if !gone[msg.ID] {
+			kept = append(kept, msg)
+		}
+	}
+	s.messages[chatID] = kept
+}
@@ -0,0 +1,237 @@
+package storage
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"chat-app/internal/models"
+)
+
+// HighlightMarker is placed on both sides of every matching word in the
+// highlight of a search result
+const HighlightMarker = "**"
+
+// SearchQuery selects the messages returned by Search
+type SearchQuery struct {
+	// Text holds the words to look for. A message matches if it has a
+	// word starting with each of them, ignoring case.
+	Text string
+	// ChatIDs limits the search to these chats. It is required: with no
+	// chats nothing is found.
+	ChatIDs []string
+	// Username, if set, limits the search to messages by this user
+	Username string
+	// From and To, if set, limit the search to messages posted at or after
+	// From and before To
+	From, To time.Time
+	// Limit is the maximum number of results, or unlimited if zero
+	Limit int
+}
+
+// searchIndex is an inverted index from words to the messages containing
+// them. Deleted messages are not indexed.
+type searchIndex struct {
+	postings map[string]map[string]int // word -> messageID -> occurrences
+	words    map[string][]string       // messageID -> distinct words, to unindex it
+
+	// vocab lists the indexed words in order, so that the words starting
+	// with a term are found by binary search. Words are added and removed
+	// by appending and marking it dirty; it is put back in order by the next
+	// search, which only holds the read lock, hence mu.
+	mu    sync.Mutex
+	vocab []string
+	dirty bool
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		postings: make(map[string]map[string]int),
+		words:    make(map[string][]string),
+	}
+}
+
+// tokenize splits text into lowercased words of letters and digits
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// add indexes msg, replacing what was indexed for it before
+func (idx *searchIndex) add(msg *models.Message) {
+	idx.remove(msg.ID)
+	if msg.DeletedAt != nil {
+		return
+	}
+	for _, word := range tokenize(msg.Content) {
+		postings, exists := idx.postings[word]
+		if !exists {
+			postings = make(map[string]int)
+			idx.postings[word] = postings
+			idx.vocab = append(idx.vocab, word)
+			idx.dirty = true
+		}
+		if postings[msg.ID] == 0 {
+			idx.words[msg.ID] = append(idx.words[msg.ID], word)
+		}
+		postings[msg.ID]++
+	}
+}
+
+// remove drops the message with the given ID from the index
+func (idx *searchIndex) remove(messageID string) {
+	for _, word := range idx.words[messageID] {
+		delete(idx.postings[word], messageID)
+		if len(idx.postings[word]) == 0 {
+			delete(idx.postings, word)
+			idx.dirty = true
+		}
+	}
+	delete(idx.words, messageID)
+	// Words no longer indexed stay in vocab until it is sorted; without
+	// searches to do that it is tidied here before it grows out of bounds
+	if len(idx.vocab) > 2*len(idx.postings)+1024 {
+		idx.sortedVocab()
+	}
+}
+
+// sortedVocab returns the indexed words in order, first sorting in the words
+// added and dropping those removed since it was last called
+func (idx *searchIndex) sortedVocab() []string {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if idx.dirty {
+		live := idx.vocab[:0]
+		for _, word := range idx.vocab {
+			if _, indexed := idx.postings[word]; indexed {
+				live = append(live, word)
+			}
+		}
+		slices.Sort(live)
+		// A word removed and added again is listed twice
+		idx.vocab = slices.Compact(live)
+		idx.dirty = false
+	}
+	return idx.vocab
+}
+
+// match scores the messages having a word that starts with term. Scores
+// follow tf-idf, with words that merely start with term counting half.
+func (idx *searchIndex) match(term string) map[string]float64 {
+	scores := make(map[string]float64)
+	total := float64(len(idx.words))
+	vocab := idx.sortedVocab()
+	start, _ := slices.BinarySearch(vocab, term)
+	for _, word := range vocab[start:] {
+		if !strings.HasPrefix(word, term) {
+			break
+		}
+		postings := idx.postings[word]
+		weight := math.Log(1 + total/float64(len(postings)))
+		if word != term {
+			weight /= 2
+		}
+		for messageID, count := range postings {
+			scores[messageID] += float64(count) * weight
+		}
+	}
+	return scores
+}
+
+// Search returns the messages matching every word of query.Text, best
+// matches first and newest first among equal ones
+func (s *Storage) Search(query SearchQuery) []*models.SearchResult {
+	defer s.rlock("Search")()
+
+	terms := tokenize(query.Text)
+	if len(terms) == 0 || len(query.ChatIDs) == 0 {
+		return []*models.SearchResult{}
+	}
+	slices.Sort(terms)
+	terms = slices.Compact(terms)
+	var scores map[string]float64
+	for _, term := range terms {
+		matched := s.search.match(term)
+		if scores == nil {
+			scores = matched
+			continue
+		}
+		for messageID, score := range scores {
+			if extra, ok := matched[messageID]; ok {
+				scores[messageID] = score + extra
+			} else {
+				delete(scores, messageID)
+			}
+		}
+	}
+
+	chats := make(map[string]bool, len(query.ChatIDs))
+	for _, chatID := range query.ChatIDs {
+		chats[chatID] = true
+	}
+	results := []*models.SearchResult{}
+	for messageID, score := range scores {
+		msg := s.byID[messageID]
+		if !chats[msg.ChatID] ||
+			query.Username != "" && !strings.EqualFold(msg.Username, query.Username) ||
+			!query.From.IsZero() && msg.Timestamp.Before(query.From) ||
+			!query.To.IsZero() && !msg.Timestamp.Before(query.To) {
+			continue
+		}
+		results = append(results, &models.SearchResult{Message: msg, Score: score})
+	}
+	slices.SortFunc(results, func(a, b *models.SearchResult) int {
+		if a.Score != b.Score {
+			if a.Score > b.Score {
+				return -1
+			}
+			return 1
+		}
+		if c := b.Message.Timestamp.Compare(a.Message.Timestamp); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Message.ID, b.Message.ID)
+	})
+	if query.Limit > 0 && len(results) > query.Limit {
+		results = results[:query.Limit]
+	}
+	for _, result := range results {
+		result.Highlight = highlight(result.Message.Content, terms)
+	}
+	return results
+}
+
+// highlight wraps the words of content that start with one of terms in
+// HighlightMarker
+func highlight(content string, terms []string) string {
+	var b strings.Builder
+	for len(content) > 0 {
+		r, size := utf8.DecodeRuneInString(content)
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			b.WriteString(content[:size])
+			content = content[size:]
+			continue
+		}
+		end := strings.IndexFunc(content, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		if end < 0 {
+			end = len(content)
+		}
+		word := content[:end]
+		lower := strings.ToLower(word)
+		if slices.ContainsFunc(terms, func(term string) bool { return strings.HasPrefix(lower, term) }) {
+			b.WriteString(HighlightMarker + word + HighlightMarker)
+		} else {
+			b.WriteString(word)
+		}
+		content = content[end:]
+	}
+	return b.String()
+}
@@ -0,0 +1,324 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"chat-app/internal/models"
+)
+
+// Snapshots are named by the first log segment they do not cover
+const (
+	snapshotPrefix = "snapshot-"
+	snapshotSuffix = ".snap"
+)
+
+// snapshotState is the full state of a Storage as written to a snapshot
+type snapshotState struct {
+	Chats    []*models.Chat               `json:"chats"`
+	Messages map[string][]*models.Message `json:"messages"`
+	LastSeq  map[string]int64             `json:"last_seq"`
+	Members  []*models.Member             `json:"members,omitempty"`
+	Accounts []*Account                   `json:"accounts,omitempty"`
+	Sessions []*Session                   `json:"sessions,omitempty"`
+	APIKeys  []*StoredAPIKey              `json:"api_keys,omitempty"`
+	Invites  []*StoredInvite              `json:"invites,omitempty"`
+
+	Revisions map[string][]*models.Revision `json:"revisions,omitempty"`
+	Reactions map[string][]*models.Reaction `json:"reactions,omitempty"`
+}
+
+func snapshotName(seq uint64) string {
+	return numberedName(snapshotPrefix, seq, snapshotSuffix)
+}
+
+// listSnapshots returns the sequence numbers of the snapshots in dir in
+// ascending order
+func listSnapshots(dir string) ([]uint64, error) {
+	return listNumbered(dir, snapshotPrefix, snapshotSuffix)
+}
+
+// captureState copies the current state for a snapshot. The caller must hold
+// the lock. Stored models are never modified in place, so copying the
+// slices is enough for the snapshot to be encoded after the lock is released.
+func (s *Storage) captureState() *snapshotState {
+	state := &snapshotState{
+		Chats:    make([]*models.Chat, 0, len(s.chats)),
+		Messages: make(map[string][]*models.Message, len(s.messages)),
+		LastSeq:  make(map[string]int64, len(s.lastSeq)),
+	}
+	for _, chat := range s.chats {
+		state.Chats = append(state.Chats, chat)
+	}
+	for chatID, messages := range s.messages {
+		state.Messages[chatID] = append([]*models.Message(nil), messages...)
+	}
+	for chatID, seq := range s.lastSeq {
+		state.LastSeq[chatID] = seq
+	}
+	for _, members := range s.members {
+		for _, member := range members {
+			state.Members = append(state.Members, member)
+		}
+	}
+	for _, account := range s.accounts {
+		state.Accounts = append(state.Accounts, account)
+	}
+	// Expired sessions are dropped rather than carried forward
+	now := time.Now()
+	for _, session := range s.sessions {
+		if !session.Expired(now) {
+			state.Sessions = append(state.Sessions, session)
+		}
+	}
+	for _, key := range s.apiKeys {
+		state.APIKeys = append(state.APIKeys, key)
+	}
+	for _, invite := range s.invites {
+		state.Invites = append(state.Invites, invite)
+	}
+	if len(s.revisions) > 0 {
+		state.Revisions = make(map[string][]*models.Revision, len(s.revisions))
+		for messageID, revisions := range s.revisions {
+			state.Revisions[messageID] = append([]*models.Revision(nil), revisions...)
+		}
+	}
+	if len(s.reactions) > 0 {
+		state.Reactions = make(map[string][]*models.Reaction, len(s.reactions))
+		for messageID, reactions := range s.reactions {
+			state.Reactions[messageID] = append([]*models.Reaction(nil), reactions...)
+		}
+	}
+	return state
+}
+
+// restoreState replaces the current state with a snapshot
+func (s *Storage) restoreState(state *snapshotState) error {
+	defer s.lock("restoreState")()
+
+	chats := make(map[string]*models.Chat, len(state.Chats))
+	messages := make(map[string][]*models.Message, len(state.Chats))
+	byID := make(map[string]*models.Message)
+	threads := make(map[string][]string)
+	search := newSearchIndex()
+	lastSeq := make(map[string]int64, len(state.Chats))
+	live := make(map[string]int, len(state.Chats))
+	directChats := make(map[string]string)
+	for _, chat := range state.Chats {
+		if chat == nil {
+			return errors.New("snapshot contains empty chat")
+		}
+		if chat.Visibility == models.VisibilityDirect {
+			if len(chat.Participants) != 2 {
+				return fmt.Errorf("snapshot has direct chat %s without two participants", chat.ID)
+			}
+			directChats[directKey(chat.Participants[0], chat.Participants[1])] = chat.ID
+		}
+		chats[chat.ID] = chat
+		messages[chat.ID] = []*models.Message{}
+		lastSeq[chat.ID] = state.LastSeq[chat.ID]
+	}
+	for chatID, list := range state.Messages {
+		if _, exists := chats[chatID]; !exists {
+			return fmt.Errorf("snapshot has messages for unknown chat %s", chatID)
+		}
+		seq := state.LastSeq[chatID]
+		for i, msg := range list {
+			if msg == nil || msg.ChatID != chatID {
+				return fmt.Errorf("snapshot has invalid message at %s[%d]", chatID, i)
+			}
+			// Snapshots taken before sequence numbers existed are
+			// numbered in stored order
+			if msg.Seq == 0 {
+				msg.Seq = int64(i) + 1
+			}
+			if i > 0 && msg.Seq <= list[i-1].Seq {
+				return fmt.Errorf("snapshot has out of order message at %s[%d]", chatID, i)
+			}
+			if msg.ThreadID != "" {
+				// The root of a thread may have expired before its
+				// replies
+				if root, exists := byID[msg.ThreadID]; exists && root.ChatID != chatID {
+					return fmt.Errorf("snapshot has reply %s in unknown thread %s", msg.ID, msg.ThreadID)
+				}
+				threads[msg.ThreadID] = append(threads[msg.ThreadID], msg.ID)
+			}
+			byID[msg.ID] = msg
+			if msg.DeletedAt == nil {
+				live[chatID]++
+			}
+			search.add(msg)
+			seq = max(seq, msg.Seq)
+		}
+		messages[chatID] = list
+		lastSeq[chatID] = seq
+	}
+
+	members := make(map[string]map[string]*models.Member, len(state.Chats))
+	for chatID := range chats {
+		members[chatID] = make(map[string]*models.Member)
+	}
+	for _, member := range state.Members {
+		if member == nil {
+			return errors.New("snapshot contains empty member")
+		}
+		if _, exists := chats[member.ChatID]; !exists {
+			return fmt.Errorf("snapshot has member of unknown chat %s", member.ChatID)
+		}
+		members[member.ChatID][accountKey(member.Username)] = member
+	}
+
+	accounts := make(map[string]*Account, len(state.Accounts))
+	for _, account := range state.Accounts {
+		if account == nil || account.User == nil {
+			return errors.New("snapshot contains empty account")
+		}
+		accounts[accountKey(account.User.Username)] = account
+	}
+	sessions := make(map[string]*Session, len(state.Sessions))
+	for _, session := range state.Sessions {
+		if session == nil {
+			return errors.New("snapshot contains empty session")
+		}
+		if _, exists := accounts[accountKey(session.Username)]; !exists {
+			return fmt.Errorf("snapshot has session for unknown user %s", session.Username)
+		}
+		sessions[session.TokenHash] = session
+	}
+	apiKeys := make(map[string]*StoredAPIKey, len(state.APIKeys))
+	keyIDs := make(map[string]string, len(state.APIKeys))
+	botOwners := make(map[string]string)
+	for _, stored := range state.APIKeys {
+		if stored == nil || stored.Key == nil {
+			return errors.New("snapshot contains empty API key")
+		}
+		apiKeys[stored.Key.ID] = stored
+		keyIDs[stored.TokenHash] = stored.Key.ID
+		botOwners[accountKey(stored.Key.BotName)] = stored.Key.Owner
+	}
+	revisions := make(map[string][]*models.Revision, len(state.Revisions))
+	for messageID, list := range state.Revisions {
+		if _, exists := byID[messageID]; !exists {
+			return fmt.Errorf("snapshot has revisions of unknown message %s", messageID)
+		}
+		revisions[messageID] = list
+	}
+	reactions := make(map[string][]*models.Reaction, len(state.Reactions))
+	for messageID, list := range state.Reactions {
+		if _, exists := byID[messageID]; !exists {
+			return fmt.Errorf("snapshot has reactions to unknown message %s", messageID)
+		}
+		reactions[messageID] = list
+	}
+
+	invites := make(map[string]*StoredInvite, len(state.Invites))
+	for _, stored := range state.Invites {
+		if stored == nil || stored.Invite == nil {
+			return errors.New("snapshot contains empty invite")
+		}
+		if _, exists := chats[stored.Invite.ChatID]; !exists {
+			return fmt.Errorf("snapshot has invite for unknown chat %s", stored.Invite.ChatID)
+		}
+		invites[stored.TokenHash] = stored
+	}
+
+	s.chats = chats
+	s.messages = messages
+	s.byID = byID
+	s.lastSeq = lastSeq
+	s.live = live
+	s.members = members
+	s.revisions = revisions
+	s.threads = threads
+	s.reactions = reactions
+	s.accounts = accounts
+	s.sessions = sessions
+	s.apiKeys = apiKeys
+	s.keyIDs = keyIDs
+	s.botOwners = botOwners
+	s.invites = invites
+	s.directChats = directChats
+	s.search = search
+	return nil
+}
+
+// writeSnapshot atomically writes state as snapshot seq in dir
+func writeSnapshot(dir string, seq uint64, state *snapshotState) error {
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("encode snapshot: %w", err)
+	}
+
+	path := filepath.Join(dir, snapshotName(seq))
+	tmp, err := os.CreateTemp(dir, snapshotName(seq)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create snapshot: %w", err)
+	}
+	defer func() {
+		// Only a failed write leaves the temporary file behind
+		_ = os.Remove(tmp.Name())
+	}()
+
+	if _, err := tmp.Write(encodeFrame(payload)); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("sync snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close snapshot: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("rename snapshot: %w", err)
+	}
+	return syncDir(dir)
+}
+
+// readSnapshot loads and verifies snapshot seq from dir
+func readSnapshot(dir string, seq uint64) (*snapshotState, error) {
+	file, err := os.Open(filepath.Join(dir, snapshotName(seq)))
+	if err != nil {
+		return nil, fmt.Errorf("open snapshot: %w", err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	reader := bufio.NewReader(file)
+	payload, _, err := readFrame(reader, make([]byte, frameHeaderSize))
+	if err != nil {
+		return nil, fmt.Errorf("read snapshot: %w", err)
+	}
+	if _, err := reader.ReadByte(); !errors.Is(err, io.EOF) {
+		return nil, errors.New("read snapshot: trailing data")
+	}
+
+	var state snapshotState
+	if err := json.Unmarshal(payload, &state); err != nil {
+		return nil, fmt.Errorf("decode snapshot: %w", err)
+	}
+	return &state, nil
+}
+
+// syncDir fsyncs a directory so that renames and removals in it are durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("open data directory: %w", err)
+	}
+	defer func() {
+		_ = d.Close()
+	}()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("sync data directory: %w", err)
+	}
+	return nil
+}
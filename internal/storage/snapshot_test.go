@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	t.Run("ReplaysOnlyTail", func(t *testing.T) {
+		dir := t.TempDir()
+		s := openTestFileStore(t, dir)
+
+		chat, _ := s.CreateChat("Snapshot Chat")
+		for i := 0; i < 5; i++ {
+			if _, err := s.AddMessage(chat.ID, "user", fmt.Sprintf("before %d", i)); err != nil {
+				t.Fatalf("Failed to add message: %v", err)
+			}
+		}
+		if err := s.Snapshot(); err != nil {
+			t.Fatalf("Failed to snapshot: %v", err)
+		}
+		if _, err := s.AddMessage(chat.ID, "user", "after"); err != nil {
+			t.Fatalf("Failed to add message: %v", err)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatalf("Failed to close store: %v", err)
+		}
+
+		s = openTestFileStore(t, dir)
+		defer closeTestFileStore(t, s)
+
+		stats := s.Recovered()
+		if stats.Snapshot == 0 {
+			t.Error("Expected state to be loaded from a snapshot")
+		}
+		if stats.Records != 1 {
+			t.Errorf("Expected only the tail record to be replayed, got %d", stats.Records)
+		}
+
+		messages, _ := s.GetMessages(chat.ID)
+		if len(messages) != 6 {
+			t.Fatalf("Expected 6 messages, got %d", len(messages))
+		}
+		if messages[5].Content != "after" {
+			t.Errorf("Expected last message 'after', got '%s'", messages[5].Content)
+		}
+
+		msg, err := s.AddMessage(chat.ID, "user", "reopened")
+		if err != nil {
+			t.Fatalf("Failed to add message: %v", err)
+		}
+		if msg.Seq != 7 {
+			t.Errorf("Expected sequence numbering to continue at 7, got %d", msg.Seq)
+		}
+	})
+
+	t.Run("CompactsOldSegments", func(t *testing.T) {
+		dir := t.TempDir()
+		s, err := OpenFileStore(dir, FileOptions{SnapshotRetain: 2})
+		if err != nil {
+			t.Fatalf("Failed to open store: %v", err)
+		}
+		defer closeTestFileStore(t, s)
+
+		chat, _ := s.CreateChat("Compacted Chat")
+		for i := 0; i < 4; i++ {
+			if _, err := s.AddMessage(chat.ID, "user", "message"); err != nil {
+				t.Fatalf("Failed to add message: %v", err)
+			}
+			if err := s.Snapshot(); err != nil {
+				t.Fatalf("Failed to snapshot: %v", err)
+			}
+		}
+
+		snapshots, _ := listSnapshots(dir)
+		if len(snapshots) != 2 {
+			t.Fatalf("Expected 2 retained snapshots, got %d", len(snapshots))
+		}
+		segments, _ := listSegments(dir)
+		for _, seg := range segments {
+			if seg < snapshots[0] {
+				t.Errorf("Expected segment %d to be compacted away", seg)
+			}
+		}
+	})
+
+	t.Run("FallsBackToOlderSnapshot", func(t *testing.T) {
+		dir := t.TempDir()
+		s := openTestFileStore(t, dir)
+
+		chat, _ := s.CreateChat("Fallback Chat")
+		if _, err := s.AddMessage(chat.ID, "user", "one"); err != nil {
+			t.Fatalf("Failed to add message: %v", err)
+		}
+		if err := s.Snapshot(); err != nil {
+			t.Fatalf("Failed to snapshot: %v", err)
+		}
+		if _, err := s.AddMessage(chat.ID, "user", "two"); err != nil {
+			t.Fatalf("Failed to add message: %v", err)
+		}
+		if err := s.Snapshot(); err != nil {
+			t.Fatalf("Failed to snapshot: %v", err)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatalf("Failed to close store: %v", err)
+		}
+
+		snapshots, _ := listSnapshots(dir)
+		latest := filepath.Join(dir, snapshotName(snapshots[len(snapshots)-1]))
+		if err := os.WriteFile(latest, []byte("garbage"), 0o600); err != nil {
+			t.Fatalf("Failed to corrupt snapshot: %v", err)
+		}
+
+		s = openTestFileStore(t, dir)
+		defer closeTestFileStore(t, s)
+
+		if got := s.Recovered().Snapshot; got != snapshots[0] {
+			t.Errorf("Expected fallback to snapshot %d, got %d", snapshots[0], got)
+		}
+		messages, _ := s.GetMessages(chat.ID)
+		if len(messages) != 2 {
+			t.Errorf("Expected 2 messages after fallback, got %d", len(messages))
+		}
+	})
+
+	t.Run("Periodic", func(t *testing.T) {
+		dir := t.TempDir()
+		s, err := OpenFileStore(dir, FileOptions{SnapshotInterval: 10 * time.Millisecond})
+		if err != nil {
+			t.Fatalf("Failed to open store: %v", err)
+		}
+		defer closeTestFileStore(t, s)
+
+		if _, err := s.CreateChat("Periodic Chat"); err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			snapshots, _ := listSnapshots(dir)
+			if len(snapshots) > 0 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("Expected a periodic snapshot to be written")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	})
+}
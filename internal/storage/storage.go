@@ -1,6 +1,9 @@
 package storage
 
 import (
+	"fmt"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,80 +16,223 @@ import (
 type Storage struct {
 	mu       sync.RWMutex
 	chats    map[string]*models.Chat
-	messages map[string][]*models.Message // chatID -> messages
+	messages map[string][]*models.Message         // chatID -> messages
+	byID     map[string]*models.Message           // messageID -> message
+	lastSeq  map[string]int64                     // chatID -> last assigned sequence number
+	live     map[string]int                       // chatID -> number of messages not deleted
+	members  map[string]map[string]*models.Member // chatID -> lowercased username -> member
+	// revisions holds the earlier versions of edited messages
+	revisions map[string][]*models.Revision // messageID -> oldest first
+	threads   map[string][]string           // messageID of a thread's root -> IDs of its replies
+	reactions map[string][]*models.Reaction // messageID -> reactions, oldest first
+	accounts  map[string]*Account           // lowercased username -> account
+	sessions  map[string]*Session           // token hash -> session
+
+	apiKeys   map[string]*StoredAPIKey // key ID -> key
+	keyIDs    map[string]string        // token hash -> key ID
+	botOwners map[string]string        // lowercased bot name -> owning username
+	invites   map[string]*StoredInvite // token hash -> invite
+
+	directChats map[string]string // directKey of the participants -> chatID
+	search      *searchIndex
+
+	// journal, when set, is called with every mutation before it is
+	// applied. A journal error aborts the mutation.
+	journal func(rec *record) error
+	broker  *broker
+	metrics *storageMetrics
 }
 
 // NewStorage creates a new storage instance
 func NewStorage() *Storage {
 	return &Storage{
-		chats:    make(map[string]*models.Chat),
-		messages: make(map[string][]*models.Message),
+		chats:     make(map[string]*models.Chat),
+		messages:  make(map[string][]*models.Message),
+		byID:      make(map[string]*models.Message),
+		lastSeq:   make(map[string]int64),
+		live:      make(map[string]int),
+		members:   make(map[string]map[string]*models.Member),
+		revisions: make(map[string][]*models.Revision),
+		threads:   make(map[string][]string),
+		reactions: make(map[string][]*models.Reaction),
+		accounts:  make(map[string]*Account),
+		sessions:  make(map[string]*Session),
+
+		apiKeys:   make(map[string]*StoredAPIKey),
+		keyIDs:    make(map[string]string),
+		botOwners: make(map[string]string),
+		invites:   make(map[string]*StoredInvite),
+
+		directChats: make(map[string]string),
+		search:      newSearchIndex(),
+		broker:      newBroker(),
+		metrics:     newStorageMetrics(),
 	}
 }
 
 // CreateChat creates a new chat
 func (s *Storage) CreateChat(name string) (*models.Chat, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	defer s.lock("CreateChat")()
 
 	chat := &models.Chat{
-		ID:        uuid.New().String(),
-		Name:      name,
-		CreatedAt: time.Now(),
+		ID:         uuid.New().String(),
+		Name:       name,
+		CreatedAt:  time.Now(),
+		Visibility: models.VisibilityPublic,
 	}
 
-	s.chats[chat.ID] = chat
-	s.messages[chat.ID] = []*models.Message{}
-
+	if err := s.commit(&record{Op: opCreateChat, Chat: chat}); err != nil {
+		return nil, err
+	}
 	return chat, nil
 }
 
+// ChatUpdate lists the changes UpdateChat makes to a chat. Nil fields are
+// left as they are.
+type ChatUpdate struct {
+	Name        *string
+	Topic       *string
+	Description *string
+	// Archived archives or restores the chat
+	Archived *bool
+	// LegalHold places the chat under legal hold or lifts it
+	LegalHold *bool
+}
+
+// UpdateChat changes the name, topic, description, archive state or legal
+// hold of a chat. Archiving an archived chat keeps the time it was first
+// archived.
+func (s *Storage) UpdateChat(chatID string, update ChatUpdate) (*models.Chat, error) {
+	defer s.lock("UpdateChat")()
+
+	chat, exists := s.chats[chatID]
+	if !exists {
+		return nil, ErrChatNotFound
+	}
+
+	// Chats are replaced rather than modified in place
+	updated := *chat
+	if update.Name != nil {
+		updated.Name = *update.Name
+	}
+	if update.Topic != nil {
+		updated.Topic = *update.Topic
+	}
+	if update.Description != nil {
+		updated.Description = *update.Description
+	}
+	if update.Archived != nil {
+		switch {
+		case !*update.Archived:
+			updated.ArchivedAt = nil
+		case updated.ArchivedAt == nil:
+			now := time.Now()
+			updated.ArchivedAt = &now
+		}
+	}
+	if update.LegalHold != nil {
+		updated.LegalHold = *update.LegalHold
+	}
+	if err := s.commit(&record{Op: opUpdateChat, Chat: &updated}); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// DeleteChat removes a chat together with its messages and members
+func (s *Storage) DeleteChat(chatID string) error {
+	defer s.lock("DeleteChat")()
+
+	chat, exists := s.chats[chatID]
+	if !exists {
+		return ErrChatNotFound
+	}
+	return s.commit(&record{Op: opDeleteChat, Chat: chat})
+}
+
 // GetChat retrieves a chat by ID
 func (s *Storage) GetChat(chatID string) (*models.Chat, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	defer s.rlock("GetChat")()
 
 	chat, exists := s.chats[chatID]
 	return chat, exists
 }
 
-// ListChats returns all chats
+// ListChats returns all chats, oldest first. Chats created at the same time
+// are ordered by ID.
 func (s *Storage) ListChats() []*models.Chat {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	defer s.rlock("ListChats")()
 
 	chats := make([]*models.Chat, 0, len(s.chats))
 	for _, chat := range s.chats {
 		chats = append(chats, chat)
 	}
+	slices.SortFunc(chats, func(a, b *models.Chat) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
 	return chats
 }
 
-// AddMessage adds a message to a chat
+// NewMessage holds the caller-supplied fields of a message for Post
+type NewMessage struct {
+	ChatID   string
+	Username string
+	Content  string
+	Bot      bool
+	// ReplyTo is the ID of the message this one replies to, if any
+	ReplyTo string
+}
+
+// AddMessage adds a message from a user to a chat
 func (s *Storage) AddMessage(chatID, username, content string) (*models.Message, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	return s.Post(NewMessage{ChatID: chatID, Username: username, Content: content})
+}
+
+// Post adds a message to a chat, assigning it the next sequence number of
+// that chat. A reply joins the thread of the message it answers.
+func (s *Storage) Post(msg NewMessage) (*models.Message, error) {
+	defer s.lock("Post")()
 
-	if _, exists := s.chats[chatID]; !exists {
-		return nil, nil
+	if _, exists := s.chats[msg.ChatID]; !exists {
+		return nil, ErrChatNotFound
+	}
+	var threadID string
+	if msg.ReplyTo != "" {
+		parent, exists := s.byID[msg.ReplyTo]
+		if !exists || parent.ChatID != msg.ChatID {
+			return nil, ErrMessageNotFound
+		}
+		if parent.DeletedAt != nil {
+			return nil, ErrMessageDeleted
+		}
+		// The thread carries on if its root has expired
+		threadID = threadOf(parent)
 	}
 
 	message := &models.Message{
 		ID:        uuid.New().String(),
-		ChatID:    chatID,
-		Username:  username,
-		Content:   content,
+		ChatID:    msg.ChatID,
+		Username:  msg.Username,
+		Content:   msg.Content,
 		Timestamp: time.Now(),
+		Seq:       s.lastSeq[msg.ChatID] + 1,
+		Bot:       msg.Bot,
+		ReplyTo:   msg.ReplyTo,
+		ThreadID:  threadID,
 	}
 
-	s.messages[chatID] = append(s.messages[chatID], message)
+	if err := s.commit(&record{Op: opAddMessage, Message: message}); err != nil {
+		return nil, err
+	}
 	return message, nil
 }
 
 // GetMessages retrieves all messages for a chat
 func (s *Storage) GetMessages(chatID string) ([]*models.Message, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	defer s.rlock("GetMessages")()
 
 	messages, exists := s.messages[chatID]
 	if !exists {
@@ -98,3 +244,205 @@ func (s *Storage) GetMessages(chatID string) ([]*models.Message, bool) {
 	copy(result, messages)
 	return result, true
 }
+
+// commit journals a mutation, applies it and notifies subscribers. The
+// caller must hold the write lock.
+func (s *Storage) commit(rec *record) error {
+	if s.journal != nil {
+		if err := s.journal(rec); err != nil {
+			return err
+		}
+	}
+	s.apply(rec)
+	s.publish(rec)
+	return nil
+}
+
+// apply applies a mutation to the in-memory state. The caller must hold the
+// write lock.
+func (s *Storage) apply(rec *record) {
+	switch rec.Op {
+	case opCreateChat:
+		s.chats[rec.Chat.ID] = rec.Chat
+		s.messages[rec.Chat.ID] = []*models.Message{}
+		s.members[rec.Chat.ID] = make(map[string]*models.Member)
+		if rec.Member != nil {
+			s.members[rec.Chat.ID][accountKey(rec.Member.Username)] = rec.Member
+		}
+		for _, member := range rec.Members {
+			s.members[rec.Chat.ID][accountKey(member.Username)] = member
+		}
+		s.indexDirectChat(rec.Chat)
+	case opRenameChat, opUpdateChat:
+		s.chats[rec.Chat.ID] = rec.Chat
+	case opDeleteChat:
+		for _, msg := range s.messages[rec.Chat.ID] {
+			delete(s.byID, msg.ID)
+			delete(s.revisions, msg.ID)
+			delete(s.threads, msg.ID)
+			delete(s.reactions, msg.ID)
+			s.search.remove(msg.ID)
+		}
+		delete(s.chats, rec.Chat.ID)
+		delete(s.messages, rec.Chat.ID)
+		delete(s.lastSeq, rec.Chat.ID)
+		delete(s.live, rec.Chat.ID)
+		delete(s.members, rec.Chat.ID)
+		if len(rec.Chat.Participants) == 2 {
+			delete(s.directChats, directKey(rec.Chat.Participants[0], rec.Chat.Participants[1]))
+		}
+		for hash, invite := range s.invites {
+			if invite.Invite.ChatID == rec.Chat.ID {
+				delete(s.invites, hash)
+			}
+		}
+	case opSetMember:
+		s.members[rec.Member.ChatID][accountKey(rec.Member.Username)] = rec.Member
+	case opRemoveMember:
+		delete(s.members[rec.Member.ChatID], accountKey(rec.Member.Username))
+	case opAddMessage:
+		s.messages[rec.Message.ChatID] = append(s.messages[rec.Message.ChatID], rec.Message)
+		s.byID[rec.Message.ID] = rec.Message
+		s.lastSeq[rec.Message.ChatID] = rec.Message.Seq
+		s.live[rec.Message.ChatID]++
+		if rec.Message.ThreadID != "" {
+			s.addReply(rec.Message)
+		}
+		s.search.add(rec.Message)
+	case opEditMessage:
+		s.replaceMessage(rec.Message)
+		s.revisions[rec.Message.ID] = append(s.revisions[rec.Message.ID], rec.Revision)
+		s.search.add(rec.Message)
+	case opDeleteMessage:
+		if old, exists := s.byID[rec.Message.ID]; exists && old.DeletedAt == nil {
+			s.live[rec.Message.ChatID]--
+		}
+		s.replaceMessage(rec.Message)
+		delete(s.revisions, rec.Message.ID)
+		delete(s.reactions, rec.Message.ID)
+		s.search.remove(rec.Message.ID)
+	case opExpireMessages:
+		s.expireMessages(rec.Chat.ID, rec.Expired)
+	case opAddReaction:
+		s.reactions[rec.Reaction.MessageID] = append(s.reactions[rec.Reaction.MessageID], rec.Reaction)
+		s.countReactions(rec.Reaction.MessageID)
+	case opRemoveReaction:
+		s.removeReaction(rec.Reaction)
+		s.countReactions(rec.Reaction.MessageID)
+	case opCreateUser:
+		s.accounts[accountKey(rec.Account.User.Username)] = rec.Account
+	case opCreateSession:
+		s.sessions[rec.Session.TokenHash] = rec.Session
+	case opCreateAPIKey, opRevokeAPIKey:
+		s.apiKeys[rec.APIKey.Key.ID] = rec.APIKey
+		s.keyIDs[rec.APIKey.TokenHash] = rec.APIKey.Key.ID
+		s.botOwners[accountKey(rec.APIKey.Key.BotName)] = rec.APIKey.Key.Owner
+	case opCreateInvite:
+		s.invites[rec.Invite.TokenHash] = rec.Invite
+	case opRedeemInvite:
+		s.invites[rec.Invite.TokenHash] = rec.Invite
+		s.members[rec.Member.ChatID][accountKey(rec.Member.Username)] = rec.Member
+	}
+}
+
+// indexDirectChat records chat in the index of direct chats if it is one.
+// The caller must hold the write lock.
+func (s *Storage) indexDirectChat(chat *models.Chat) {
+	if chat.Visibility == models.VisibilityDirect && len(chat.Participants) == 2 {
+		s.directChats[directKey(chat.Participants[0], chat.Participants[1])] = chat.ID
+	}
+}
+
+// replay applies a record read back from a log, checking that it is
+// consistent with the state rebuilt so far
+func (s *Storage) replay(rec *record) error {
+	defer s.lock("replay")()
+
+	switch rec.Op {
+	case opCreateChat:
+		if _, exists := s.chats[rec.Chat.ID]; exists {
+			return fmt.Errorf("duplicate chat %s", rec.Chat.ID)
+		}
+		if rec.Chat.Visibility == models.VisibilityDirect && len(rec.Chat.Participants) != 2 {
+			return fmt.Errorf("direct chat %s without two participants", rec.Chat.ID)
+		}
+	case opRenameChat, opUpdateChat, opDeleteChat:
+		if _, exists := s.chats[rec.Chat.ID]; !exists {
+			return fmt.Errorf("%s of unknown chat %s", rec.Op, rec.Chat.ID)
+		}
+	case opExpireMessages:
+		for _, msg := range rec.Expired {
+			if stored, exists := s.byID[msg.ID]; !exists || stored.ChatID != rec.Chat.ID {
+				return fmt.Errorf("expiry of unknown message %s in chat %s", msg.ID, rec.Chat.ID)
+			}
+		}
+	case opSetMember:
+		if _, exists := s.chats[rec.Member.ChatID]; !exists {
+			return fmt.Errorf("member %s of unknown chat %s", rec.Member.Username, rec.Member.ChatID)
+		}
+	case opRemoveMember:
+		if _, exists := s.members[rec.Member.ChatID][accountKey(rec.Member.Username)]; !exists {
+			return fmt.Errorf("removal of unknown member %s from chat %s", rec.Member.Username, rec.Member.ChatID)
+		}
+	case opAddMessage:
+		if _, exists := s.chats[rec.Message.ChatID]; !exists {
+			return fmt.Errorf("message %s for unknown chat %s", rec.Message.ID, rec.Message.ChatID)
+		}
+		// Messages logged before sequence numbers existed are numbered in
+		// log order
+		if rec.Message.Seq == 0 {
+			rec.Message.Seq = s.lastSeq[rec.Message.ChatID] + 1
+		}
+		if rec.Message.Seq <= s.lastSeq[rec.Message.ChatID] {
+			return fmt.Errorf("message %s has sequence %d, expected more than %d",
+				rec.Message.ID, rec.Message.Seq, s.lastSeq[rec.Message.ChatID])
+		}
+		// The root of a thread may have expired before its replies
+		if threadID := rec.Message.ThreadID; threadID != "" {
+			if root, exists := s.byID[threadID]; exists && root.ChatID != rec.Message.ChatID {
+				return fmt.Errorf("message %s replies in unknown thread %s", rec.Message.ID, threadID)
+			}
+		}
+	case opEditMessage, opDeleteMessage:
+		if old, exists := s.byID[rec.Message.ID]; !exists || old.ChatID != rec.Message.ChatID || old.Seq != rec.Message.Seq {
+			return fmt.Errorf("%s of unknown message %s", rec.Op, rec.Message.ID)
+		}
+	case opAddReaction:
+		if _, exists := s.byID[rec.Reaction.MessageID]; !exists {
+			return fmt.Errorf("reaction to unknown message %s", rec.Reaction.MessageID)
+		}
+	case opRemoveReaction:
+		if s.findReaction(rec.Reaction.MessageID, rec.Reaction.Username, rec.Reaction.Emoji) < 0 {
+			return fmt.Errorf("removal of unknown reaction to message %s", rec.Reaction.MessageID)
+		}
+	case opCreateUser:
+		if _, exists := s.accounts[accountKey(rec.Account.User.Username)]; exists {
+			return fmt.Errorf("duplicate user %s", rec.Account.User.Username)
+		}
+	case opCreateSession:
+		if _, exists := s.accounts[accountKey(rec.Session.Username)]; !exists {
+			return fmt.Errorf("session for unknown user %s", rec.Session.Username)
+		}
+	case opCreateAPIKey:
+		if _, exists := s.apiKeys[rec.APIKey.Key.ID]; exists {
+			return fmt.Errorf("duplicate API key %s", rec.APIKey.Key.ID)
+		}
+	case opRevokeAPIKey:
+		if _, exists := s.apiKeys[rec.APIKey.Key.ID]; !exists {
+			return fmt.Errorf("revocation of unknown API key %s", rec.APIKey.Key.ID)
+		}
+	case opCreateInvite:
+		if _, exists := s.chats[rec.Invite.Invite.ChatID]; !exists {
+			return fmt.Errorf("invite %s for unknown chat %s", rec.Invite.Invite.ID, rec.Invite.Invite.ChatID)
+		}
+	case opRedeemInvite:
+		if _, exists := s.invites[rec.Invite.TokenHash]; !exists {
+			return fmt.Errorf("redemption of unknown invite %s", rec.Invite.Invite.ID)
+		}
+		if _, exists := s.chats[rec.Member.ChatID]; !exists {
+			return fmt.Errorf("member %s of unknown chat %s", rec.Member.Username, rec.Member.ChatID)
+		}
+	}
+	s.apply(rec)
+	return nil
+}
@@ -1,8 +1,14 @@
 package storage
 
 import (
+	"errors"
+	"slices"
+	"strings"
 	"testing"
 	"time"
+
+	"chat-app/internal/metrics"
+	"chat-app/internal/models"
 )
 
 func TestStorage(t *testing.T) {
@@ -113,8 +119,8 @@ func TestStorage(t *testing.T) {
 		s := NewStorage()
 		message, err := s.AddMessage("nonexistent", "testuser", "Hello")
 
-		if err != nil {
-			t.Errorf("Unexpected error: %v", err)
+		if !errors.Is(err, ErrChatNotFound) {
+			t.Errorf("Expected ErrChatNotFound, got %v", err)
 		}
 
 		if message != nil {
@@ -202,3 +208,65 @@ func TestStorage(t *testing.T) {
 		}
 	})
 }
+
+func TestSearchIndex(t *testing.T) {
+	idx := newSearchIndex()
+	idx.add(&models.Message{ID: "1", Content: "car card"})
+	idx.add(&models.Message{ID: "2", Content: "care cat"})
+	idx.add(&models.Message{ID: "3", Content: "scar"})
+
+	matched := func(term string) []string {
+		var ids []string
+		for id := range idx.match(term) {
+			ids = append(ids, id)
+		}
+		slices.Sort(ids)
+		return ids
+	}
+	if got := matched("car"); !slices.Equal(got, []string{"1", "2"}) {
+		t.Errorf("Expected the words starting with car to match, got %v", got)
+	}
+
+	// A word removed and indexed again before a search is listed once
+	first := idx.match("cat")["2"]
+	idx.remove("2")
+	idx.add(&models.Message{ID: "2", Content: "care cat"})
+	if got := idx.match("cat")["2"]; got != first {
+		t.Errorf("Expected the same score after indexing again, got %v want %v", got, first)
+	}
+	idx.remove("3")
+	if got := matched("scar"); len(got) != 0 {
+		t.Errorf("Expected removed words not to match, got %v", got)
+	}
+	if got := idx.sortedVocab(); !slices.Equal(got, []string{"car", "card", "care", "cat"}) {
+		t.Errorf("Expected the indexed words in order, got %v", got)
+	}
+}
+
+func TestStorageMetrics(t *testing.T) {
+	s := NewStorage()
+	reg := metrics.NewRegistry()
+	s.RegisterMetrics(reg)
+
+	chat, _ := s.CreateChat("Measured")
+	s.AddMessage(chat.ID, "alice", "one")
+	s.AddMessage(chat.ID, "alice", "two")
+	s.GetMessages(chat.ID)
+
+	var out strings.Builder
+	if _, err := reg.WriteTo(&out); err != nil {
+		t.Fatalf("Failed to write metrics: %v", err)
+	}
+	for _, want := range []string{
+		`chat_storage_operation_duration_seconds_count{op="Post"} 2`,
+		`chat_storage_operation_duration_seconds_count{op="CreateChat"} 1`,
+		`chat_storage_operation_duration_seconds_count{op="GetMessages"} 1`,
+		`chat_storage_lock_wait_seconds_count{mode="write"} 3`,
+		"chat_chats 1\n",
+		"chat_messages 2\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected %q in metrics:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,1624 @@
+// Package storagetest provides a conformance suite that every storage.Store
+// implementation must pass.
+package storagetest
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"chat-app/internal/models"
+	"chat-app/internal/storage"
+)
+
+// Factory returns a new, empty store for a single test
+type Factory func(t *testing.T) storage.Store
+
+// Run runs the conformance suite against stores created by newStore
+func Run(t *testing.T, newStore Factory) {
+	t.Run("CreateChat", func(t *testing.T) {
+		s := newStore(t)
+		chat, err := s.CreateChat("Test Chat")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+
+		if chat.Name != "Test Chat" {
+			t.Errorf("Expected chat name 'Test Chat', got '%s'", chat.Name)
+		}
+
+		if chat.ID == "" {
+			t.Error("Expected chat to have an ID")
+		}
+
+		if chat.CreatedAt.IsZero() {
+			t.Error("Expected chat to have a creation time")
+		}
+	})
+
+	t.Run("CreateChat_UniqueIDs", func(t *testing.T) {
+		s := newStore(t)
+		first, err := s.CreateChat("Same Name")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+		second, err := s.CreateChat("Same Name")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+
+		if first.ID == second.ID {
+			t.Errorf("Expected distinct chat IDs, got %s twice", first.ID)
+		}
+	})
+
+	t.Run("GetChat", func(t *testing.T) {
+		s := newStore(t)
+		created, err := s.CreateChat("Test Chat")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+
+		retrieved, exists := s.GetChat(created.ID)
+		if !exists {
+			t.Fatal("Expected chat to exist")
+		}
+
+		if retrieved.ID != created.ID {
+			t.Errorf("Expected chat ID %s, got %s", created.ID, retrieved.ID)
+		}
+
+		if retrieved.Name != created.Name {
+			t.Errorf("Expected chat name %s, got %s", created.Name, retrieved.Name)
+		}
+	})
+
+	t.Run("GetChat_NonExistent", func(t *testing.T) {
+		s := newStore(t)
+		if _, exists := s.GetChat("nonexistent"); exists {
+			t.Error("Expected chat to not exist")
+		}
+	})
+
+	t.Run("ListChats", func(t *testing.T) {
+		s := newStore(t)
+
+		if chats := s.ListChats(); len(chats) != 0 {
+			t.Errorf("Expected 0 chats, got %d", len(chats))
+		}
+
+		want := make(map[string]bool)
+		for i := 1; i <= 3; i++ {
+			chat, err := s.CreateChat(fmt.Sprintf("Chat %d", i))
+			if err != nil {
+				t.Fatalf("Failed to create chat %d: %v", i, err)
+			}
+			want[chat.ID] = true
+		}
+
+		chats := s.ListChats()
+		if len(chats) != len(want) {
+			t.Errorf("Expected %d chats, got %d", len(want), len(chats))
+		}
+		for i, chat := range chats {
+			if !want[chat.ID] {
+				t.Errorf("Unexpected chat %s in listing", chat.ID)
+			}
+			if i == 0 {
+				continue
+			}
+			prev := chats[i-1]
+			if c := prev.CreatedAt.Compare(chat.CreatedAt); c > 0 || c == 0 && prev.ID > chat.ID {
+				t.Errorf("Expected chats oldest first, got %s before %s", prev.ID, chat.ID)
+			}
+		}
+	})
+
+	t.Run("GetChatSummary", func(t *testing.T) {
+		s := newStore(t)
+		chat, err := s.CreateChat("Summarized")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+
+		summary, exists := s.GetChatSummary(chat.ID)
+		if !exists {
+			t.Fatal("Expected a summary of the chat")
+		}
+		if summary.MessageCount != 0 || summary.LastMessage != nil || !summary.LastActivityAt.Equal(chat.CreatedAt) {
+			t.Errorf("Expected an empty summary active since creation, got %+v", summary)
+		}
+
+		if _, err := s.AddMessage(chat.ID, "alice", "First"); err != nil {
+			t.Fatalf("Failed to add message: %v", err)
+		}
+		long, err := s.AddMessage(chat.ID, "bob", strings.Repeat("é", storage.PreviewLength+10))
+		if err != nil {
+			t.Fatalf("Failed to add message: %v", err)
+		}
+		summary, _ = s.GetChatSummary(chat.ID)
+		if summary.MessageCount != 2 || !summary.LastActivityAt.Equal(long.Timestamp) {
+			t.Errorf("Expected 2 messages active at the last one, got %+v", summary)
+		}
+		if preview := summary.LastMessage; preview == nil || preview.ID != long.ID || utf8.RuneCountInString(preview.Content) != storage.PreviewLength {
+			t.Errorf("Expected a shortened preview of the last message, got %+v", preview)
+		}
+
+		// Deleted messages are neither counted nor previewed
+		if _, err := s.DeleteMessage(long.ID); err != nil {
+			t.Fatalf("Failed to delete message: %v", err)
+		}
+		summary, _ = s.GetChatSummary(chat.ID)
+		if summary.MessageCount != 1 || summary.LastMessage == nil || summary.LastMessage.Content != "First" {
+			t.Errorf("Expected the deleted message to be skipped, got %+v", summary)
+		}
+
+		if _, exists := s.GetChatSummary("nonexistent"); exists {
+			t.Error("Expected no summary for a nonexistent chat")
+		}
+	})
+
+	t.Run("AddMessage", func(t *testing.T) {
+		s := newStore(t)
+		chat, err := s.CreateChat("Test Chat")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+
+		message, err := s.AddMessage(chat.ID, "testuser", "Hello, World!")
+		if err != nil {
+			t.Fatalf("Failed to add message: %v", err)
+		}
+
+		if message == nil {
+			t.Fatal("Expected message to be returned")
+		}
+
+		if message.ID == "" {
+			t.Error("Expected message to have an ID")
+		}
+
+		if message.Username != "testuser" {
+			t.Errorf("Expected username 'testuser', got '%s'", message.Username)
+		}
+
+		if message.Content != "Hello, World!" {
+			t.Errorf("Expected content 'Hello, World!', got '%s'", message.Content)
+		}
+
+		if message.ChatID != chat.ID {
+			t.Errorf("Expected chat ID %s, got %s", chat.ID, message.ChatID)
+		}
+
+		if message.Timestamp.IsZero() {
+			t.Error("Expected message to have a timestamp")
+		}
+	})
+
+	t.Run("AddMessage_SequenceNumbers", func(t *testing.T) {
+		s := newStore(t)
+		first, err := s.CreateChat("First Chat")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+		second, err := s.CreateChat("Second Chat")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+
+		for i := int64(1); i <= 3; i++ {
+			msg, err := s.AddMessage(first.ID, "user", "Message")
+			if err != nil {
+				t.Fatalf("Failed to add message: %v", err)
+			}
+			if msg.Seq != i {
+				t.Errorf("Expected sequence %d, got %d", i, msg.Seq)
+			}
+		}
+
+		// Sequences are per chat
+		msg, err := s.AddMessage(second.ID, "user", "Message")
+		if err != nil {
+			t.Fatalf("Failed to add message: %v", err)
+		}
+		if msg.Seq != 1 {
+			t.Errorf("Expected sequence 1 in second chat, got %d", msg.Seq)
+		}
+	})
+
+	t.Run("AddMessage_NonExistentChat", func(t *testing.T) {
+		s := newStore(t)
+		message, err := s.AddMessage("nonexistent", "testuser", "Hello")
+		if !errors.Is(err, storage.ErrChatNotFound) || !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("Expected ErrChatNotFound, got %v", err)
+		}
+
+		if message != nil {
+			t.Error("Expected nil message for non-existent chat")
+		}
+	})
+
+	t.Run("GetMessages", func(t *testing.T) {
+		s := newStore(t)
+		chat, err := s.CreateChat("Test Chat")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+
+		messages, exists := s.GetMessages(chat.ID)
+		if !exists {
+			t.Error("Expected messages to exist for created chat")
+		}
+		if len(messages) != 0 {
+			t.Errorf("Expected 0 messages, got %d", len(messages))
+		}
+
+		for i := 1; i <= 3; i++ {
+			if _, err := s.AddMessage(chat.ID, "user", fmt.Sprintf("Message %d", i)); err != nil {
+				t.Fatalf("Failed to add message %d: %v", i, err)
+			}
+		}
+
+		messages, exists = s.GetMessages(chat.ID)
+		if !exists {
+			t.Error("Expected messages to exist")
+		}
+		if len(messages) != 3 {
+			t.Fatalf("Expected 3 messages, got %d", len(messages))
+		}
+		for i, msg := range messages {
+			if want := fmt.Sprintf("Message %d", i+1); msg.Content != want {
+				t.Errorf("Expected message %d to be '%s', got '%s'", i, want, msg.Content)
+			}
+		}
+	})
+
+	t.Run("GetMessages_ReturnsCopy", func(t *testing.T) {
+		s := newStore(t)
+		chat, err := s.CreateChat("Test Chat")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+		if _, err := s.AddMessage(chat.ID, "user", "Message"); err != nil {
+			t.Fatalf("Failed to add message: %v", err)
+		}
+
+		messages, _ := s.GetMessages(chat.ID)
+		messages[0] = nil
+
+		messages, _ = s.GetMessages(chat.ID)
+		if messages[0] == nil {
+			t.Error("Expected store contents to be unaffected by caller modifications")
+		}
+	})
+
+	t.Run("GetMessages_NonExistentChat", func(t *testing.T) {
+		s := newStore(t)
+		if _, exists := s.GetMessages("nonexistent"); exists {
+			t.Error("Expected messages to not exist for non-existent chat")
+		}
+	})
+
+	t.Run("GetMessagePage", func(t *testing.T) {
+		s := newStore(t)
+		chat, err := s.CreateChat("Paged Chat")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+		ids := make([]string, 10)
+		for i := range ids {
+			msg, err := s.AddMessage(chat.ID, "user", fmt.Sprintf("Message %d", i))
+			if err != nil {
+				t.Fatalf("Failed to add message %d: %v", i, err)
+			}
+			ids[i] = msg.ID
+		}
+
+		tests := []struct {
+			name     string
+			query    storage.PageQuery
+			first    int
+			count    int
+			hasOlder bool
+			hasNewer bool
+		}{
+			{"All", storage.PageQuery{}, 0, 10, false, false},
+			{"Latest", storage.PageQuery{Limit: 3}, 7, 3, true, false},
+			{"Before", storage.PageQuery{Limit: 3, Before: ids[7]}, 4, 3, true, true},
+			{"BeforeStart", storage.PageQuery{Limit: 3, Before: ids[2]}, 0, 2, false, true},
+			{"After", storage.PageQuery{Limit: 3, After: ids[2]}, 3, 3, true, true},
+			{"AfterEnd", storage.PageQuery{Limit: 3, After: ids[8]}, 9, 1, true, false},
+			{"AfterLast", storage.PageQuery{Limit: 3, After: ids[9]}, 10, 0, true, false},
+			{"Between", storage.PageQuery{After: ids[2], Before: ids[6]}, 3, 3, true, true},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				page, err := s.GetMessagePage(chat.ID, tt.query)
+				if err != nil {
+					t.Fatalf("Failed to get page: %v", err)
+				}
+				if page == nil {
+					t.Fatal("Expected page to be returned")
+				}
+				if len(page.Messages) != tt.count {
+					t.Fatalf("Expected %d messages, got %d", tt.count, len(page.Messages))
+				}
+				for i, msg := range page.Messages {
+					if msg.ID != ids[tt.first+i] {
+						t.Errorf("Expected message %d at position %d, got '%s'", tt.first+i, i, msg.Content)
+					}
+				}
+				if page.HasOlder != tt.hasOlder {
+					t.Errorf("Expected HasOlder %v, got %v", tt.hasOlder, page.HasOlder)
+				}
+				if page.HasNewer != tt.hasNewer {
+					t.Errorf("Expected HasNewer %v, got %v", tt.hasNewer, page.HasNewer)
+				}
+			})
+		}
+	})
+
+	t.Run("GetMessagePage_AfterSeq", func(t *testing.T) {
+		s := newStore(t)
+		chat, err := s.CreateChat("Paged Chat")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+		for i := 0; i < 5; i++ {
+			if _, err := s.AddMessage(chat.ID, "user", fmt.Sprintf("Message %d", i)); err != nil {
+				t.Fatalf("Failed to add message %d: %v", i, err)
+			}
+		}
+
+		page, err := s.GetMessagePage(chat.ID, storage.PageQuery{AfterSeq: 2, HasAfterSeq: true, Limit: 2})
+		if err != nil {
+			t.Fatalf("Failed to get page: %v", err)
+		}
+		if len(page.Messages) != 2 {
+			t.Fatalf("Expected 2 messages, got %d", len(page.Messages))
+		}
+		if page.Messages[0].Seq != 3 || page.Messages[1].Seq != 4 {
+			t.Errorf("Expected sequences 3 and 4, got %d and %d", page.Messages[0].Seq, page.Messages[1].Seq)
+		}
+		if !page.HasNewer {
+			t.Error("Expected HasNewer to be set")
+		}
+
+		page, err = s.GetMessagePage(chat.ID, storage.PageQuery{AfterSeq: 5, HasAfterSeq: true})
+		if err != nil {
+			t.Fatalf("Failed to get page: %v", err)
+		}
+		if len(page.Messages) != 0 {
+			t.Errorf("Expected no messages after the last sequence, got %d", len(page.Messages))
+		}
+	})
+
+	t.Run("GetMessagePage_AfterSeqZero", func(t *testing.T) {
+		s := newStore(t)
+		chat, err := s.CreateChat("Paged Chat")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+		for i := 0; i < 10; i++ {
+			if _, err := s.AddMessage(chat.ID, "user", fmt.Sprintf("Message %d", i)); err != nil {
+				t.Fatalf("Failed to add message %d: %v", i, err)
+			}
+		}
+
+		// 0 is a lower bound before the first message, not the absence
+		// of one
+		page, err := s.GetMessagePage(chat.ID, storage.PageQuery{AfterSeq: 0, HasAfterSeq: true, Limit: 3})
+		if err != nil {
+			t.Fatalf("Failed to get page: %v", err)
+		}
+		if len(page.Messages) != 3 || page.Messages[0].Seq != 1 || page.Messages[2].Seq != 3 {
+			t.Fatalf("Expected the oldest 3 messages, got %+v", page.Messages)
+		}
+		if page.HasOlder || !page.HasNewer {
+			t.Errorf("Expected only newer messages to remain, got HasOlder=%v HasNewer=%v", page.HasOlder, page.HasNewer)
+		}
+	})
+
+	t.Run("GetMessagePage_InvalidCursor", func(t *testing.T) {
+		s := newStore(t)
+		chat, err := s.CreateChat("Paged Chat")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+		other, err := s.CreateChat("Other Chat")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+		foreign, err := s.AddMessage(other.ID, "user", "Elsewhere")
+		if err != nil {
+			t.Fatalf("Failed to add message: %v", err)
+		}
+
+		for _, cursor := range []string{"nonexistent", foreign.ID} {
+			if _, err := s.GetMessagePage(chat.ID, storage.PageQuery{After: cursor}); !errors.Is(err, storage.ErrInvalidCursor) {
+				t.Errorf("Expected ErrInvalidCursor for cursor %q, got %v", cursor, err)
+			}
+		}
+	})
+
+	t.Run("GetMessagePage_NonExistentChat", func(t *testing.T) {
+		s := newStore(t)
+		page, err := s.GetMessagePage("nonexistent", storage.PageQuery{})
+		if !errors.Is(err, storage.ErrChatNotFound) {
+			t.Errorf("Expected ErrChatNotFound, got %v", err)
+		}
+		if page != nil {
+			t.Error("Expected nil page for non-existent chat")
+		}
+	})
+
+	t.Run("Subscribe", func(t *testing.T) {
+		s := newStore(t)
+		chat, err := s.CreateChat("Live Chat")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+		other, err := s.CreateChat("Other Chat")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+
+		sub := s.Subscribe(chat.ID)
+		defer sub.Close()
+
+		if _, err := s.AddMessage(other.ID, "user", "Elsewhere"); err != nil {
+			t.Fatalf("Failed to add message: %v", err)
+		}
+		for i := 1; i <= 3; i++ {
+			if _, err := s.AddMessage(chat.ID, "user", fmt.Sprintf("Message %d", i)); err != nil {
+				t.Fatalf("Failed to add message %d: %v", i, err)
+			}
+		}
+
+		for i := int64(1); i <= 3; i++ {
+			select {
+			case event := <-sub.Events():
+				if event.Type != models.EventMessage || event.Message == nil {
+					t.Fatalf("Expected message event, got %+v", event)
+				}
+				if event.Message.ChatID != chat.ID {
+					t.Errorf("Expected event for chat %s, got %s", chat.ID, event.Message.ChatID)
+				}
+				if event.Message.Seq != i {
+					t.Errorf("Expected events in commit order, got sequence %d at %d", event.Message.Seq, i)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("Timed out waiting for event %d", i)
+			}
+		}
+
+		sub.Close()
+		if _, ok := <-sub.Events(); ok {
+			t.Error("Expected events channel to be closed after Close")
+		}
+	})
+
+	t.Run("Changed", func(t *testing.T) {
+		s := newStore(t)
+		chat, err := s.CreateChat("Watched Chat")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+		other, err := s.CreateChat("Other Chat")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+
+		changed := s.Changed(chat.ID)
+		if _, err := s.AddMessage(other.ID, "user", "Elsewhere"); err != nil {
+			t.Fatalf("Failed to add message: %v", err)
+		}
+		select {
+		case <-changed:
+			t.Fatal("Expected no notification for another chat")
+		default:
+		}
+
+		if _, err := s.AddMessage(chat.ID, "user", "Here"); err != nil {
+			t.Fatalf("Failed to add message: %v", err)
+		}
+		select {
+		case <-changed:
+		case <-time.After(time.Second):
+			t.Fatal("Expected notification after a message was added")
+		}
+
+		// Each change closes the current channel; later callers get a new one
+		select {
+		case <-s.Changed(chat.ID):
+			t.Error("Expected a fresh channel after the notification")
+		default:
+		}
+	})
+
+	t.Run("EditMessage", func(t *testing.T) {
+		s := newStore(t)
+		chat, err := s.CreateChat("Test Chat")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+		msg, err := s.AddMessage(chat.ID, "user", "Helo")
+		if err != nil {
+			t.Fatalf("Failed to add message: %v", err)
+		}
+		sub := s.Subscribe(chat.ID)
+		defer sub.Close()
+
+		edited, err := s.EditMessage(msg.ID, "Hello")
+		if err != nil {
+			t.Fatalf("Failed to edit message: %v", err)
+		}
+		if edited.Content != "Hello" || edited.EditedAt == nil || edited.ID != msg.ID || edited.Seq != msg.Seq {
+			t.Errorf("Expected the same message with new content, got %+v", edited)
+		}
+		if msg.Content != "Helo" || msg.EditedAt != nil {
+			t.Error("Expected the previously returned message to be left unchanged")
+		}
+		if _, err := s.EditMessage(msg.ID, "Hello!"); err != nil {
+			t.Fatalf("Failed to edit message: %v", err)
+		}
+
+		if got, _ := s.GetMessage(msg.ID); got.Content != "Hello!" {
+			t.Errorf("Expected stored content 'Hello!', got '%s'", got.Content)
+		}
+		if messages, _ := s.GetMessages(chat.ID); messages[0].Content != "Hello!" {
+			t.Errorf("Expected listed content 'Hello!', got '%s'", messages[0].Content)
+		}
+		revisions, err := s.GetRevisions(msg.ID)
+		if err != nil {
+			t.Fatalf("Failed to get revisions: %v", err)
+		}
+		if len(revisions) != 2 || revisions[0].Content != "Helo" || revisions[1].Content != "Hello" {
+			t.Errorf("Expected revisions 'Helo' and 'Hello', got %+v", revisions)
+		}
+
+		select {
+		case event := <-sub.Events():
+			if event.Type != models.EventEdit || event.Message.Content != "Hello" {
+				t.Errorf("Expected edit event, got %+v", event)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for edit event")
+		}
+
+		if _, err := s.EditMessage("nonexistent", "Hi"); !errors.Is(err, storage.ErrMessageNotFound) {
+			t.Errorf("Expected ErrMessageNotFound, got %v", err)
+		}
+		if _, err := s.GetRevisions("nonexistent"); !errors.Is(err, storage.ErrMessageNotFound) {
+			t.Errorf("Expected ErrMessageNotFound, got %v", err)
+		}
+	})
+
+	t.Run("DeleteMessage", func(t *testing.T) {
+		s := newStore(t)
+		chat, err := s.CreateChat("Test Chat")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+		var ids []string
+		for i := 1; i <= 3; i++ {
+			msg, err := s.AddMessage(chat.ID, "user", fmt.Sprintf("Message %d", i))
+			if err != nil {
+				t.Fatalf("Failed to add message %d: %v", i, err)
+			}
+			ids = append(ids, msg.ID)
+		}
+		if _, err := s.EditMessage(ids[1], "The secret is 42"); err != nil {
+			t.Fatalf("Failed to edit message: %v", err)
+		}
+		sub := s.Subscribe(chat.ID)
+		defer sub.Close()
+
+		tombstone, err := s.DeleteMessage(ids[1])
+		if err != nil {
+			t.Fatalf("Failed to delete message: %v", err)
+		}
+		if tombstone.DeletedAt == nil || tombstone.Content != "" || tombstone.Seq != 2 || tombstone.Username != "user" {
+			t.Errorf("Expected a tombstone keeping sequence and author, got %+v", tombstone)
+		}
+		if revisions, _ := s.GetRevisions(ids[1]); len(revisions) != 0 {
+			t.Errorf("Expected the revision history to be dropped, got %+v", revisions)
+		}
+
+		// The tombstone keeps its place, so cursors around it still work
+		messages, _ := s.GetMessages(chat.ID)
+		if len(messages) != 3 || messages[1].ID != ids[1] || messages[1].DeletedAt == nil {
+			t.Errorf("Expected the tombstone in place of the message, got %+v", messages)
+		}
+		page, err := s.GetMessagePage(chat.ID, storage.PageQuery{Limit: 10, After: ids[1]})
+		if err != nil || len(page.Messages) != 1 || page.Messages[0].ID != ids[2] {
+			t.Errorf("Expected the tombstone to remain a valid cursor, got %+v, %v", page, err)
+		}
+
+		select {
+		case event := <-sub.Events():
+			if event.Type != models.EventDelete || event.Message.ID != ids[1] {
+				t.Errorf("Expected delete event, got %+v", event)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for delete event")
+		}
+
+		if again, err := s.DeleteMessage(ids[1]); err != nil || again.ID != ids[1] {
+			t.Errorf("Expected deleting twice to return the tombstone, got %+v, %v", again, err)
+		}
+		if _, err := s.EditMessage(ids[1], "Back"); !errors.Is(err, storage.ErrMessageDeleted) {
+			t.Errorf("Expected ErrMessageDeleted, got %v", err)
+		}
+		if _, err := s.DeleteMessage("nonexistent"); !errors.Is(err, storage.ErrMessageNotFound) {
+			t.Errorf("Expected ErrMessageNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Threads", func(t *testing.T) {
+		s := newStore(t)
+		chat, err := s.CreateChat("Test Chat")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+		root, err := s.AddMessage(chat.ID, "alice", "Lunch?")
+		if err != nil {
+			t.Fatalf("Failed to add message: %v", err)
+		}
+		if _, err := s.AddMessage(chat.ID, "bob", "Unrelated"); err != nil {
+			t.Fatalf("Failed to add message: %v", err)
+		}
+		reply, err := s.Post(storage.NewMessage{ChatID: chat.ID, Username: "bob", Content: "Sure", ReplyTo: root.ID})
+		if err != nil {
+			t.Fatalf("Failed to post reply: %v", err)
+		}
+		if reply.ReplyTo != root.ID || reply.ThreadID != root.ID || reply.Seq != 3 {
+			t.Errorf("Expected a reply in the root's thread, got %+v", reply)
+		}
+		// Replying to a reply stays in the same thread
+		nested, err := s.Post(storage.NewMessage{ChatID: chat.ID, Username: "alice", Content: "Noon", ReplyTo: reply.ID})
+		if err != nil {
+			t.Fatalf("Failed to post reply: %v", err)
+		}
+		if nested.ReplyTo != reply.ID || nested.ThreadID != root.ID {
+			t.Errorf("Expected a nested reply in the root's thread, got %+v", nested)
+		}
+
+		if got, _ := s.GetMessage(root.ID); got.ReplyCount != 2 {
+			t.Errorf("Expected 2 replies counted on the root, got %d", got.ReplyCount)
+		}
+		if root.ReplyCount != 0 {
+			t.Error("Expected the previously returned root to be left unchanged")
+		}
+
+		for _, id := range []string{root.ID, nested.ID} {
+			thread, err := s.GetThread(id)
+			if err != nil {
+				t.Fatalf("Failed to get thread: %v", err)
+			}
+			if len(thread) != 3 || thread[0].ID != root.ID || thread[1].ID != reply.ID || thread[2].ID != nested.ID {
+				t.Errorf("Expected the root and its two replies, got %+v", thread)
+			}
+		}
+
+		// Edits and deletions keep messages in their thread
+		if _, err := s.EditMessage(root.ID, "Lunch at noon?"); err != nil {
+			t.Fatalf("Failed to edit message: %v", err)
+		}
+		if _, err := s.DeleteMessage(reply.ID); err != nil {
+			t.Fatalf("Failed to delete message: %v", err)
+		}
+		thread, _ := s.GetThread(root.ID)
+		if len(thread) != 3 || thread[0].ReplyCount != 2 || thread[1].DeletedAt == nil || thread[1].ThreadID != root.ID {
+			t.Errorf("Expected the thread to survive edits and deletions, got %+v", thread)
+		}
+	})
+
+	t.Run("Threads_Errors", func(t *testing.T) {
+		s := newStore(t)
+		chat, err := s.CreateChat("Test Chat")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+		other, err := s.CreateChat("Other Chat")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+		foreign, err := s.AddMessage(other.ID, "user", "Elsewhere")
+		if err != nil {
+			t.Fatalf("Failed to add message: %v", err)
+		}
+		deleted, err := s.AddMessage(chat.ID, "user", "Gone")
+		if err != nil {
+			t.Fatalf("Failed to add message: %v", err)
+		}
+		if _, err := s.DeleteMessage(deleted.ID); err != nil {
+			t.Fatalf("Failed to delete message: %v", err)
+		}
+
+		for _, tt := range []struct {
+			name    string
+			replyTo string
+			want    error
+		}{
+			{"Unknown", "nonexistent", storage.ErrMessageNotFound},
+			{"OtherChat", foreign.ID, storage.ErrMessageNotFound},
+			{"Deleted", deleted.ID, storage.ErrMessageDeleted},
+		} {
+			_, err := s.Post(storage.NewMessage{ChatID: chat.ID, Username: "user", Content: "Reply", ReplyTo: tt.replyTo})
+			if !errors.Is(err, tt.want) {
+				t.Errorf("%s: expected %v, got %v", tt.name, tt.want, err)
+			}
+		}
+		if _, err := s.GetThread("nonexistent"); !errors.Is(err, storage.ErrMessageNotFound) {
+			t.Errorf("Expected ErrMessageNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Reactions", func(t *testing.T) {
+		s := newStore(t)
+		chat, err := s.CreateChat("Test Chat")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+		msg, err := s.AddMessage(chat.ID, "alice", "Deployed")
+		if err != nil {
+			t.Fatalf("Failed to add message: %v", err)
+		}
+		sub := s.Subscribe(chat.ID)
+		defer sub.Close()
+
+		for _, r := range []struct{ username, emoji string }{
+			{"alice", ":thumbsup:"},
+			{"bob", ":thumbsup:"},
+			{"BOB", ":thumbsup:"},
+			{"bob", ":tada:"},
+		} {
+			if _, err := s.AddReaction(msg.ID, r.username, r.emoji); err != nil {
+				t.Fatalf("Failed to add reaction: %v", err)
+			}
+		}
+
+		messages, _ := s.GetMessages(chat.ID)
+		counts := messages[0].Reactions
+		if len(counts) != 2 || counts[":thumbsup:"] != 2 || counts[":tada:"] != 1 {
+			t.Errorf("Expected 2 :thumbsup: and 1 :tada:, got %v", counts)
+		}
+		if msg.Reactions != nil {
+			t.Error("Expected the previously returned message to be left unchanged")
+		}
+		reactions, err := s.ListReactions(msg.ID)
+		if err != nil {
+			t.Fatalf("Failed to list reactions: %v", err)
+		}
+		if len(reactions) != 3 || reactions[0].Username != "alice" {
+			t.Errorf("Expected 3 reactions, alice's first, got %+v", reactions)
+		}
+
+		select {
+		case event := <-sub.Events():
+			if event.Type != models.EventReaction || event.Message.Reactions[":thumbsup:"] != 1 {
+				t.Errorf("Expected reaction event, got %+v", event)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for reaction event")
+		}
+
+		updated, err := s.RemoveReaction(msg.ID, "Bob", ":tada:")
+		if err != nil {
+			t.Fatalf("Failed to remove reaction: %v", err)
+		}
+		if _, present := updated.Reactions[":tada:"]; present || updated.Reactions[":thumbsup:"] != 2 {
+			t.Errorf("Expected only :thumbsup: left, got %v", updated.Reactions)
+		}
+		if _, err := s.RemoveReaction(msg.ID, "bob", ":tada:"); !errors.Is(err, storage.ErrReactionNotFound) {
+			t.Errorf("Expected ErrReactionNotFound, got %v", err)
+		}
+
+		// Deleting the message drops its reactions
+		if _, err := s.DeleteMessage(msg.ID); err != nil {
+			t.Fatalf("Failed to delete message: %v", err)
+		}
+		if reactions, _ := s.ListReactions(msg.ID); len(reactions) != 0 {
+			t.Errorf("Expected no reactions on the tombstone, got %+v", reactions)
+		}
+		if _, err := s.AddReaction(msg.ID, "alice", ":tada:"); !errors.Is(err, storage.ErrMessageDeleted) {
+			t.Errorf("Expected ErrMessageDeleted, got %v", err)
+		}
+		if _, err := s.AddReaction("nonexistent", "alice", ":tada:"); !errors.Is(err, storage.ErrMessageNotFound) {
+			t.Errorf("Expected ErrMessageNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Search", func(t *testing.T) {
+		s := newStore(t)
+		chat, err := s.CreateChat("Ops")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+		other, err := s.CreateChat("Random")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+		add := func(chatID, username, content string) *models.Message {
+			t.Helper()
+			msg, err := s.AddMessage(chatID, username, content)
+			if err != nil {
+				t.Fatalf("Failed to add message: %v", err)
+			}
+			return msg
+		}
+		link := add(chat.ID, "alice", "Deploy link: https://ci.example.com/deploy/42")
+		deployed := add(chat.ID, "bob", "Deployed the fix")
+		add(chat.ID, "bob", "Lunch anyone?")
+		elsewhere := add(other.ID, "alice", "Deploy day")
+		both := []string{chat.ID, other.ID}
+
+		search := func(query storage.SearchQuery) []string {
+			t.Helper()
+			var ids []string
+			for _, result := range s.Search(query) {
+				ids = append(ids, result.Message.ID)
+			}
+			return ids
+		}
+
+		// The link mentions deploy twice and matches exactly
+		got := search(storage.SearchQuery{Text: "DEPLOY", ChatIDs: both})
+		if len(got) != 3 || got[0] != link.ID {
+			t.Errorf("Expected 3 matches with the link first, got %v", got)
+		}
+		if got := search(storage.SearchQuery{Text: "deploy link", ChatIDs: both}); !slices.Equal(got, []string{link.ID}) {
+			t.Errorf("Expected every word to be required, got %v", got)
+		}
+		if got := search(storage.SearchQuery{Text: "deploy", ChatIDs: []string{other.ID}}); !slices.Equal(got, []string{elsewhere.ID}) {
+			t.Errorf("Expected only the other chat's message, got %v", got)
+		}
+		if got := search(storage.SearchQuery{Text: "deploy", ChatIDs: both, Username: "BOB"}); !slices.Equal(got, []string{deployed.ID}) {
+			t.Errorf("Expected only bob's message, got %v", got)
+		}
+		window := storage.SearchQuery{Text: "deploy", ChatIDs: both, From: deployed.Timestamp, To: elsewhere.Timestamp}
+		if got := search(window); !slices.Equal(got, []string{deployed.ID}) {
+			t.Errorf("Expected only the message within the time range, got %v", got)
+		}
+		if got := search(storage.SearchQuery{Text: "deploy", ChatIDs: both, Limit: 1}); len(got) != 1 {
+			t.Errorf("Expected the limit to apply, got %v", got)
+		}
+		if got := search(storage.SearchQuery{Text: "deploy"}); len(got) != 0 {
+			t.Errorf("Expected nothing found without chats, got %v", got)
+		}
+
+		results := s.Search(storage.SearchQuery{Text: "fix deploy", ChatIDs: both})
+		if len(results) != 1 || results[0].Highlight != "**Deployed** the **fix**" {
+			t.Errorf("Expected the matching words highlighted, got %+v", results)
+		}
+
+		// The index follows edits and deletions
+		if _, err := s.EditMessage(deployed.ID, "Rolled back the fix"); err != nil {
+			t.Fatalf("Failed to edit message: %v", err)
+		}
+		if got := search(storage.SearchQuery{Text: "deployed", ChatIDs: both}); len(got) != 0 {
+			t.Errorf("Expected the old content to be forgotten, got %v", got)
+		}
+		if got := search(storage.SearchQuery{Text: "rolled", ChatIDs: both}); !slices.Equal(got, []string{deployed.ID}) {
+			t.Errorf("Expected the new content to be found, got %v", got)
+		}
+		if _, err := s.DeleteMessage(link.ID); err != nil {
+			t.Fatalf("Failed to delete message: %v", err)
+		}
+		if got := search(storage.SearchQuery{Text: "link", ChatIDs: both}); len(got) != 0 {
+			t.Errorf("Expected deleted messages not to be found, got %v", got)
+		}
+	})
+
+	t.Run("CreateChatWithOwner", func(t *testing.T) {
+		s := newStore(t)
+		if _, err := s.CreateUser("Alice", "hash"); err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		chat, err := s.CreateChatWithOwner("Owned Chat", "alice", models.VisibilityPrivate)
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+
+		if chat.Visibility != models.VisibilityPrivate {
+			t.Errorf("Expected a private chat, got visibility '%s'", chat.Visibility)
+		}
+		member, exists := s.GetMember(chat.ID, "ALICE")
+		if !exists {
+			t.Fatal("Expected the owner to be a member")
+		}
+		if member.Role != models.RoleOwner || member.Username != "Alice" {
+			t.Errorf("Expected Alice as owner, got %+v", member)
+		}
+
+		if _, err := s.CreateChatWithOwner("Orphan Chat", "nobody", ""); !errors.Is(err, storage.ErrUserNotFound) {
+			t.Errorf("Expected ErrUserNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Members", func(t *testing.T) {
+		s := newStore(t)
+		for _, name := range []string{"alice", "bob"} {
+			if _, err := s.CreateUser(name, "hash"); err != nil {
+				t.Fatalf("Failed to create user: %v", err)
+			}
+		}
+		chat, err := s.CreateChatWithOwner("Team", "alice", "")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+
+		added, err := s.SetMember(chat.ID, "bob", models.RoleMember)
+		if err != nil {
+			t.Fatalf("Failed to add member: %v", err)
+		}
+		promoted, err := s.SetMember(chat.ID, "bob", models.RoleAdmin)
+		if err != nil {
+			t.Fatalf("Failed to change role: %v", err)
+		}
+		if promoted.Role != models.RoleAdmin || !promoted.JoinedAt.Equal(added.JoinedAt) {
+			t.Errorf("Expected admin role with the original join time, got %+v", promoted)
+		}
+		if added.Role != models.RoleMember {
+			t.Error("Expected the previously returned member to be left unchanged")
+		}
+
+		members, err := s.ListMembers(chat.ID)
+		if err != nil {
+			t.Fatalf("Failed to list members: %v", err)
+		}
+		if len(members) != 2 || members[0].Username != "alice" || members[1].Username != "bob" {
+			t.Errorf("Expected alice then bob, got %+v", members)
+		}
+
+		if err := s.RemoveMember(chat.ID, "bob"); err != nil {
+			t.Fatalf("Failed to remove member: %v", err)
+		}
+		if _, exists := s.GetMember(chat.ID, "bob"); exists {
+			t.Error("Expected bob to no longer be a member")
+		}
+		if err := s.RemoveMember(chat.ID, "bob"); !errors.Is(err, storage.ErrMemberNotFound) {
+			t.Errorf("Expected ErrMemberNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Members_Errors", func(t *testing.T) {
+		s := newStore(t)
+		chat, err := s.CreateChat("Test Chat")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+		if _, err := s.SetMember(chat.ID, "nobody", models.RoleMember); !errors.Is(err, storage.ErrUserNotFound) {
+			t.Errorf("Expected ErrUserNotFound, got %v", err)
+		}
+		if _, err := s.SetMember("nonexistent", "nobody", models.RoleMember); !errors.Is(err, storage.ErrChatNotFound) {
+			t.Errorf("Expected ErrChatNotFound, got %v", err)
+		}
+		if _, err := s.ListMembers("nonexistent"); !errors.Is(err, storage.ErrChatNotFound) {
+			t.Errorf("Expected ErrChatNotFound, got %v", err)
+		}
+		if err := s.RemoveMember("nonexistent", "nobody"); !errors.Is(err, storage.ErrChatNotFound) {
+			t.Errorf("Expected ErrChatNotFound, got %v", err)
+		}
+		if members, err := s.ListMembers(chat.ID); err != nil || len(members) != 0 {
+			t.Errorf("Expected no members, got %v, %v", members, err)
+		}
+	})
+
+	t.Run("Invites", func(t *testing.T) {
+		s := newStore(t)
+		for _, name := range []string{"alice", "bob", "carol"} {
+			if _, err := s.CreateUser(name, "hash"); err != nil {
+				t.Fatalf("Failed to create user: %v", err)
+			}
+		}
+		chat, err := s.CreateChatWithOwner("Secret", "alice", models.VisibilityPrivate)
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+
+		invite, err := s.CreateInvite("hash1", models.Invite{ChatID: chat.ID, CreatedBy: "alice", MaxUses: 1})
+		if err != nil {
+			t.Fatalf("Failed to create invite: %v", err)
+		}
+		if invite.ID == "" || invite.CreatedAt.IsZero() || invite.Uses != 0 {
+			t.Errorf("Expected an ID, creation time and no uses, got %+v", invite)
+		}
+
+		// Members redeeming an invite keep their role and use nothing up
+		if member, err := s.RedeemInvite("hash1", "alice"); err != nil || member.Role != models.RoleOwner {
+			t.Errorf("Expected alice to stay the owner, got %+v, %v", member, err)
+		}
+
+		member, err := s.RedeemInvite("hash1", "BOB")
+		if err != nil {
+			t.Fatalf("Failed to redeem invite: %v", err)
+		}
+		if member.Username != "bob" || member.Role != models.RoleMember || member.ChatID != chat.ID {
+			t.Errorf("Expected bob to become a member, got %+v", member)
+		}
+		if _, exists := s.GetMember(chat.ID, "bob"); !exists {
+			t.Error("Expected bob to be stored as a member")
+		}
+
+		if _, err := s.RedeemInvite("hash1", "carol"); !errors.Is(err, storage.ErrInviteExpired) {
+			t.Errorf("Expected ErrInviteExpired once used up, got %v", err)
+		}
+		if _, err := s.RedeemInvite("unknown", "carol"); !errors.Is(err, storage.ErrInviteNotFound) {
+			t.Errorf("Expected ErrInviteNotFound, got %v", err)
+		}
+		if _, err := s.RedeemInvite("hash1", "nobody"); !errors.Is(err, storage.ErrUserNotFound) {
+			t.Errorf("Expected ErrUserNotFound, got %v", err)
+		}
+		if _, err := s.CreateInvite("hash2", models.Invite{ChatID: "nonexistent"}); !errors.Is(err, storage.ErrChatNotFound) {
+			t.Errorf("Expected ErrChatNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Invites_Expired", func(t *testing.T) {
+		s := newStore(t)
+		if _, err := s.CreateUser("alice", "hash"); err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		chat, err := s.CreateChat("Test Chat")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+
+		expired := time.Now().Add(-time.Minute)
+		if _, err := s.CreateInvite("hash", models.Invite{ChatID: chat.ID, ExpiresAt: &expired}); err != nil {
+			t.Fatalf("Failed to create invite: %v", err)
+		}
+		if _, err := s.RedeemInvite("hash", "alice"); !errors.Is(err, storage.ErrInviteExpired) {
+			t.Errorf("Expected ErrInviteExpired, got %v", err)
+		}
+
+		// Deleting a chat removes its invites
+		if err := s.DeleteChat(chat.ID); err != nil {
+			t.Fatalf("Failed to delete chat: %v", err)
+		}
+		if _, err := s.RedeemInvite("hash", "alice"); !errors.Is(err, storage.ErrInviteNotFound) {
+			t.Errorf("Expected ErrInviteNotFound, got %v", err)
+		}
+	})
+
+	t.Run("DirectChats", func(t *testing.T) {
+		s := newStore(t)
+		for _, name := range []string{"Alice", "bob", "carol"} {
+			if _, err := s.CreateUser(name, "hash"); err != nil {
+				t.Fatalf("Failed to create user: %v", err)
+			}
+		}
+
+		chat, created, err := s.OpenDirectChat("alice", "bob")
+		if err != nil {
+			t.Fatalf("Failed to open direct chat: %v", err)
+		}
+		if !created || chat.Visibility != models.VisibilityDirect {
+			t.Errorf("Expected a new direct chat, got %+v", chat)
+		}
+		if len(chat.Participants) != 2 || chat.Participants[0] != "Alice" || chat.Participants[1] != "bob" {
+			t.Errorf("Expected participants Alice and bob, got %v", chat.Participants)
+		}
+		for _, name := range []string{"alice", "bob"} {
+			if member, exists := s.GetMember(chat.ID, name); !exists || member.Role != models.RoleMember {
+				t.Errorf("Expected %s to be a member, got %+v", name, member)
+			}
+		}
+
+		// The pair has a single chat whichever way round it is opened
+		again, created, err := s.OpenDirectChat("BOB", "alice")
+		if err != nil || created || again.ID != chat.ID {
+			t.Errorf("Expected the existing chat %s, got %+v, %v, %v", chat.ID, again, created, err)
+		}
+		other, _, err := s.OpenDirectChat("carol", "alice")
+		if err != nil || other.ID == chat.ID {
+			t.Errorf("Expected a separate chat for another pair, got %+v, %v", other, err)
+		}
+
+		if chats := s.ListDirectChats("ALICE"); len(chats) != 2 || chats[0].ID != chat.ID || chats[1].ID != other.ID {
+			t.Errorf("Expected alice's two direct chats, got %+v", chats)
+		}
+		if chats := s.ListDirectChats("bob"); len(chats) != 1 || chats[0].ID != chat.ID {
+			t.Errorf("Expected bob's direct chat, got %+v", chats)
+		}
+
+		if _, _, err := s.OpenDirectChat("alice", "Alice"); !errors.Is(err, storage.ErrSameUser) {
+			t.Errorf("Expected ErrSameUser, got %v", err)
+		}
+		if _, _, err := s.OpenDirectChat("alice", "nobody"); !errors.Is(err, storage.ErrUserNotFound) {
+			t.Errorf("Expected ErrUserNotFound, got %v", err)
+		}
+
+		// Deleting a direct chat lets the pair start over
+		if err := s.DeleteChat(chat.ID); err != nil {
+			t.Fatalf("Failed to delete chat: %v", err)
+		}
+		if chats := s.ListDirectChats("bob"); len(chats) != 0 {
+			t.Errorf("Expected no direct chats for bob, got %d", len(chats))
+		}
+		fresh, created, err := s.OpenDirectChat("alice", "bob")
+		if err != nil || !created || fresh.ID == chat.ID {
+			t.Errorf("Expected a new direct chat, got %+v, %v, %v", fresh, created, err)
+		}
+	})
+
+	t.Run("UpdateChat_Name", func(t *testing.T) {
+		s := newStore(t)
+		chat, err := s.CreateChat("Old Name")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+
+		name := "New Name"
+		renamed, err := s.UpdateChat(chat.ID, storage.ChatUpdate{Name: &name})
+		if err != nil {
+			t.Fatalf("Failed to rename chat: %v", err)
+		}
+		if renamed.Name != "New Name" || renamed.ID != chat.ID || !renamed.CreatedAt.Equal(chat.CreatedAt) {
+			t.Errorf("Expected only the name to change, got %+v", renamed)
+		}
+		if chat.Name != "Old Name" {
+			t.Error("Expected the previously returned chat to be left unchanged")
+		}
+		if got, _ := s.GetChat(chat.ID); got.Name != "New Name" {
+			t.Errorf("Expected stored name 'New Name', got '%s'", got.Name)
+		}
+
+		if _, err := s.UpdateChat("nonexistent", storage.ChatUpdate{Name: &name}); !errors.Is(err, storage.ErrChatNotFound) {
+			t.Errorf("Expected ErrChatNotFound, got %v", err)
+		}
+	})
+
+	t.Run("UpdateChat", func(t *testing.T) {
+		s := newStore(t)
+		chat, err := s.CreateChat("Team")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+
+		topic, description := "Release planning", "Where we plan releases"
+		updated, err := s.UpdateChat(chat.ID, storage.ChatUpdate{Topic: &topic, Description: &description})
+		if err != nil {
+			t.Fatalf("Failed to update chat: %v", err)
+		}
+		if updated.Name != "Team" || updated.Topic != topic || updated.Description != description {
+			t.Errorf("Expected the topic and description to change, got %+v", updated)
+		}
+
+		// Empty strings clear, nil fields are left alone
+		empty := ""
+		updated, err = s.UpdateChat(chat.ID, storage.ChatUpdate{Topic: &empty})
+		if err != nil {
+			t.Fatalf("Failed to update chat: %v", err)
+		}
+		if updated.Topic != "" || updated.Description != description {
+			t.Errorf("Expected only the topic to be cleared, got %+v", updated)
+		}
+
+		archive, restore := true, false
+		archived, err := s.UpdateChat(chat.ID, storage.ChatUpdate{Archived: &archive})
+		if err != nil {
+			t.Fatalf("Failed to archive chat: %v", err)
+		}
+		if archived.ArchivedAt == nil {
+			t.Fatal("Expected the chat to be archived")
+		}
+		again, _ := s.UpdateChat(chat.ID, storage.ChatUpdate{Archived: &archive})
+		if !again.ArchivedAt.Equal(*archived.ArchivedAt) {
+			t.Error("Expected archiving twice to keep the first archive time")
+		}
+		if got, _ := s.GetChat(chat.ID); got.ArchivedAt == nil {
+			t.Error("Expected the stored chat to be archived")
+		}
+		if restored, _ := s.UpdateChat(chat.ID, storage.ChatUpdate{Archived: &restore}); restored.ArchivedAt != nil {
+			t.Error("Expected the chat to be restored")
+		}
+
+		if _, err := s.UpdateChat("nonexistent", storage.ChatUpdate{Topic: &topic}); !errors.Is(err, storage.ErrChatNotFound) {
+			t.Errorf("Expected ErrChatNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Retention", func(t *testing.T) {
+		s := newStore(t)
+		create := func(name string, messages int) *models.Chat {
+			t.Helper()
+			chat, err := s.CreateChat(name)
+			if err != nil {
+				t.Fatalf("Failed to create chat: %v", err)
+			}
+			for i := 1; i <= messages; i++ {
+				if _, err := s.AddMessage(chat.ID, "alice", fmt.Sprintf("%s message %d", name, i)); err != nil {
+					t.Fatalf("Failed to add message %d: %v", i, err)
+				}
+			}
+			return chat
+		}
+		messagesOf := func(chatID string) []*models.Message {
+			t.Helper()
+			messages, _ := s.GetMessages(chatID)
+			return messages
+		}
+		capped := create("Capped", 5)
+		aging := create("Aging", 2)
+		held := create("Held", 5)
+		first := messagesOf(capped.ID)[0]
+
+		if _, err := s.SetRetention(aging.ID, &models.RetentionPolicy{MaxAge: models.Duration(time.Hour)}); err != nil {
+			t.Fatalf("Failed to set retention: %v", err)
+		}
+		hold := true
+		if _, err := s.UpdateChat(held.ID, storage.ChatUpdate{LegalHold: &hold}); err != nil {
+			t.Fatalf("Failed to place legal hold: %v", err)
+		}
+
+		sub := s.Subscribe(capped.ID)
+		defer sub.Close()
+
+		defaults := models.RetentionPolicy{MaxMessages: 3}
+		expired, err := s.ApplyRetention(defaults, time.Now().Add(2*time.Hour))
+		if err != nil {
+			t.Fatalf("Failed to apply retention: %v", err)
+		}
+		if len(expired) != 4 {
+			t.Errorf("Expected 2 capped and 2 aged messages to expire, got %d", len(expired))
+		}
+		for _, msg := range expired {
+			if msg.DeletedAt == nil || msg.Content != "" {
+				t.Errorf("Expected tombstones of expired messages, got %+v", msg)
+			}
+		}
+
+		if messages := messagesOf(capped.ID); len(messages) != 3 || messages[0].Seq != 3 {
+			t.Errorf("Expected the 3 newest messages to be kept, got %+v", messages)
+		}
+		if messages := messagesOf(aging.ID); len(messages) != 0 {
+			t.Errorf("Expected the chat's own policy to expire old messages, got %+v", messages)
+		}
+		if messages := messagesOf(held.ID); len(messages) != 5 {
+			t.Errorf("Expected legal hold to keep every message, got %d", len(messages))
+		}
+		if summary, _ := s.GetChatSummary(capped.ID); summary.MessageCount != 3 {
+			t.Errorf("Expected expired messages to leave the count, got %d", summary.MessageCount)
+		}
+		if _, exists := s.GetMessage(first.ID); exists {
+			t.Error("Expected the expired message to be gone")
+		}
+		results := s.Search(storage.SearchQuery{Text: "capped", ChatIDs: []string{capped.ID}})
+		if len(results) != 3 {
+			t.Errorf("Expected expired messages to leave the search index, got %d results", len(results))
+		}
+
+		select {
+		case event := <-sub.Events():
+			if event.Type != models.EventExpire || len(event.Messages) != 2 || event.Messages[0].ID != first.ID {
+				t.Errorf("Expected one expiry of the oldest messages, got %+v", event)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for the expire event")
+		}
+
+		// New messages continue the sequence
+		msg, err := s.AddMessage(capped.ID, "alice", "After expiry")
+		if err != nil {
+			t.Fatalf("Failed to add message: %v", err)
+		}
+		if msg.Seq != 6 {
+			t.Errorf("Expected sequence 6, got %d", msg.Seq)
+		}
+
+		chat, err := s.SetRetention(aging.ID, nil)
+		if err != nil {
+			t.Fatalf("Failed to clear retention: %v", err)
+		}
+		if chat.Retention != nil {
+			t.Errorf("Expected the chat to follow the defaults again, got %+v", chat.Retention)
+		}
+		if _, err := s.SetRetention("nonexistent", nil); !errors.Is(err, storage.ErrChatNotFound) {
+			t.Errorf("Expected ErrChatNotFound, got %v", err)
+		}
+	})
+
+	t.Run("DeleteChat", func(t *testing.T) {
+		s := newStore(t)
+		chat, err := s.CreateChat("Doomed Chat")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+		msg, err := s.AddMessage(chat.ID, "user", "Goodbye")
+		if err != nil {
+			t.Fatalf("Failed to add message: %v", err)
+		}
+		kept, err := s.CreateChat("Kept Chat")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+
+		if err := s.DeleteChat(chat.ID); err != nil {
+			t.Fatalf("Failed to delete chat: %v", err)
+		}
+		if _, exists := s.GetChat(chat.ID); exists {
+			t.Error("Expected chat to be gone")
+		}
+		if _, exists := s.GetMessages(chat.ID); exists {
+			t.Error("Expected messages to be gone")
+		}
+		if chats := s.ListChats(); len(chats) != 1 || chats[0].ID != kept.ID {
+			t.Errorf("Expected only the kept chat to be listed, got %+v", chats)
+		}
+		if _, err := s.GetMessagePage(kept.ID, storage.PageQuery{Limit: 10, Before: msg.ID}); !errors.Is(err, storage.ErrInvalidCursor) {
+			t.Errorf("Expected a deleted message to be an invalid cursor, got %v", err)
+		}
+
+		if err := s.DeleteChat(chat.ID); !errors.Is(err, storage.ErrChatNotFound) {
+			t.Errorf("Expected ErrChatNotFound, got %v", err)
+		}
+	})
+
+	t.Run("CreateUser", func(t *testing.T) {
+		s := newStore(t)
+		user, err := s.CreateUser("Alice", "hash")
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		if user.ID == "" || user.Username != "Alice" || user.CreatedAt.IsZero() {
+			t.Errorf("Expected a populated user named Alice, got %+v", user)
+		}
+
+		// Lookups ignore case and keep the registered spelling
+		account, exists := s.GetAccount("alice")
+		if !exists {
+			t.Fatal("Expected account to exist")
+		}
+		if account.User.ID != user.ID || account.User.Username != "Alice" {
+			t.Errorf("Expected user %s named Alice, got %+v", user.ID, account.User)
+		}
+		if account.PasswordHash != "hash" {
+			t.Errorf("Expected password hash 'hash', got '%s'", account.PasswordHash)
+		}
+	})
+
+	t.Run("CreateUser_UsernameTaken", func(t *testing.T) {
+		s := newStore(t)
+		if _, err := s.CreateUser("alice", "hash"); err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		if _, err := s.CreateUser("ALICE", "other"); !errors.Is(err, storage.ErrUsernameTaken) {
+			t.Errorf("Expected ErrUsernameTaken, got %v", err)
+		}
+	})
+
+	t.Run("GetAccount_NonExistent", func(t *testing.T) {
+		s := newStore(t)
+		if _, exists := s.GetAccount("nobody"); exists {
+			t.Error("Expected account to not exist")
+		}
+	})
+
+	t.Run("Sessions", func(t *testing.T) {
+		s := newStore(t)
+		user, err := s.CreateUser("alice", "hash")
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+
+		created, err := s.CreateSession("token-hash", "ALICE", time.Hour)
+		if err != nil {
+			t.Fatalf("Failed to create session: %v", err)
+		}
+		if created.UserID != user.ID || created.Username != "alice" {
+			t.Errorf("Expected session for user %s named alice, got %+v", user.ID, created)
+		}
+		if !created.ExpiresAt.After(created.CreatedAt) {
+			t.Errorf("Expected expiry after creation, got %v", created.ExpiresAt)
+		}
+
+		session, exists := s.GetSession("token-hash")
+		if !exists {
+			t.Fatal("Expected session to exist")
+		}
+		if session.UserID != user.ID {
+			t.Errorf("Expected session for user %s, got %s", user.ID, session.UserID)
+		}
+		if _, exists := s.GetSession("other-hash"); exists {
+			t.Error("Expected unknown session to not exist")
+		}
+	})
+
+	t.Run("Sessions_Expired", func(t *testing.T) {
+		s := newStore(t)
+		if _, err := s.CreateUser("alice", "hash"); err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		if _, err := s.CreateSession("token-hash", "alice", -time.Second); err != nil {
+			t.Fatalf("Failed to create session: %v", err)
+		}
+		if _, exists := s.GetSession("token-hash"); exists {
+			t.Error("Expected expired session to not be returned")
+		}
+	})
+
+	t.Run("Sessions_UnknownUser", func(t *testing.T) {
+		s := newStore(t)
+		if _, err := s.CreateSession("token-hash", "nobody", time.Hour); !errors.Is(err, storage.ErrUserNotFound) {
+			t.Errorf("Expected ErrUserNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Post_Bot", func(t *testing.T) {
+		s := newStore(t)
+		chat, err := s.CreateChat("Test Chat")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+
+		msg, err := s.Post(storage.NewMessage{ChatID: chat.ID, Username: "ci-bot", Content: "Build passed", Bot: true})
+		if err != nil {
+			t.Fatalf("Failed to post message: %v", err)
+		}
+		if !msg.Bot || msg.Username != "ci-bot" || msg.Seq != 1 {
+			t.Errorf("Expected bot message 1 from ci-bot, got %+v", msg)
+		}
+
+		messages, _ := s.GetMessages(chat.ID)
+		if len(messages) != 1 || !messages[0].Bot {
+			t.Errorf("Expected the stored message to be flagged as from a bot, got %+v", messages)
+		}
+
+		if msg, err := s.Post(storage.NewMessage{ChatID: "nonexistent", Username: "ci-bot", Content: "Hi"}); msg != nil || !errors.Is(err, storage.ErrChatNotFound) {
+			t.Errorf("Expected ErrChatNotFound for a missing chat, got %v, %v", msg, err)
+		}
+	})
+
+	t.Run("APIKeys", func(t *testing.T) {
+		s := newStore(t)
+		created, err := s.CreateAPIKey("hash-1", models.APIKey{
+			BotName: "ci-bot",
+			Owner:   "alice",
+			Chats:   []string{"chat-1"},
+			Scopes:  []string{models.ScopeWrite},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create API key: %v", err)
+		}
+		if created.ID == "" || created.CreatedAt.IsZero() {
+			t.Errorf("Expected key to have an ID and creation time, got %+v", created)
+		}
+
+		key, exists := s.GetAPIKey("hash-1")
+		if !exists {
+			t.Fatal("Expected API key to exist")
+		}
+		if key.ID != created.ID || key.BotName != "ci-bot" || len(key.Chats) != 1 {
+			t.Errorf("Expected key %s for ci-bot limited to one chat, got %+v", created.ID, key)
+		}
+
+		// The same owner may hold several keys for one bot
+		if _, err := s.CreateAPIKey("hash-2", models.APIKey{BotName: "ci-bot", Owner: "alice", Scopes: []string{models.ScopeRead}}); err != nil {
+			t.Fatalf("Failed to create second API key: %v", err)
+		}
+		keys := s.ListAPIKeys("alice")
+		if len(keys) != 2 || keys[0].ID != created.ID {
+			t.Errorf("Expected alice's 2 keys oldest first, got %+v", keys)
+		}
+		if keys := s.ListAPIKeys("bob"); len(keys) != 0 {
+			t.Errorf("Expected no keys for bob, got %d", len(keys))
+		}
+	})
+
+	t.Run("APIKeys_Quota", func(t *testing.T) {
+		s := newStore(t)
+		var first *models.APIKey
+		for i := 0; i < storage.MaxAPIKeys; i++ {
+			key, err := s.CreateAPIKey(fmt.Sprintf("hash-%d", i), models.APIKey{BotName: "ci-bot", Owner: "alice"})
+			if err != nil {
+				t.Fatalf("Failed to create API key %d: %v", i, err)
+			}
+			if first == nil {
+				first = key
+			}
+		}
+
+		_, err := s.CreateAPIKey("hash-extra", models.APIKey{BotName: "ci-bot", Owner: "alice"})
+		if !errors.Is(err, storage.ErrAPIKeyQuota) || !errors.Is(err, storage.ErrQuota) {
+			t.Errorf("Expected ErrAPIKeyQuota, got %v", err)
+		}
+		if _, err := s.CreateAPIKey("hash-bob", models.APIKey{BotName: "bob-bot", Owner: "bob"}); err != nil {
+			t.Errorf("Expected other owners to be unaffected, got %v", err)
+		}
+
+		// Revoking a key makes room for another
+		if _, err := s.RevokeAPIKey("alice", first.ID); err != nil {
+			t.Fatalf("Failed to revoke API key: %v", err)
+		}
+		if _, err := s.CreateAPIKey("hash-extra", models.APIKey{BotName: "ci-bot", Owner: "alice"}); err != nil {
+			t.Errorf("Expected a key after revoking one, got %v", err)
+		}
+	})
+
+	t.Run("APIKeys_Revoke", func(t *testing.T) {
+		s := newStore(t)
+		created, err := s.CreateAPIKey("hash-1", models.APIKey{BotName: "ci-bot", Owner: "alice", Scopes: []string{models.ScopeRead}})
+		if err != nil {
+			t.Fatalf("Failed to create API key: %v", err)
+		}
+
+		if _, err := s.RevokeAPIKey("bob", created.ID); !errors.Is(err, storage.ErrAPIKeyNotFound) {
+			t.Errorf("Expected ErrAPIKeyNotFound revoking another owner's key, got %v", err)
+		}
+		revoked, err := s.RevokeAPIKey("alice", created.ID)
+		if err != nil {
+			t.Fatalf("Failed to revoke API key: %v", err)
+		}
+		if revoked.RevokedAt == nil {
+			t.Error("Expected revoked key to have a revocation time")
+		}
+		if created.RevokedAt != nil {
+			t.Error("Expected the previously returned key to be left unchanged")
+		}
+
+		if _, exists := s.GetAPIKey("hash-1"); exists {
+			t.Error("Expected revoked key to not be returned")
+		}
+		if keys := s.ListAPIKeys("alice"); len(keys) != 1 || keys[0].RevokedAt == nil {
+			t.Errorf("Expected the revoked key to still be listed, got %+v", keys)
+		}
+		if _, err := s.RevokeAPIKey("alice", "nonexistent"); !errors.Is(err, storage.ErrAPIKeyNotFound) {
+			t.Errorf("Expected ErrAPIKeyNotFound, got %v", err)
+		}
+	})
+
+	t.Run("APIKeys_BotNames", func(t *testing.T) {
+		s := newStore(t)
+		if _, err := s.CreateUser("alice", "hash"); err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		if _, err := s.CreateAPIKey("hash-1", models.APIKey{BotName: "Alice", Owner: "bob", Scopes: []string{models.ScopeRead}}); !errors.Is(err, storage.ErrBotNameTaken) {
+			t.Errorf("Expected ErrBotNameTaken for a username, got %v", err)
+		}
+
+		if _, err := s.CreateAPIKey("hash-2", models.APIKey{BotName: "ci-bot", Owner: "alice", Scopes: []string{models.ScopeRead}}); err != nil {
+			t.Fatalf("Failed to create API key: %v", err)
+		}
+		if _, err := s.CreateAPIKey("hash-3", models.APIKey{BotName: "CI-Bot", Owner: "bob", Scopes: []string{models.ScopeRead}}); !errors.Is(err, storage.ErrBotNameTaken) {
+			t.Errorf("Expected ErrBotNameTaken for another owner's bot, got %v", err)
+		}
+		if _, err := s.CreateUser("ci-bot", "hash"); !errors.Is(err, storage.ErrUsernameTaken) {
+			t.Errorf("Expected ErrUsernameTaken for a bot name, got %v", err)
+		}
+	})
+
+	t.Run("ConcurrentAccess", func(t *testing.T) {
+		s := newStore(t)
+		chat, err := s.CreateChat("Concurrent Chat")
+		if err != nil {
+			t.Fatalf("Failed to create chat: %v", err)
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(n int) {
+				defer wg.Done()
+				if _, err := s.AddMessage(chat.ID, "user", "Message"); err != nil {
+					t.Errorf("Failed to add message in goroutine %d: %v", n, err)
+				}
+				s.ListChats()
+				s.GetMessages(chat.ID)
+			}(i)
+		}
+		wg.Wait()
+
+		messages, _ := s.GetMessages(chat.ID)
+		if len(messages) != 10 {
+			t.Errorf("Expected 10 messages after concurrent writes, got %d", len(messages))
+		}
+	})
+}
@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"time"
+
+	"chat-app/internal/models"
+)
+
+// Store is the persistence backend used by the server. Every backend must
+// pass the conformance suite in the storagetest package.
+type Store interface {
+	// CreateChat creates a new chat with the given name
+	CreateChat(name string) (*models.Chat, error)
+	// CreateChatWithOwner creates a new chat whose first member is owner,
+	// with the owner role. An empty visibility makes the chat public. It
+	// returns ErrUserNotFound if owner does not exist.
+	CreateChatWithOwner(name, owner, visibility string) (*models.Chat, error)
+	// UpdateChat changes the name, topic, description, archive state or
+	// legal hold of a chat. It returns ErrChatNotFound if the chat does not
+	// exist.
+	UpdateChat(chatID string, update ChatUpdate) (*models.Chat, error)
+	// DeleteChat removes a chat with its messages and members, and ends its
+	// subscriptions with an EventChatDeleted. It returns ErrChatNotFound if
+	// the chat does not exist.
+	DeleteChat(chatID string) error
+	// OpenDirectChat returns the direct chat between two users, creating it
+	// with both as members if needed, and reports whether it was created. It
+	// returns ErrUserNotFound if either user does not exist and ErrSameUser
+	// if they are the same.
+	OpenDirectChat(a, b string) (*models.Chat, bool, error)
+	// ListDirectChats returns the direct chats of a user, oldest first
+	ListDirectChats(username string) []*models.Chat
+	// GetChat retrieves a chat by ID
+	GetChat(chatID string) (*models.Chat, bool)
+	// ListChats returns all chats, oldest first and by ID among chats
+	// created at the same time
+	ListChats() []*models.Chat
+	// SetRetention gives a chat its own retention policy, or makes it
+	// follow the server's again if policy is nil. It returns
+	// ErrChatNotFound if the chat does not exist.
+	SetRetention(chatID string, policy *models.RetentionPolicy) (*models.Chat, error)
+	// ApplyRetention removes the messages that have expired at now, under
+	// each chat's own policy or defaults, skipping chats under legal hold.
+	// It returns the tombstones of the removed messages.
+	ApplyRetention(defaults models.RetentionPolicy, now time.Time) ([]*models.Message, error)
+	// GetChatSummary retrieves a chat with its message count, last activity
+	// and a preview of its newest message that was not deleted
+	GetChatSummary(chatID string) (*models.ChatSummary, bool)
+	// AddMessage adds a message to a chat. It returns ErrChatNotFound if
+	// the chat does not exist.
+	AddMessage(chatID, username, content string) (*models.Message, error)
+	// Post adds a message described by msg. Like AddMessage, it returns
+	// ErrChatNotFound if the chat does not exist. Replies
+	// return ErrMessageNotFound if they answer a message not in the chat and
+	// ErrMessageDeleted if it was deleted.
+	Post(msg NewMessage) (*models.Message, error)
+	// GetMessage retrieves a message by ID
+	GetMessage(messageID string) (*models.Message, bool)
+	// EditMessage replaces the content of a message and keeps the old
+	// content as a revision. It returns ErrMessageNotFound if the message
+	// does not exist and ErrMessageDeleted if it was deleted.
+	EditMessage(messageID, content string) (*models.Message, error)
+	// DeleteMessage replaces a message with a tombstone that keeps its
+	// place in the chat and forgets its revisions. It returns
+	// ErrMessageNotFound if the message does not exist.
+	DeleteMessage(messageID string) (*models.Message, error)
+	// GetRevisions returns the earlier versions of a message, oldest
+	// first. It returns ErrMessageNotFound if the message does not exist.
+	GetRevisions(messageID string) ([]*models.Revision, error)
+	// AddReaction records a user's reaction to a message and returns the
+	// message with its updated counts. It returns ErrMessageNotFound if the
+	// message does not exist and ErrMessageDeleted if it was deleted.
+	AddReaction(messageID, username, emoji string) (*models.Message, error)
+	// RemoveReaction takes back a user's reaction to a message and returns
+	// the message with its updated counts. It returns ErrMessageNotFound or
+	// ErrReactionNotFound if either does not exist.
+	RemoveReaction(messageID, username, emoji string) (*models.Message, error)
+	// ListReactions returns the reactions to a message, oldest first. It
+	// returns ErrMessageNotFound if the message does not exist.
+	ListReactions(messageID string) ([]*models.Reaction, error)
+	// GetThread returns the message that started the thread of messageID
+	// followed by its replies, oldest first, leaving out the first message
+	// once it has expired. It returns ErrMessageNotFound if the message does
+	// not exist.
+	GetThread(messageID string) ([]*models.Message, error)
+	// Search finds the messages in the chats of query that contain a word
+	// starting with each word of its text, best matches first. Deleted
+	// messages are never found.
+	Search(query SearchQuery) []*models.SearchResult
+	// GetMessages retrieves all messages for a chat in the order they were added
+	GetMessages(chatID string) ([]*models.Message, bool)
+	// GetMessagePage retrieves a page of messages for a chat. It returns
+	// ErrChatNotFound if the chat does not exist and ErrInvalidCursor if a
+	// cursor does not belong to the chat.
+	GetMessagePage(chatID string, query PageQuery) (*MessagePage, error)
+	// Subscribe returns a subscription to the events of a chat, delivered
+	// in commit order. The caller must Close it when done.
+	Subscribe(chatID string) *Subscription
+	// Changed returns a channel that is closed the next time the chat
+	// changes. Take it before checking for new messages so that none are
+	// missed in between.
+	Changed(chatID string) <-chan struct{}
+
+	// GetMember retrieves a user's membership of a chat
+	GetMember(chatID, username string) (*models.Member, bool)
+	// ListMembers returns the members of a chat in the order they joined.
+	// It returns ErrChatNotFound if the chat does not exist.
+	ListMembers(chatID string) ([]*models.Member, error)
+	// SetMember adds a registered user to a chat or changes their role. It
+	// returns ErrChatNotFound or ErrUserNotFound if either does not exist.
+	SetMember(chatID, username, role string) (*models.Member, error)
+	// RemoveMember removes a user from a chat. It returns ErrChatNotFound or
+	// ErrMemberNotFound if either does not exist.
+	RemoveMember(chatID, username string) error
+
+	// CreateInvite stores an invite to a chat identified by the hash of its
+	// token, assigning its ID and creation time. It returns ErrChatNotFound
+	// if the chat does not exist.
+	CreateInvite(tokenHash string, invite models.Invite) (*models.Invite, error)
+	// RedeemInvite adds username to the chat of an invite as a member. It
+	// returns ErrInviteNotFound for unknown tokens, ErrInviteExpired for
+	// invites past their expiry or use limit and ErrUserNotFound if the
+	// user does not exist. Existing members keep their role.
+	RedeemInvite(tokenHash, username string) (*models.Member, error)
+
+	// CreateUser registers a user with an already hashed password. It
+	// returns ErrUsernameTaken if the name is in use, ignoring case.
+	CreateUser(username, passwordHash string) (*models.User, error)
+	// GetAccount retrieves a user and its password hash by username
+	GetAccount(username string) (*Account, bool)
+	// CreateSession starts a session identified by the hash of its token.
+	// It returns ErrUserNotFound if the user does not exist.
+	CreateSession(tokenHash, username string, ttl time.Duration) (*Session, error)
+	// GetSession retrieves a session by the hash of its token. Expired
+	// sessions are not returned.
+	GetSession(tokenHash string) (*Session, bool)
+
+	// CreateAPIKey stores an API key identified by the hash of its token,
+	// assigning its ID and creation time. It returns ErrBotNameTaken if the
+	// bot name is a username or is used by another owner's keys, and
+	// ErrAPIKeyQuota if the owner already has MaxAPIKeys unrevoked keys.
+	CreateAPIKey(tokenHash string, key models.APIKey) (*models.APIKey, error)
+	// GetAPIKey retrieves an API key by the hash of its token. Revoked keys
+	// are not returned.
+	GetAPIKey(tokenHash string) (*models.APIKey, bool)
+	// ListAPIKeys returns the API keys created by owner, oldest first
+	ListAPIKeys(owner string) []*models.APIKey
+	// RevokeAPIKey revokes one of owner's API keys. It returns
+	// ErrAPIKeyNotFound if owner has no key with that ID.
+	RevokeAPIKey(owner, keyID string) (*models.APIKey, error)
+}
+
+// Ensure Storage implements Store
+var _ Store = (*Storage)(nil)
@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"sync"
+
+	"chat-app/internal/models"
+)
+
+// SubscriptionBuffer is how many events a subscription may fall behind
+// before it is dropped
+const SubscriptionBuffer = 64
+
+// broker fans out events to the live subscriptions of each chat and wakes
+// up anyone waiting for a chat to change
+type broker struct {
+	mu     sync.Mutex
+	subs   map[string]map[*Subscription]struct{} // chatID -> subscriptions
+	notify map[string]chan struct{}              // chatID -> closed on next change
+}
+
+// Subscription receives the events of one chat as they are committed.
+// Events is closed when the subscription is closed, either by Close, because
+// it fell more than SubscriptionBuffer events behind or because the chat was
+// deleted.
+type Subscription struct {
+	broker *broker
+	chatID string
+	events chan *models.Event
+	// overflowed is set when the subscription was dropped for being too slow
+	overflowed bool
+}
+
+func newBroker() *broker {
+	return &broker{
+		subs:   make(map[string]map[*Subscription]struct{}),
+		notify: make(map[string]chan struct{}),
+	}
+}
+
+func (b *broker) subscribe(chatID string) *Subscription {
+	sub := &Subscription{
+		broker: b,
+		chatID: chatID,
+		events: make(chan *models.Event, SubscriptionBuffer),
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.subs[chatID] == nil {
+		b.subs[chatID] = make(map[*Subscription]struct{})
+	}
+	b.subs[chatID][sub] = struct{}{}
+	return sub
+}
+
+func (b *broker) removeLocked(sub *Subscription) {
+	subs, ok := b.subs[sub.chatID]
+	if !ok {
+		return
+	}
+	if _, ok := subs[sub]; !ok {
+		return
+	}
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(b.subs, sub.chatID)
+	}
+	close(sub.events)
+}
+
+// changed returns a channel that is closed the next time chatID changes
+func (b *broker) changed(chatID string) <-chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch, ok := b.notify[chatID]
+	if !ok {
+		ch = make(chan struct{})
+		b.notify[chatID] = ch
+	}
+	return ch
+}
+
+// publish wakes up waiters on a chat and delivers an event to every
+// subscription of it without blocking. Subscriptions whose buffer is full
+// are dropped so that one slow consumer cannot stall writers.
+func (b *broker) publish(chatID string, event *models.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if ch, ok := b.notify[chatID]; ok {
+		close(ch)
+		delete(b.notify, chatID)
+	}
+
+	for sub := range b.subs[chatID] {
+		select {
+		case sub.events <- event:
+		default:
+			sub.overflowed = true
+			b.removeLocked(sub)
+		}
+	}
+}
+
+// end delivers a last event to every subscription of a chat and closes them.
+// Subscriptions whose buffer is full are closed as overflowed instead.
+func (b *broker) end(chatID string, event *models.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if ch, ok := b.notify[chatID]; ok {
+		close(ch)
+		delete(b.notify, chatID)
+	}
+
+	for sub := range b.subs[chatID] {
+		select {
+		case sub.events <- event:
+		default:
+			sub.overflowed = true
+		}
+		b.removeLocked(sub)
+	}
+}
+
+// Events returns the channel the subscription's events are delivered on
+func (sub *Subscription) Events() <-chan *models.Event {
+	return sub.events
+}
+
+// Overflowed reports whether the subscription was dropped for falling
+// behind. It is only meaningful once Events has been closed.
+func (sub *Subscription) Overflowed() bool {
+	return sub.overflowed
+}
+
+// Close stops delivery and closes Events. It is safe to call more than once.
+func (sub *Subscription) Close() {
+	sub.broker.mu.Lock()
+	defer sub.broker.mu.Unlock()
+	sub.broker.removeLocked(sub)
+}
+
+// Subscribe returns a subscription to the events of a chat. Events are
+// delivered in commit order. The caller must Close the subscription when
+// done with it.
+func (s *Storage) Subscribe(chatID string) *Subscription {
+	return s.broker.subscribe(chatID)
+}
+
+// Changed returns a channel that is closed the next time a chat changes.
+// Callers that wait for new messages should take the channel before
+// checking for them, so that a message committed in between still wakes
+// them up.
+func (s *Storage) Changed(chatID string) <-chan struct{} {
+	return s.broker.changed(chatID)
+}
+
+// publish notifies subscribers of a committed record. The caller must hold
+// the write lock, which keeps events in commit order.
+func (s *Storage) publish(rec *record) {
+	switch rec.Op {
+	case opDeleteChat:
+		s.broker.end(rec.Chat.ID, &models.Event{Type: models.EventChatDeleted, Chat: rec.Chat})
+	case opAddMessage:
+		s.broker.publish(rec.Message.ChatID, &models.Event{Type: models.EventMessage, Message: rec.Message})
+	case opEditMessage:
+		s.broker.publish(rec.Message.ChatID, &models.Event{Type: models.EventEdit, Message: rec.Message})
+	case opDeleteMessage:
+		s.broker.publish(rec.Message.ChatID, &models.Event{Type: models.EventDelete, Message: rec.Message})
+	case opExpireMessages:
+		// One event per pass, however many messages expired, so that a
+		// large pass cannot overflow subscriptions
+		s.broker.publish(rec.Chat.ID, &models.Event{Type: models.EventExpire, Messages: rec.Expired})
+	case opAddReaction, opRemoveReaction:
+		msg := s.byID[rec.Reaction.MessageID]
+		s.broker.publish(msg.ChatID, &models.Event{Type: models.EventReaction, Message: msg})
+	}
+}
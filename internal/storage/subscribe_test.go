@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"chat-app/internal/models"
+)
+
+func TestSubscribe(t *testing.T) {
+	t.Run("DropsSlowSubscription", func(t *testing.T) {
+		s := NewStorage()
+		chat, _ := s.CreateChat("Busy Chat")
+		slow := s.Subscribe(chat.ID)
+		fast := s.Subscribe(chat.ID)
+		defer fast.Close()
+
+		for i := 0; i < SubscriptionBuffer+1; i++ {
+			if _, err := s.AddMessage(chat.ID, "user", "message"); err != nil {
+				t.Fatalf("Failed to add message: %v", err)
+			}
+			<-fast.Events()
+		}
+
+		count := 0
+		for range slow.Events() {
+			count++
+		}
+		if count != SubscriptionBuffer {
+			t.Errorf("Expected %d buffered events before the drop, got %d", SubscriptionBuffer, count)
+		}
+		if !slow.Overflowed() {
+			t.Error("Expected slow subscription to be marked as overflowed")
+		}
+
+		// Closing a dropped subscription must be harmless
+		slow.Close()
+	})
+
+	t.Run("BatchesExpiry", func(t *testing.T) {
+		s := NewStorage()
+		chat, _ := s.CreateChat("Expiring Chat")
+		for i := 0; i < 2*SubscriptionBuffer+1; i++ {
+			if _, err := s.AddMessage(chat.ID, "user", "message"); err != nil {
+				t.Fatalf("Failed to add message: %v", err)
+			}
+		}
+		sub := s.Subscribe(chat.ID)
+		defer sub.Close()
+
+		if _, err := s.ApplyRetention(models.RetentionPolicy{MaxMessages: 1}, time.Now()); err != nil {
+			t.Fatalf("Failed to apply retention: %v", err)
+		}
+		select {
+		case event, ok := <-sub.Events():
+			if !ok {
+				t.Fatalf("Expected the subscription to survive, overflowed: %v", sub.Overflowed())
+			}
+			if event.Type != models.EventExpire || len(event.Messages) != 2*SubscriptionBuffer {
+				t.Errorf("Expected one expire event for %d messages, got %s with %d", 2*SubscriptionBuffer, event.Type, len(event.Messages))
+			}
+		default:
+			t.Fatal("Expected an expire event")
+		}
+		select {
+		case event := <-sub.Events():
+			t.Errorf("Expected a single event, also got %+v", event)
+		default:
+		}
+	})
+
+	t.Run("DeleteChatEndsSubscriptions", func(t *testing.T) {
+		s := NewStorage()
+		chat, _ := s.CreateChat("Doomed Chat")
+		sub := s.Subscribe(chat.ID)
+		defer sub.Close()
+
+		if err := s.DeleteChat(chat.ID); err != nil {
+			t.Fatalf("Failed to delete chat: %v", err)
+		}
+		if event, ok := <-sub.Events(); !ok || event.Type != models.EventChatDeleted || event.Chat.ID != chat.ID {
+			t.Errorf("Expected a chat_deleted event, got %+v", event)
+		}
+		if _, ok := <-sub.Events(); ok {
+			t.Error("Expected events channel to be closed")
+		}
+		if sub.Overflowed() {
+			t.Error("Expected the subscription not to be marked as overflowed")
+		}
+	})
+
+	t.Run("CloseClosesEvents", func(t *testing.T) {
+		s := NewStorage()
+		sub := s.Subscribe("chat")
+		sub.Close()
+		sub.Close()
+
+		if _, ok := <-sub.Events(); ok {
+			t.Error("Expected events channel to be closed")
+		}
+		if sub.Overflowed() {
+			t.Error("Expected closed subscription not to be marked as overflowed")
+		}
+	})
+
+	t.Run("FailedWriteIsNotPublished", func(t *testing.T) {
+		s := openTestFileStore(t, t.TempDir())
+		chat, _ := s.CreateChat("Closed Chat")
+		sub := s.Subscribe(chat.ID)
+		defer sub.Close()
+
+		if err := s.Close(); err != nil {
+			t.Fatalf("Failed to close store: %v", err)
+		}
+		if _, err := s.AddMessage(chat.ID, "user", "lost"); err == nil {
+			t.Fatal("Expected write to a closed store to fail")
+		}
+
+		select {
+		case event := <-sub.Events():
+			t.Errorf("Expected no event for a failed write, got %+v", event)
+		default:
+		}
+	})
+}
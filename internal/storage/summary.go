@@ -0,0 +1,49 @@
+package storage
+
+import "chat-app/internal/models"
+
+// PreviewLength is the number of characters of a message shown in a chat
+// summary's preview
+const PreviewLength = 80
+
+// GetChatSummary returns a chat with its message count, last activity and a
+// preview of its newest message
+func (s *Storage) GetChatSummary(chatID string) (*models.ChatSummary, bool) {
+	defer s.rlock("GetChatSummary")()
+
+	chat, exists := s.chats[chatID]
+	if !exists {
+		return nil, false
+	}
+	summary := &models.ChatSummary{
+		Chat:           chat,
+		MessageCount:   s.live[chatID],
+		LastActivityAt: chat.CreatedAt,
+	}
+	messages := s.messages[chatID]
+	if n := len(messages); n > 0 {
+		summary.LastActivityAt = messages[n-1].Timestamp
+	}
+	// Only the deleted messages at the end are skipped to find the newest
+	for i := len(messages) - 1; i >= 0; i-- {
+		if msg := messages[i]; msg.DeletedAt == nil {
+			summary.LastMessage = &models.MessagePreview{
+				ID:        msg.ID,
+				Username:  msg.Username,
+				Content:   preview(msg.Content),
+				Timestamp: msg.Timestamp,
+			}
+			break
+		}
+	}
+	return summary, true
+}
+
+// preview shortens content to PreviewLength characters, marking the cut
+func preview(content string) string {
+	runes := []rune(content)
+	if len(runes) <= PreviewLength {
+		return content
+	}
+	return string(runes[:PreviewLength-1]) + "…"
+}
@@ -0,0 +1,45 @@
+package storage
+
+import "chat-app/internal/models"
+
+// threadOf returns the ID of the thread msg belongs to, which for the
+// message starting a thread is its own
+func threadOf(msg *models.Message) string {
+	if msg.ThreadID != "" {
+		return msg.ThreadID
+	}
+	return msg.ID
+}
+
+// GetThread returns the message that started the thread of messageID
+// followed by its replies, oldest first. Once the first message has expired
+// the thread starts with its oldest remaining reply.
+func (s *Storage) GetThread(messageID string) ([]*models.Message, error) {
+	defer s.rlock("GetThread")()
+
+	msg, exists := s.byID[messageID]
+	if !exists {
+		return nil, ErrMessageNotFound
+	}
+	threadID := threadOf(msg)
+	replies := s.threads[threadID]
+	thread := make([]*models.Message, 0, len(replies)+1)
+	if root, exists := s.byID[threadID]; exists {
+		thread = append(thread, root)
+	}
+	for _, id := range replies {
+		thread = append(thread, s.byID[id])
+	}
+	return thread, nil
+}
+
+// addReply records reply in its thread and counts it on the thread's root.
+// The caller must hold the write lock.
+func (s *Storage) addReply(reply *models.Message) {
+	s.threads[reply.ThreadID] = append(s.threads[reply.ThreadID], reply.ID)
+	if root, exists := s.byID[reply.ThreadID]; exists {
+		counted := *root
+		counted.ReplyCount++
+		s.replaceMessage(&counted)
+	}
+}
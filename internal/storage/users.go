@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"strings"
+	"time"
+
+	"chat-app/internal/models"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrUsernameTaken is returned by CreateUser when the username is in use
+	ErrUsernameTaken = newError(ErrConflict, "username already taken")
+	// ErrUserNotFound is returned when a user does not exist
+	ErrUserNotFound = newError(ErrNotFound, "user not found")
+)
+
+// Account is a registered user together with its password hash
+type Account struct {
+	User         *models.User `json:"user"`
+	PasswordHash string       `json:"password_hash"`
+}
+
+// Session is a login session. Only a hash of its bearer token is stored.
+type Session struct {
+	TokenHash string    `json:"token_hash"`
+	UserID    string    `json:"user_id"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Expired reports whether the session is no longer valid at now
+func (sess *Session) Expired(now time.Time) bool {
+	return !now.Before(sess.ExpiresAt)
+}
+
+// accountKey is the key accounts are stored under. Usernames are unique
+// regardless of case.
+func accountKey(username string) string {
+	return strings.ToLower(username)
+}
+
+// CreateUser registers a user with an already hashed password
+func (s *Storage) CreateUser(username, passwordHash string) (*models.User, error) {
+	defer s.lock("CreateUser")()
+
+	if _, exists := s.accounts[accountKey(username)]; exists {
+		return nil, ErrUsernameTaken
+	}
+	if _, exists := s.botOwners[accountKey(username)]; exists {
+		return nil, ErrUsernameTaken
+	}
+
+	account := &Account{
+		User: &models.User{
+			ID:        uuid.New().String(),
+			Username:  username,
+			CreatedAt: time.Now(),
+		},
+		PasswordHash: passwordHash,
+	}
+	if err := s.commit(&record{Op: opCreateUser, Account: account}); err != nil {
+		return nil, err
+	}
+	return account.User, nil
+}
+
+// GetAccount retrieves a user and its password hash by username, ignoring
+// case
+func (s *Storage) GetAccount(username string) (*Account, bool) {
+	defer s.rlock("GetAccount")()
+
+	account, exists := s.accounts[accountKey(username)]
+	return account, exists
+}
+
+// CreateSession starts a session for a user that lasts ttl. The token itself
+// is never stored; tokenHash identifies the session.
+func (s *Storage) CreateSession(tokenHash, username string, ttl time.Duration) (*Session, error) {
+	defer s.lock("CreateSession")()
+
+	account, exists := s.accounts[accountKey(username)]
+	if !exists {
+		return nil, ErrUserNotFound
+	}
+
+	now := time.Now()
+	session := &Session{
+		TokenHash: tokenHash,
+		UserID:    account.User.ID,
+		Username:  account.User.Username,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	if err := s.commit(&record{Op: opCreateSession, Session: session}); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// GetSession retrieves an unexpired session by the hash of its token
+func (s *Storage) GetSession(tokenHash string) (*Session, bool) {
+	defer s.rlock("GetSession")()
+
+	session, exists := s.sessions[tokenHash]
+	if !exists || session.Expired(time.Now()) {
+		return nil, false
+	}
+	return session, true
+}
@@ -0,0 +1,424 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SyncPolicy controls when the write-ahead log is fsynced to disk
+type SyncPolicy string
+
+const (
+	// SyncAlways fsyncs after every record
+	SyncAlways SyncPolicy = "always"
+	// SyncInterval fsyncs periodically in the background
+	SyncInterval SyncPolicy = "interval"
+	// SyncNever leaves flushing to the operating system
+	SyncNever SyncPolicy = "never"
+)
+
+// ParseSyncPolicy parses a sync policy name
+func ParseSyncPolicy(name string) (SyncPolicy, error) {
+	switch p := SyncPolicy(name); p {
+	case SyncAlways, SyncInterval, SyncNever:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown fsync policy %q", name)
+	}
+}
+
+// frameHeaderSize is the size of the length and checksum prefix of each frame
+const frameHeaderSize = 8
+
+// maxFrameSize bounds the payload length accepted when reading so that a
+// corrupted header cannot trigger a huge allocation
+const maxFrameSize = 1 << 30
+
+// Log segments are named by their sequence number
+const (
+	segmentPrefix = "wal-"
+	segmentSuffix = ".log"
+)
+
+var (
+	// ErrClosed is returned when writing to a closed store
+	ErrClosed = errors.New("storage: store is closed")
+	// ErrCorrupt is returned when a log contains a damaged record that is
+	// not the final one
+	ErrCorrupt = errors.New("storage: corrupt log record")
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// encodeFrame frames a payload as a 4-byte little-endian length, a 4-byte
+// CRC-32C of the payload and the payload itself. Log records and snapshots
+// share this framing.
+func encodeFrame(payload []byte) []byte {
+	buf := make([]byte, frameHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buf[4:8], crc32.Checksum(payload, crcTable))
+	copy(buf[frameHeaderSize:], payload)
+	return buf
+}
+
+// readFrame reads one frame, returning its payload along with the number of
+// bytes the frame occupies. A frame cut short by the end of the input is
+// reported as io.ErrUnexpectedEOF.
+func readFrame(r io.Reader, header []byte) ([]byte, int64, error) {
+	if _, err := io.ReadFull(r, header); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+
+	length := binary.LittleEndian.Uint32(header[0:4])
+	checksum := binary.LittleEndian.Uint32(header[4:8])
+	n := int64(frameHeaderSize) + int64(length)
+	if length > maxFrameSize {
+		return nil, n, fmt.Errorf("frame length %d exceeds limit", length)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, n, err
+	}
+	if crc32.Checksum(payload, crcTable) != checksum {
+		return nil, n, errors.New("checksum mismatch")
+	}
+	return payload, n, nil
+}
+
+// segmentFile is what the log needs of a segment file; tests substitute it
+// to make writes fail
+type segmentFile interface {
+	io.WriteCloser
+	Sync() error
+	Truncate(size int64) error
+}
+
+// wal is an append-only log of records split into numbered segment files.
+// Records are only ever appended to the newest segment; rotate starts a new
+// one so that older segments can be dropped once a snapshot covers them.
+type wal struct {
+	mu      sync.Mutex
+	dir     string
+	segment uint64
+	file    segmentFile
+	size    int64 // bytes in the current segment
+	policy  SyncPolicy
+	dirty   bool
+	closed  bool
+	// failed is set when a torn write could not be undone; the segment
+	// then takes no more records
+	failed error
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// openWAL opens segment in dir for appending. The caller must have replayed
+// (and if necessary truncated) the segment first.
+func openWAL(dir string, segment uint64, policy SyncPolicy, interval time.Duration) (*wal, error) {
+	file, err := openSegment(dir, segment)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("stat log segment: %w", err)
+	}
+
+	w := &wal{dir: dir, segment: segment, file: file, size: info.Size(), policy: policy}
+	if policy == SyncInterval {
+		if interval <= 0 {
+			interval = time.Second
+		}
+		w.stop = make(chan struct{})
+		w.done = make(chan struct{})
+		go w.syncLoop(interval)
+	}
+	return w, nil
+}
+
+func openSegment(dir string, segment uint64) (*os.File, error) {
+	path := filepath.Join(dir, segmentName(segment))
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open log segment: %w", err)
+	}
+	return file, nil
+}
+
+// append writes a record to the current segment and syncs it according to
+// the policy
+func (w *wal) append(rec *record) error {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("encode record: %w", err)
+	}
+	buf := encodeFrame(payload)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ErrClosed
+	}
+	if w.failed != nil {
+		return w.failed
+	}
+	n, err := w.file.Write(buf)
+	if err == nil && n < len(buf) {
+		err = io.ErrShortWrite
+	}
+	if err != nil {
+		// Records appended after a partial frame could not be replayed,
+		// so it is cut off again
+		if n > 0 {
+			if terr := w.file.Truncate(w.size); terr != nil {
+				w.failed = fmt.Errorf("log unusable after failed write: %w", terr)
+			}
+		}
+		return fmt.Errorf("write record: %w", err)
+	}
+	w.size += int64(n)
+	if w.policy == SyncAlways {
+		if err := w.file.Sync(); err != nil {
+			return fmt.Errorf("sync log: %w", err)
+		}
+		return nil
+	}
+	w.dirty = true
+	return nil
+}
+
+// rotate syncs and closes the current segment and starts a new one. It
+// returns the sequence number of the new segment; every record appended
+// before the call lives in an older segment.
+func (w *wal) rotate() (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, ErrClosed
+	}
+	if w.failed != nil {
+		return 0, w.failed
+	}
+	if err := w.file.Sync(); err != nil {
+		return 0, fmt.Errorf("sync log: %w", err)
+	}
+	file, err := openSegment(w.dir, w.segment+1)
+	if err != nil {
+		return 0, err
+	}
+	if err := w.file.Close(); err != nil {
+		_ = file.Close()
+		return 0, fmt.Errorf("close log segment: %w", err)
+	}
+	w.file = file
+	w.segment++
+	w.size = 0
+	w.dirty = false
+	return w.segment, nil
+}
+
+// pending reports whether the current segment holds any records
+func (w *wal) pending() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.size > 0
+}
+
+// sync flushes pending writes to disk
+func (w *wal) sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.syncLocked()
+}
+
+func (w *wal) syncLocked() error {
+	if w.closed || !w.dirty {
+		return nil
+	}
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("sync log: %w", err)
+	}
+	w.dirty = false
+	return nil
+}
+
+func (w *wal) syncLoop(interval time.Duration) {
+	defer close(w.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			// A failed background sync is retried on the next tick; the
+			// final sync in close reports persistent errors.
+			_ = w.sync()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// close syncs and closes the log
+func (w *wal) close() error {
+	if w.stop != nil {
+		close(w.stop)
+		<-w.done
+		w.stop = nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	w.dirty = true
+	syncErr := w.syncLocked()
+	w.closed = true
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("close log: %w", err)
+	}
+	return syncErr
+}
+
+func segmentName(seq uint64) string {
+	return numberedName(segmentPrefix, seq, segmentSuffix)
+}
+
+// listSegments returns the sequence numbers of the log segments in dir in
+// ascending order
+func listSegments(dir string) ([]uint64, error) {
+	return listNumbered(dir, segmentPrefix, segmentSuffix)
+}
+
+func numberedName(prefix string, seq uint64, suffix string) string {
+	return fmt.Sprintf("%s%016d%s", prefix, seq, suffix)
+}
+
+// listNumbered returns the sequence numbers of files in dir named by
+// numberedName with the given prefix and suffix, in ascending order
+func listNumbered(dir, prefix, suffix string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read data directory: %w", err)
+	}
+
+	var seqs []uint64
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, prefix), suffix), 10, 64)
+		if err != nil || name != numberedName(prefix, seq, suffix) {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	return seqs, nil
+}
+
+// ReplayStats describes what was recovered from disk on startup
+type ReplayStats struct {
+	// Snapshot is the sequence number of the snapshot the state was loaded
+	// from, or zero if there was none
+	Snapshot uint64
+	// Segments is the number of log segments replayed on top of the snapshot
+	Segments int
+	// Records is the number of log records replayed
+	Records int
+	// TruncatedBytes is the size of an incomplete final record that was
+	// discarded, if any
+	TruncatedBytes int64
+}
+
+// replaySegment reads every record in the segment at path and passes it to
+// fn. When tail is set, a damaged final record, as left behind by a crash
+// mid-write, is truncated away. Any other damage is reported as ErrCorrupt.
+func replaySegment(path string, tail bool, stats *ReplayStats, fn func(rec *record) error) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("open log segment: %w", err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("stat log segment: %w", err)
+	}
+	size := info.Size()
+
+	reader := bufio.NewReader(file)
+	var offset int64
+	header := make([]byte, frameHeaderSize)
+	for offset < size {
+		rec, n, err := readRecord(reader, header)
+		if err != nil {
+			// Only the final record of the newest segment may be damaged.
+			// Anything that reaches the end of that file is a torn write
+			// and is dropped.
+			if tail && (errors.Is(err, io.ErrUnexpectedEOF) || offset+n >= size) {
+				if err := file.Truncate(offset); err != nil {
+					return fmt.Errorf("truncate log segment: %w", err)
+				}
+				if err := file.Sync(); err != nil {
+					return fmt.Errorf("sync log segment: %w", err)
+				}
+				stats.TruncatedBytes = size - offset
+				return nil
+			}
+			return fmt.Errorf("%w in %s at offset %d: %v", ErrCorrupt, filepath.Base(path), offset, err)
+		}
+
+		if err := fn(rec); err != nil {
+			return fmt.Errorf("replay record in %s at offset %d: %w", filepath.Base(path), offset, err)
+		}
+		offset += n
+		stats.Records++
+	}
+	return nil
+}
+
+// readRecord reads and decodes one framed record
+func readRecord(r io.Reader, header []byte) (*record, int64, error) {
+	payload, n, err := readFrame(r, header)
+	if err != nil {
+		return nil, n, err
+	}
+
+	var rec record
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return nil, n, fmt.Errorf("decode record: %w", err)
+	}
+	if err := rec.validate(); err != nil {
+		return nil, n, err
+	}
+	return &rec, n, nil
+}